@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/config"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"go.uber.org/zap"
+)
+
+// trcHandshakeTimeout is the time, within which TRC connecting to SRRS must complete the handshake.
+const trcHandshakeTimeout = 10 * time.Second
+
+// listenedTRC is a TRC, which connects to SRRS.
+type listenedTRC struct {
+	logger       *zap.Logger
+	pool         *trcapi.Pool
+	opts         []trcapi.Option
+	pingInterval time.Duration
+}
+
+// trcListener accepts connections from TRCs and registers them in the pools of the TRCs by fleet ID.
+// This allows TRCs, which cannot be dialed, e.g. because they are behind NAT, to connect to SRRS.
+type trcListener struct {
+	logger *zap.Logger
+
+	// trcs are the TRCs by fleet ID. The TRC with empty fleet ID accepts any fleet ID.
+	trcs map[string]listenedTRC
+
+	listener  net.Listener
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// newTRCListener returns a new *trcListener without any TRCs.
+func newTRCListener(logger *zap.Logger) *trcListener {
+	return &trcListener{
+		logger:  logger,
+		trcs:    make(map[string]listenedTRC),
+		closeCh: make(chan struct{}),
+	}
+}
+
+// add registers the TRC configured by c, connections of which are set in pool.
+// add must not be called after listen.
+// c must specify a secret, as validated by config.SRRS.Validate.
+func (l *trcListener) add(logger *zap.Logger, c config.TRCClient, pool *trcapi.Pool) {
+	l.trcs[c.FleetID] = listenedTRC{
+		logger:       logger,
+		pool:         pool,
+		opts:         trcOptions(c),
+		pingInterval: c.PingInterval,
+	}
+}
+
+// lookup returns the TRC identified by fleetID.
+func (l *trcListener) lookup(fleetID string) (listenedTRC, bool) {
+	if t, ok := l.trcs[fleetID]; ok {
+		return t, true
+	}
+	t, ok := l.trcs[""]
+	return t, ok
+}
+
+// listen starts accepting connections from TRCs on TCP address addr.
+// TLS is used on the connections if tlsConf is not nil.
+func (l *trcListener) listen(addr string, tlsConf *tls.Config) error {
+	lst, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for TRC connections")
+	}
+	if tlsConf != nil {
+		lst = tls.NewListener(lst, tlsConf)
+	}
+	l.listener = lst
+
+	go func() {
+		logger := l.logger.With(zap.String("trc_listen_addr", addr))
+		logger.Info("Accepting connections from TRC...")
+		for {
+			netConn, err := lst.Accept()
+			if err != nil {
+				select {
+				case <-l.closeCh:
+					return
+				default:
+				}
+
+				if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+					logger.Warn("Failed to accept TRC connection, retrying...", zap.Error(err))
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+				logger.Error("Failed to accept TRC connection", zap.Error(err))
+				return
+			}
+			go l.handle(netConn)
+		}
+	}()
+	return nil
+}
+
+// handle performs the handshake on netConn and replaces the connection of the TRC
+// identified by the fleet ID sent in the handshake.
+func (l *trcListener) handle(netConn net.Conn) {
+	logger := l.logger.With(zap.Stringer("trc_addr", netConn.RemoteAddr()))
+	logger.Debug("Accepted TRC connection")
+
+	if err := netConn.SetDeadline(time.Now().Add(trcHandshakeTimeout)); err != nil {
+		logger.Error("Failed to set handshake deadline", zap.Error(err))
+		netConn.Close()
+		return
+	}
+
+	var trc listenedTRC
+	trcConn, err := trcapi.Connect(trcapi.DefaultVersion, netConn, netConn, trcapi.WithFleetOptions(func(fleetID string) ([]trcapi.Option, error) {
+		t, ok := l.lookup(fleetID)
+		if !ok {
+			return nil, errors.New("unknown fleet")
+		}
+		trc = t
+		return t.opts, nil
+	}))
+	if err != nil {
+		logger.Warn("Failed to establish connection to TRC", zap.Error(err))
+		netConn.Close()
+		return
+	}
+
+	logger = trc.logger.With(
+		zap.Stringer("trc_addr", netConn.RemoteAddr()),
+		zap.String("fleet_id", trcConn.FleetID()),
+	)
+	closeFunc := closeTRC(logger, trcConn, netConn)
+
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		logger.Error("Failed to clear handshake deadline", zap.Error(err))
+		closeFunc()
+		return
+	}
+
+	if err := trc.pool.Set(trcConn, closeFunc); err != nil {
+		logger.Warn("Failed to register TRC connection", zap.Error(err))
+		return
+	}
+	logger.Info("TRC connected")
+
+	go pingTRC(logger, trcConn, trc.pingInterval)
+}
+
+// Close stops accepting connections from TRCs.
+// Established connections are closed by the pools.
+func (l *trcListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+		if l.listener != nil {
+			err = l.listener.Close()
+		}
+	})
+	return err
+}
@@ -2,48 +2,129 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/config"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/macro"
+	"github.com/rvolosatovs/turtlitto/pkg/match"
+	"github.com/rvolosatovs/turtlitto/pkg/refbox"
+	"github.com/rvolosatovs/turtlitto/pkg/transport"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
 	"github.com/rvolosatovs/turtlitto/pkg/webapi"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-const (
-	defaultTCPAddress = ":4242" // default webserver address
-	defaultTLSAddress = ":4244" // default https server address
-	retryInterval     = 5 * time.Second
-)
-
 var (
-	debug    = flag.Bool("debug", false, "Debug mode")
-	tcpAddr  = flag.String("tcp", defaultTCPAddress, "HTTP service address")
-	tlsAddr  = flag.String("tls", defaultTLSAddress, "HTTPS service address")
-	static   = flag.String("static", "", "Path to the static assets")
-	unixSock = flag.String("unixSocket", filepath.Join(os.TempDir(), "trc.sock"), "Path to the unix socket")
-	tcpSock  = flag.String("tcpSocket", "", "Internal TCP socket address. TRC <-> SRRS communication will use this TCP socket instead of a Unix socket when set")
-	certPath = flag.String("cert", "", "Path to the authentication certificate")
-	keyPath  = flag.String("key", "", "Path to the private key of the certificate")
+	conf config.SRRS
+
+	configPath = flag.String("config", os.Getenv("SRRS_CONFIG"), "Path to the YAML configuration file. Settings can be overridden by SRRS_* environment variables and flags. The configuration is reloaded on SIGHUP")
 )
 
+func init() {
+	conf = config.DefaultSRRS()
+	registerFlags(flag.CommandLine, &conf)
+}
+
+// registerFlags registers the flags configuring SRRS on fs and binds them to c.
+func registerFlags(fs *flag.FlagSet, c *config.SRRS) {
+	fs.BoolVar(&c.Debug, "debug", c.Debug, "Debug mode")
+	fs.StringVar(&c.HTTP.TCPAddress, "tcp", c.HTTP.TCPAddress, "HTTP service address. The insecure web server is disabled if empty")
+	fs.StringVar(&c.HTTP.TLSAddress, "tls", c.HTTP.TLSAddress, "HTTPS service address")
+	fs.StringVar(&c.HTTP.Static, "static", c.HTTP.Static, "Path to the static assets")
+	fs.StringVar(&c.TRC.UnixSocket, "unixSocket", c.TRC.UnixSocket, "Path to the unix socket")
+	fs.StringVar(&c.TRC.TCPSocket, "tcpSocket", c.TRC.TCPSocket, "Internal TCP socket address. TRC <-> SRRS communication will use this TCP socket instead of a Unix socket when set")
+	fs.StringVar(&c.HTTP.Cert, "cert", c.HTTP.Cert, "Path to the authentication certificate")
+	fs.StringVar(&c.HTTP.Key, "key", c.HTTP.Key, "Path to the private key of the certificate")
+	fs.StringVar(&c.HTTP.ClientCA, "clientCA", c.HTTP.ClientCA, "Path to the PEM-encoded CA certificates. Clients of the secure web server must present a certificate signed by one of them when set. The common name of the certificate names the operator")
+	fs.StringVar(&c.Macros, "macros", c.Macros, "Path to the file, in which macros are stored. Macros are only stored in memory if empty")
+	fs.DurationVar(&c.HalfDuration, "halfDuration", c.HalfDuration, "Duration of a half of the match")
+	fs.StringVar(&c.RefBox, "refbox", c.RefBox, "TCP address of the referee box. Commands can be received from the referee box when set")
+	fs.StringVar(&c.AuditLog, "audit", c.AuditLog, "Path to the file, to which the audit log is appended. The audit log is only stored in memory if empty")
+	fs.StringVar(&c.Operators, "operators", c.Operators, "Path to the file, in which operator credentials are stored. Operators are only stored in memory if empty")
+	fs.BoolVar(&c.TRC.TokenAuth, "trcToken", c.TRC.TokenAuth, "Accept the token received from TRC for authentication")
+	fs.StringVar(&c.TRC.Secret, "trcSecret", c.TRC.Secret, "Secret shared with TRC. TRC must perform the challenge-response handshake when set")
+	fs.StringVar(&c.TRC.SerialDevice, "serialDevice", c.TRC.SerialDevice, "Path to the serial device, to which TRC is connected. TRC <-> SRRS communication will use the serial device instead of the sockets when set")
+	fs.IntVar(&c.TRC.SerialBaudRate, "serialBaudRate", c.TRC.SerialBaudRate, "Baud rate of the serial device")
+	fs.StringVar(&c.TRC.Fingerprint, "trcFingerprint", c.TRC.Fingerprint, "SHA-256 fingerprint of TRC's certificate. TRC <-> SRRS communication will use TLS when set")
+	fs.DurationVar(&c.TRC.PingInterval, "trcPingInterval", c.TRC.PingInterval, "Interval, at which TRC is pinged")
+	fs.IntVar(&c.TRC.FleetSize, "fleetSize", c.TRC.FleetSize, "Amount of turtles controlled by TRC")
+	fs.StringVar(&c.TRC.ListenAddress, "trcListen", c.TRC.ListenAddress, "TCP address, on which connections from TRC are accepted. The sockets of TRC are not dialed when set")
+	fs.StringVar(&c.TRC.ListenCert, "trcListenCert", c.TRC.ListenCert, "Path to the TLS certificate used on the TRC listen address. TLS is used on the listen address when set")
+	fs.StringVar(&c.TRC.ListenKey, "trcListenKey", c.TRC.ListenKey, "Path to the private key of the TRC listen certificate")
+	fs.StringVar(&c.TRC.WebSocketPath, "trcWebSocket", c.TRC.WebSocketPath, "Path on the web servers, on which connections from TRC via WebSocket are accepted. The sockets of TRC are not dialed when set")
+	fs.StringVar(&c.TRC.FleetID, "trcFleetID", c.TRC.FleetID, "Fleet ID, which TRC connecting to SRRS must send. Any fleet ID is accepted if empty. TRC must know the secret in either case")
+	fs.Float64Var(&c.WebAPI.RequestRate, "requestRate", c.WebAPI.RequestRate, "Amount of requests per second each session may send to TRC. Requests are not limited if not positive")
+	fs.IntVar(&c.WebAPI.RequestBurst, "requestBurst", c.WebAPI.RequestBurst, "Amount of requests each session may send to TRC at once")
+	fs.DurationVar(&c.WebAPI.DebounceInterval, "debounce", c.WebAPI.DebounceInterval, "Interval, within which identical consecutive requests of a session are ignored")
+	fs.IntVar(&c.WebAPI.AuthAttempts, "authAttempts", c.WebAPI.AuthAttempts, "Amount of failed authentication attempts, after which a client is locked out. Clients are never locked out if not positive")
+	fs.DurationVar(&c.WebAPI.AuthLockout, "authLockout", c.WebAPI.AuthLockout, "Duration of the lockout after failed authentication attempts")
+	fs.StringVar(&c.ShutdownCommand, "shutdownCommand", c.ShutdownCommand, "Command sent to TRC on shutdown. No command is sent if empty")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdownTimeout", c.ShutdownTimeout, "Time, within which in-flight requests must complete on shutdown")
+	fs.Var(&c.HTTP.AllowedOrigins, "allowedOrigins", "Comma-separated list of origins, from which cross-origin requests and WebSocket connections are accepted. * allows all origins. Only same-origin requests are accepted if empty")
+}
+
+// loadConfig loads the configuration of SRRS from the configuration file and environment.
+// flags are the values of flags set on the command line by name, which take precedence.
+func loadConfig(flags map[string]string) (config.SRRS, error) {
+	c := config.DefaultSRRS()
+
+	fs := flag.NewFlagSet("srrs", flag.ContinueOnError)
+	registerFlags(fs, &c)
+	for name, v := range flags {
+		if fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, v); err != nil {
+			return c, errors.Wrapf(err, "failed to set flag %s", name)
+		}
+	}
+
+	if err := config.Parse(fs, *configPath, config.SRRSEnvPrefix, &c); err != nil {
+		return c, err
+	}
+	if err := c.Validate(); err != nil {
+		return c, errors.Wrap(err, "invalid configuration")
+	}
+	return c, nil
+}
+
 func main() {
 	flag.Parse()
 
-	conf := zap.NewProductionConfig()
-	conf.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
-	if *debug {
-		conf = zap.NewDevelopmentConfig()
-		conf.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	flags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	var err error
+	conf, err = loadConfig(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %s\n", err)
+		os.Exit(2)
+	}
+
+	logConf := zap.NewProductionConfig()
+	logConf.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	if conf.Debug {
+		logConf = zap.NewDevelopmentConfig()
+		logConf.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
 
-	logger, err := conf.Build()
+	logger, err := logConf.Build()
 	if err != nil {
 		panic(err)
 	}
@@ -54,121 +135,186 @@ func main() {
 	if err := func() error {
 		defer logger.Sync() //nolint
 
-		pool := trcapi.NewPool(func() (*trcapi.Conn, func(), error) {
-			var netConn net.Conn
-			if *tcpSock == "" {
-				logger := logger.With(zap.String("trc_socket_unix", *unixSock))
+		var pools []*trcapi.Pool
+		defer func() {
+			for _, pool := range pools {
+				pool.Close()
+			}
+		}()
 
-				var err error
-				logger.Debug("Dialing Unix socket...")
-				netConn, err = net.Dial("unix", *unixSock)
-				if err != nil {
-					return nil, nil, errors.Wrapf(err, "Failed to connect to TRC's unix socket")
-				}
-				logger.Debug("Unix socket dial succeeded")
-			} else {
-				logger := logger.With(zap.String("trc_socket_tcp", *tcpSock))
+		macroStore, err := macro.NewStore(conf.Macros)
+		if err != nil {
+			return errors.Wrap(err, "failed to load macros")
+		}
 
-				var err error
-				logger.Debug("Dialing TCP socket...")
-				netConn, err = net.Dial("tcp", *tcpSock)
-				if err != nil {
-					return nil, nil, errors.Wrapf(err, "Failed to connect to TRC's TCP socket")
-				}
-				logger.Debug("TCP socket dial succeeded")
-			}
+		auditLog, err := audit.Open(conf.AuditLog)
+		if err != nil {
+			return errors.Wrap(err, "failed to open audit log")
+		}
+		defer auditLog.Close()
+
+		operators, err := credentials.NewStore(conf.Operators)
+		if err != nil {
+			return errors.Wrap(err, "failed to load operators")
+		}
+		if !conf.TRC.TokenAuth && len(operators.List()) == 0 {
+			logger.Warn("TRC token authentication disabled, but no operators configured")
+		}
+
+		opts := []webapi.Option{
+			webapi.WithMacroStore(macroStore),
+			webapi.WithAuditLog(auditLog),
+			webapi.WithOperators(operators),
+			webapi.WithTRCTokenAuth(conf.TRC.TokenAuth),
+		}
+		if conf.ShutdownCommand != "" {
+			opts = append(opts, webapi.WithShutdownCommand(api.Command(conf.ShutdownCommand)))
+		}
+		opts = append(opts, runtimeOptions(conf)...)
+
+		rl := &reloader{
+			logger:    logger,
+			flags:     flags,
+			conf:      conf,
+			operators: operators,
+		}
+		opts = append(opts, webapi.WithReloadFunc(rl.reload))
+
+		var trcLst *trcListener
+		if conf.TRC.Accepts() {
+			trcLst = newTRCListener(logger)
+			defer trcLst.Close()
+		}
 
-			logger.Debug("Initializing TRC protocol connection on socket...")
-			trcConn, err := trcapi.Connect(trcapi.DefaultVersion, netConn, netConn)
+		mux := http.DefaultServeMux
+
+		if len(conf.TRCs) == 0 {
+			pool, err := newPool(logger, conf.TRC)
 			if err != nil {
-				return nil, nil, errors.Wrapf(err, "Failed to establish connection to TRC")
+				return err
+			}
+			pools = append(pools, pool)
+			if trcLst != nil {
+				trcLst.add(logger, conf.TRC, pool)
 			}
-			logger.Debug("TRC protocol connection initialized")
 
-			go func() {
-				var next time.Time
-				for {
-					next = time.Now().Add(5 * time.Second)
-
-					ctx, cancel := context.WithDeadline(context.Background(), next)
-					defer cancel()
-
-					if err := trcConn.Ping(ctx); err != nil {
-						logger.Error("Failed to ping TRC",
-							zap.Error(err),
-						)
-
-						if err := trcConn.Close(); err != nil {
-							logger.Error("Failed to close TRC",
-								zap.Error(err),
-							)
-						}
-						return
-					}
-
-					select {
-					case <-trcConn.Closed():
-						return
-
-					case <-time.After(time.Until(next)):
-					}
+			opts = append(opts, webapi.WithMatchTracker(match.NewTracker(match.WithHalfDuration(conf.HalfDuration))))
+			if conf.RefBox != "" {
+				opts = append(opts, webapi.WithRefBox(refbox.New(conf.RefBox)))
+			}
+			rl.web = webapi.RegisterHandlers(pool, mux, opts...)
+		} else {
+			trcs := make([]webapi.TRC, 0, len(conf.TRCs))
+			for _, t := range conf.TRCs {
+				trcLogger := logger.With(zap.String("trc", t.Name))
+				pool, err := newPool(trcLogger, t.Client(conf.TRC))
+				if err != nil {
+					return errors.Wrapf(err, "failed to configure TRC %s", t.Name)
 				}
-			}()
-
-			return trcConn, func() {
-				logger.Debug("Closing TRC connection...")
-				if err := trcConn.Close(); err != nil {
-					logger.With(zap.Error(err)).Error("Failed to close TRC connection")
+				pools = append(pools, pool)
+				if trcLst != nil {
+					trcLst.add(trcLogger, t.Client(conf.TRC), pool)
 				}
 
-				logger.Debug("Closing socket...")
-				if err := netConn.Close(); err != nil {
-					logger.With(zap.Error(err)).Error("Failed to close socket")
+				trcOpts := []webapi.Option{
+					webapi.WithMatchTracker(match.NewTracker(match.WithHalfDuration(conf.HalfDuration))),
 				}
-			}, nil
-		})
-		defer pool.Close()
-
-		mux := http.DefaultServeMux
-
-		webapi.RegisterHandlers(pool, mux)
-		if *static != "" {
-			mux.Handle("/", http.FileServer(http.Dir(*static)))
+				if t.RefBox != "" {
+					trcOpts = append(trcOpts, webapi.WithRefBox(refbox.New(t.RefBox)))
+				}
+				trcs = append(trcs, webapi.TRC{
+					Name:    t.Name,
+					Pool:    pool,
+					Options: trcOpts,
+				})
+			}
+			logger.Info("Managing several TRCs", zap.Int("count", len(trcs)))
+			rl.web = webapi.RegisterTRCHandlers(trcs, mux, opts...)
+		}
+		if conf.HTTP.Static != "" {
+			mux.Handle("/", http.FileServer(http.Dir(conf.HTTP.Static)))
 		}
 
-		// http server
-		tcpLogger := logger.With(zap.String("listen_addr_tcp", *tcpAddr))
-		tcpSrv := &http.Server{
-			Addr:     *tcpAddr,
-			ErrorLog: zap.NewStdLog(tcpLogger),
-			Handler:  mux,
+		if conf.TRC.WebSocketPath != "" {
+			logger.Info("Accepting connections from TRC via WebSocket...", zap.String("path", conf.TRC.WebSocketPath))
+			mux.Handle(conf.TRC.WebSocketPath, transport.WebSocketHandler(nil, func(ws *transport.WebSocket) {
+				trcLst.handle(ws)
+			}))
+		}
+		if conf.TRC.ListenAddress != "" {
+			var tlsConf *tls.Config
+			if conf.TRC.ListenCert != "" {
+				cert, err := tls.LoadX509KeyPair(conf.TRC.ListenCert, conf.TRC.ListenKey)
+				if err != nil {
+					return errors.Wrap(err, "failed to load TRC listen certificate")
+				}
+				tlsConf = &tls.Config{
+					Certificates: []tls.Certificate{cert},
+				}
+				logger.Info("Using TLS for connections from TRC",
+					zap.String("fingerprint", trcapi.CertificateFingerprint(cert.Certificate[0])),
+				)
+			}
+			if err := trcLst.listen(conf.TRC.ListenAddress, tlsConf); err != nil {
+				return err
+			}
 		}
 
+		var servers []*http.Server
+
+		// http server
 		tcpErrCh := make(chan error, 1)
-		go func() {
-			tcpLogger.Info("Starting the insecure web server...")
-			if err := tcpSrv.ListenAndServe(); err != nil {
-				tcpErrCh <- errors.Wrap(err, "failed to listen")
+		if conf.HTTP.TCPAddress != "" {
+			tcpLogger := logger.With(zap.String("listen_addr_tcp", conf.HTTP.TCPAddress))
+			tcpSrv := &http.Server{
+				Addr:     conf.HTTP.TCPAddress,
+				ErrorLog: zap.NewStdLog(tcpLogger),
+				Handler:  mux,
 			}
-		}()
+			servers = append(servers, tcpSrv)
+
+			go func() {
+				tcpLogger.Info("Starting the insecure web server...")
+				if err := tcpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					tcpErrCh <- errors.Wrap(err, "failed to listen")
+				}
+			}()
+
+			if conf.HTTP.ClientCA != "" {
+				logger.Warn("Client certificates are only required by the secure web server; disable the insecure one by setting -tcp to empty string")
+			}
+		} else {
+			logger.Info("TCP address not specified; skipping insecure web server")
+		}
 
 		// https server
 		tlsErrCh := make(chan error, 1)
-		cert := *certPath
-		key := *keyPath
+		cert := conf.HTTP.Cert
+		key := conf.HTTP.Key
 		if cert != "" && key != "" {
-			tlsLogger := logger.With(zap.String("listen_addr_tcp", *tlsAddr))
+			tlsLogger := logger.With(zap.String("listen_addr_tcp", conf.HTTP.TLSAddress))
 			tlsSrv := &http.Server{
-				Addr:     *tlsAddr,
+				Addr:     conf.HTTP.TLSAddress,
 				ErrorLog: zap.NewStdLog(tlsLogger),
 				Handler:  mux,
 			}
+			servers = append(servers, tlsSrv)
+
+			certs := &certificates{}
+			if err := certs.load(cert, key, conf.HTTP.ClientCA); err != nil {
+				return err
+			}
+			tlsSrv.TLSConfig = certs.tlsConfig()
+			rl.certs = certs
+			if conf.HTTP.ClientCA != "" {
+				tlsLogger.Info("Requiring client certificates", zap.String("client_ca_path", conf.HTTP.ClientCA))
+			}
 
 			go func() {
 				tlsLogger.Info("Starting the secure web server...",
 					zap.String("certificate_path", cert), zap.String("key_path", key),
 				)
-				if err := tlsSrv.ListenAndServeTLS(cert, key); err != nil {
+				if err := tlsSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 					tlsErrCh <- errors.Wrap(err, "failed to listen")
 				}
 			}()
@@ -181,13 +327,234 @@ func main() {
 			}
 		}
 
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		go func() {
+			for range hupCh {
+				logger.Info("Received SIGHUP, reloading configuration...")
+				if err := rl.reload(); err != nil {
+					logger.Error("Failed to reload configuration", zap.Error(err))
+				}
+			}
+		}()
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
 		select {
 		case err := <-tcpErrCh:
 			return errors.Wrap(err, "TCP server failed")
 		case err := <-tlsErrCh:
 			return errors.Wrap(err, "TLS server failed")
+		case sig := <-sigCh:
+			logger.Info("Received signal, shutting down...",
+				zap.Stringer("signal", sig),
+			)
 		}
+		// A second signal terminates SRRS immediately.
+		signal.Stop(sigCh)
+
+		if trcLst != nil {
+			logger.Info("Closing the TRC listener...")
+			if err := trcLst.Close(); err != nil {
+				logger.Error("Failed to close the TRC listener", zap.Error(err))
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+		defer cancel()
+		return shutdown(ctx, logger, rl.web, servers, pools)
 	}(); err != nil {
 		logger.With(zap.Error(err)).Fatal("SRRS failed")
 	}
 }
+
+// shutdown gracefully shuts down the web API, the web servers and the connections to TRC in that order.
+// The web API rejects new requests, closes the WebSockets and sends the shutdown command to TRC,
+// the web servers wait for the remaining requests and the pool waits for the pending TRC requests.
+// The shutdown continues even if a step fails and the first error is returned.
+func shutdown(ctx context.Context, logger *zap.Logger, web *webapi.Server, servers []*http.Server, pools []*trcapi.Pool) error {
+	var errs []error
+
+	logger.Info("Shutting down the web API...")
+	if err := web.Shutdown(ctx); err != nil {
+		logger.Error("Failed to shut down the web API", zap.Error(err))
+		errs = append(errs, err)
+	}
+
+	for _, srv := range servers {
+		logger.Info("Shutting down the web server...", zap.String("addr", srv.Addr))
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error("Failed to shut down the web server", zap.String("addr", srv.Addr), zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+
+	logger.Info("Closing the connections to TRC...")
+	for _, pool := range pools {
+		if err := pool.Shutdown(ctx); err != nil {
+			logger.Error("Failed to close the connection to TRC", zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Wrap(errs[0], "failed to shut down gracefully")
+	}
+	logger.Info("Shutdown complete")
+	return nil
+}
+
+// trcOptions returns the options of connections to the TRC configured by c.
+func trcOptions(c config.TRCClient) []trcapi.Option {
+	opts := []trcapi.Option{
+		trcapi.WithFleetSize(c.FleetSize),
+	}
+	if c.Secret != "" {
+		opts = append(opts, trcapi.WithSecret([]byte(c.Secret)))
+	}
+	return opts
+}
+
+// newPool returns a new *trcapi.Pool of connections to the TRC configured by c.
+// If SRRS accepts connections from TRC, the pool does not dial TRC and connections must be set in it.
+func newPool(logger *zap.Logger, c config.TRCClient) (*trcapi.Pool, error) {
+	if c.Accepts() {
+		return trcapi.NewPool(nil), nil
+	}
+
+	var trcTLSConf *tls.Config
+	if c.Fingerprint != "" {
+		var err error
+		trcTLSConf, err = trcapi.PinnedTLSConfig(c.Fingerprint)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to configure TLS for TRC socket")
+		}
+	}
+
+	trcOpts := trcOptions(c)
+
+	return trcapi.NewPool(func() (*trcapi.Conn, func(), error) {
+		if c.SerialDevice != "" {
+			return connectSerial(logger, c, trcOpts)
+		}
+
+		var netConn net.Conn
+		if c.TCPSocket == "" {
+			logger := logger.With(zap.String("trc_socket_unix", c.UnixSocket))
+
+			var err error
+			logger.Debug("Dialing Unix socket...")
+			netConn, err = net.Dial("unix", c.UnixSocket)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "Failed to connect to TRC's unix socket")
+			}
+			logger.Debug("Unix socket dial succeeded")
+		} else {
+			logger := logger.With(zap.String("trc_socket_tcp", c.TCPSocket))
+
+			var err error
+			logger.Debug("Dialing TCP socket...")
+			netConn, err = net.Dial("tcp", c.TCPSocket)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "Failed to connect to TRC's TCP socket")
+			}
+			logger.Debug("TCP socket dial succeeded")
+		}
+
+		if trcTLSConf != nil {
+			logger.Debug("Performing TLS handshake...")
+			tlsConn := tls.Client(netConn, trcTLSConf)
+			if err := tlsConn.Handshake(); err != nil {
+				netConn.Close()
+				return nil, nil, errors.Wrap(err, "Failed to perform TLS handshake with TRC")
+			}
+			netConn = tlsConn
+		}
+
+		logger.Debug("Initializing TRC protocol connection on socket...")
+		trcConn, err := trcapi.Connect(trcapi.DefaultVersion, netConn, netConn, trcOpts...)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Failed to establish connection to TRC")
+		}
+		logger.Debug("TRC protocol connection initialized")
+
+		go pingTRC(logger, trcConn, c.PingInterval)
+
+		return trcConn, closeTRC(logger, trcConn, netConn), nil
+	}), nil
+}
+
+// connectSerial establishes the connection to TRC on the serial device configured by c.
+func connectSerial(logger *zap.Logger, c config.TRCClient, opts []trcapi.Option) (*trcapi.Conn, func(), error) {
+	logger = logger.With(zap.String("trc_serial_device", c.SerialDevice))
+
+	logger.Debug("Opening serial device...", zap.Int("baud_rate", c.SerialBaudRate))
+	serial, err := transport.OpenSerial(c.SerialDevice, c.SerialBaudRate)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to open TRC's serial device")
+	}
+
+	framed := transport.NewFramed(serial, transport.WithFrameErrorHandler(func(err *transport.FrameError) {
+		logger.Warn("Skipped corrupt bytes received from TRC", zap.Error(err))
+	}))
+
+	logger.Debug("Initializing TRC protocol connection on serial device...")
+	trcConn, err := trcapi.Connect(trcapi.DefaultVersion, framed, framed, opts...)
+	if err != nil {
+		serial.Close()
+		return nil, nil, errors.Wrapf(err, "Failed to establish connection to TRC")
+	}
+	logger.Debug("TRC protocol connection initialized")
+
+	go pingTRC(logger, trcConn, c.PingInterval)
+
+	return trcConn, closeTRC(logger, trcConn, serial), nil
+}
+
+// pingTRC pings TRC on trcConn every interval until trcConn is closed.
+// trcConn is closed if a ping fails.
+func pingTRC(logger *zap.Logger, trcConn *trcapi.Conn, interval time.Duration) {
+	var next time.Time
+	for {
+		next = time.Now().Add(interval)
+
+		ctx, cancel := context.WithDeadline(context.Background(), next)
+		err := trcConn.Ping(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("Failed to ping TRC",
+				zap.Error(err),
+			)
+
+			if err := trcConn.Close(); err != nil {
+				logger.Error("Failed to close TRC",
+					zap.Error(err),
+				)
+			}
+			return
+		}
+
+		select {
+		case <-trcConn.Closed():
+			return
+
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// closeTRC returns a function, which closes trcConn and the underlying connection c.
+func closeTRC(logger *zap.Logger, trcConn *trcapi.Conn, c io.Closer) func() {
+	return func() {
+		logger.Debug("Closing TRC connection...")
+		if err := trcConn.Close(); err != nil {
+			logger.With(zap.Error(err)).Error("Failed to close TRC connection")
+		}
+
+		logger.Debug("Closing socket...")
+		if err := c.Close(); err != nil {
+			logger.With(zap.Error(err)).Error("Failed to close socket")
+		}
+	}
+}
@@ -48,7 +48,7 @@ func init() {
 	logger = zapLogger.Sugar()
 
 	flag.Parse()
-	if *tcpSock == "" {
+	if conf.TRC.TCPSocket == "" {
 		logger.Debug("Creating Unix socket...")
 		f, err := os.Open(unixSockPath)
 		if !os.IsNotExist(err) {
@@ -67,10 +67,10 @@ func init() {
 		}
 
 	} else {
-		logger.Debugf("Listening on TCP socket on %s...", *tcpSock)
-		netLst, err = net.Listen("tcp", *tcpSock)
+		logger.Debugf("Listening on TCP socket on %s...", conf.TRC.TCPSocket)
+		netLst, err = net.Listen("tcp", conf.TRC.TCPSocket)
 		if err != nil {
-			logger.Fatalf("Failed to open TCP socket on %s: %s", *tcpSock, err)
+			logger.Fatalf("Failed to open TCP socket on %s: %s", conf.TRC.TCPSocket, err)
 		}
 	}
 }
@@ -91,7 +91,7 @@ func TestMain(m *testing.M) {
 	logger.Info("Starting SRRS in goroutine...")
 	go main()
 
-	dial := func() (net.Conn, error) { return net.DialTimeout("tcp", defaultTCPAddress, time.Second) }
+	dial := func() (net.Conn, error) { return net.DialTimeout("tcp", conf.HTTP.TCPAddress, time.Second) }
 	retries := 20
 
 	conn, err := dial()
@@ -100,7 +100,7 @@ func TestMain(m *testing.M) {
 		conn, err = dial()
 	}
 	if err != nil {
-		logger.Fatalf("Failed to connect to SRRS at %s: %s", defaultTCPAddress, err)
+		logger.Fatalf("Failed to connect to SRRS at %s: %s", conf.HTTP.TCPAddress, err)
 	}
 
 	if err := conn.Close(); err != nil {
@@ -138,7 +138,7 @@ func TestAPI(t *testing.T) {
 	go func() {
 		defer wg.Done()
 
-		req, err := http.NewRequest(http.MethodGet, "http://"+defaultTCPAddress+"/"+webapi.AuthEndpoint, nil)
+		req, err := http.NewRequest(http.MethodGet, "http://"+conf.HTTP.TCPAddress+"/"+webapi.AuthEndpoint, nil)
 		a.NoError(err)
 		req.SetBasicAuth("", handshake.Token)
 
@@ -202,7 +202,7 @@ func TestAPI(t *testing.T) {
 		t.FailNow()
 	}
 
-	wsAddr := "ws://localhost" + defaultTCPAddress + "/" + webapi.StateEndpoint
+	wsAddr := "ws://localhost" + conf.HTTP.TCPAddress + "/" + webapi.StateEndpoint
 	logger.With("addr", wsAddr).Debug("Opening a WebSocket...")
 	wsConn, _, err := websocket.DefaultDialer.Dial(wsAddr, nil)
 	if !a.NoError(err) {
@@ -256,7 +256,7 @@ func TestAPI(t *testing.T) {
 				b, err := json.Marshal(expected.Turtles)
 				a.NoError(err)
 
-				req, err := http.NewRequest(http.MethodPost, "http://"+defaultTCPAddress+"/"+webapi.TurtleEndpoint, bytes.NewReader(b))
+				req, err := http.NewRequest(http.MethodPost, "http://"+conf.HTTP.TCPAddress+"/"+webapi.TurtleEndpoint, bytes.NewReader(b))
 				a.NoError(err)
 				req.SetBasicAuth("", sessionKey)
 
@@ -328,7 +328,7 @@ func TestAPI(t *testing.T) {
 				b, err := json.Marshal(expected.Command)
 				a.NoError(err)
 
-				req, err := http.NewRequest(http.MethodPost, "http://"+defaultTCPAddress+"/"+webapi.CommandEndpoint, bytes.NewReader(b))
+				req, err := http.NewRequest(http.MethodPost, "http://"+conf.HTTP.TCPAddress+"/"+webapi.CommandEndpoint, bytes.NewReader(b))
 				a.NoError(err)
 				req.SetBasicAuth("", sessionKey)
 
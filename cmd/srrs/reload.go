@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/config"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"go.uber.org/zap"
+)
+
+// runtimeOptions returns the web API options configured by c, which can be applied at runtime.
+func runtimeOptions(c config.SRRS) []webapi.Option {
+	return []webapi.Option{
+		webapi.WithRequestRateLimit(c.WebAPI.RequestRate, c.WebAPI.RequestBurst),
+		webapi.WithDebounceInterval(c.WebAPI.DebounceInterval),
+		webapi.WithAuthLockout(c.WebAPI.AuthAttempts, c.WebAPI.AuthLockout),
+		webapi.WithTimeouts(c.WebAPI.Timeouts()),
+		webapi.WithAllowedOrigins(c.HTTP.AllowedOrigins...),
+	}
+}
+
+// loadClientCAs loads the PEM-encoded CA certificates at path.
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read client CA certificates")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no client CA certificates found")
+	}
+	return pool, nil
+}
+
+// certificates holds the TLS certificate of the secure web server
+// and the CA certificates used to verify the clients.
+// certificates can be reloaded while the server is running.
+type certificates struct {
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// load loads the certificate and key at certPath and keyPath and the client CA certificates at caPath.
+// If caPath is empty, client certificates are not required.
+// If loading fails, the previously loaded certificates are kept.
+func (c *certificates) load(certPath, keyPath, caPath string) error {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to load certificate")
+	}
+
+	var clientCAs *x509.CertPool
+	if caPath != "" {
+		clientCAs, err = loadClientCAs(caPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.clientCAs = clientCAs
+	c.mu.Unlock()
+	return nil
+}
+
+// tlsConfig returns the *tls.Config, which uses the currently loaded certificates for each handshake.
+func (c *certificates) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+
+			conf := &tls.Config{
+				Certificates: []tls.Certificate{*c.cert},
+			}
+			if c.clientCAs != nil {
+				conf.ClientCAs = c.clientCAs
+				conf.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return conf, nil
+		},
+	}
+}
+
+// revokedOperators returns the names of operators in prev, which were removed from st or whose role changed.
+func revokedOperators(prev []*credentials.Operator, st *credentials.Store) []string {
+	var names []string
+	for _, op := range prev {
+		if role, err := st.Role(op.Name); err != nil || role != op.Role {
+			names = append(names, op.Name)
+		}
+	}
+	return names
+}
+
+// reloader reloads the configuration of a running SRRS.
+type reloader struct {
+	mu sync.Mutex
+
+	logger *zap.Logger
+	flags  map[string]string
+	conf   config.SRRS
+
+	operators *credentials.Store
+	web       *webapi.Server
+
+	// certs is nil if the secure web server is not running.
+	certs *certificates
+}
+
+// reloadable reports whether the setting identified by key takes effect without a restart.
+func (r *reloader) reloadable(key string) bool {
+	switch key {
+	case "http.cert", "http.key", "http.client_ca":
+		return r.certs != nil
+	case "http.allowed_origins":
+		return true
+	}
+	return strings.HasPrefix(key, "webapi.")
+}
+
+// reload loads the configuration and applies the settings, which can be changed at runtime.
+// The connection to TRC and the WebSocket connections are preserved, except the ones of operators
+// removed from the operators file or whose role changed in it.
+// The changes are logged; changes, which require a restart, are logged as warnings.
+// If the configuration is invalid, it is not applied.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := loadConfig(r.flags)
+	if err != nil {
+		return err
+	}
+	changes := config.Diff(&r.conf, &next)
+
+	if next.Operators != r.conf.Operators {
+		return errors.New("the operators file cannot be changed without a restart")
+	}
+
+	if r.certs != nil && next.HTTP.Cert != "" && next.HTTP.Key != "" {
+		if err := r.certs.load(next.HTTP.Cert, next.HTTP.Key, next.HTTP.ClientCA); err != nil {
+			return err
+		}
+	}
+
+	prev := r.operators.List()
+	if err := r.operators.Reload(); err != nil {
+		return errors.Wrap(err, "failed to reload operators")
+	}
+	for _, name := range revokedOperators(prev, r.operators) {
+		r.logger.Info("Operator removed or changed, removing sessions", zap.String("operator", name))
+		if r.web != nil {
+			r.web.RevokeOperator(name)
+		}
+	}
+
+	if r.web != nil {
+		r.web.Apply(runtimeOptions(next)...)
+	}
+
+	for _, c := range changes {
+		logger := r.logger.With(zap.String("key", c.Key), zap.String("old", c.Old), zap.String("new", c.New))
+		if r.reloadable(c.Key) {
+			logger.Info("Configuration changed")
+		} else {
+			logger.Warn("Configuration changed, restart required to take effect")
+		}
+	}
+	if len(changes) == 0 {
+		r.logger.Info("Configuration unchanged")
+	}
+
+	r.conf = next
+	return nil
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/webclient"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const defaultAddress = "localhost:4242" // default SRRS address
+
+var (
+	debug    = flag.Bool("debug", false, "Debug mode")
+	addr     = flag.String("addr", defaultAddress, "SRRS service address")
+	secure   = flag.Bool("tls", false, "Use HTTPS and WSS to communicate with SRRS")
+	insecure = flag.Bool("insecure", false, "Skip verification of SRRS's TLS certificate")
+	token    = flag.String("token", os.Getenv("SRRS_TOKEN"), "TRC token or operator password used for authentication. Read from stdin if empty, without echo if stdin is a terminal")
+	operator = flag.String("operator", os.Getenv("USER"), "Operator name recorded in the audit log of SRRS")
+	certPath = flag.String("cert", "", "Path to the client certificate. The token is not required if set")
+	keyPath  = flag.String("key", "", "Path to the private key of the client certificate")
+	trc      = flag.String("trc", "", "Name of the TRC to control, if SRRS manages several TRCs")
+)
+
+// screen renders the state and the result of the last operator action.
+type screen struct {
+	mu     sync.Mutex
+	out    io.Writer
+	state  *api.State
+	status string
+}
+
+// formatBool formats v for display.
+func formatBool(v *bool) string {
+	switch {
+	case v == nil:
+		return "-"
+	case *v:
+		return "yes"
+	default:
+		return "no"
+	}
+}
+
+// formatUint8 formats v for display.
+func formatUint8(v *uint8) string {
+	if v == nil {
+		return "-"
+	}
+	return strconv.Itoa(int(*v))
+}
+
+// formatString formats v for display.
+func formatString(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// render redraws the whole screen.
+// render must be called with s.mu held.
+func (s *screen) render() {
+	fmt.Fprint(s.out, "\033[H\033[2J")
+
+	if s.state == nil {
+		fmt.Fprintln(s.out, "Waiting for state...")
+	} else {
+		fmt.Fprintf(s.out, "Command: %s\n\n", formatString(string(s.state.Command)))
+
+		ids := make([]string, 0, len(s.state.Turtles))
+		for id := range s.state.Turtles {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		tw := tabwriter.NewWriter(s.out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "TURTLE\tBATTERY\tROLE\tLOCALIZATION\tEMERGENCY\tIN FIELD\tTEAM")
+		for _, id := range ids {
+			ts := s.state.Turtles[id]
+			if ts == nil {
+				ts = &api.TurtleState{}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				id,
+				formatUint8(ts.BatteryVoltage),
+				formatString(string(ts.Role)),
+				formatString(string(ts.LocalizationStatus)),
+				formatUint8(ts.EmergencyStatus),
+				formatBool(ts.RobotInField),
+				formatString(string(ts.TeamColor)),
+			)
+		}
+		tw.Flush()
+	}
+
+	fmt.Fprintf(s.out, "\n%s\n> ", s.status)
+}
+
+// setState sets the displayed state and redraws the screen.
+func (s *screen) setState(st *api.State) {
+	s.mu.Lock()
+	s.state = st
+	s.render()
+	s.mu.Unlock()
+}
+
+// setStatus sets the status line and redraws the screen.
+func (s *screen) setStatus(format string, args ...interface{}) {
+	s.mu.Lock()
+	s.status = fmt.Sprintf(format, args...)
+	s.render()
+	s.mu.Unlock()
+}
+
+const usage = `Commands:
+  <command>                           send a TRC command, e.g. "start", "kick_off_cyan" or "free_kick_ours"
+  turtle <id> <field>=<value> ...     edit state of turtle <id>, e.g. "turtle 1 role=goalkeeper robotinfield=true"
+  control request|accept|deny|release request control, hand it over to the requester, deny the request or release it
+  help                                show this message
+  quit                                exit`
+
+// parseTurtleState parses fields of form <field>=<value> into *api.TurtleState.
+// Field names are the JSON names of api.TurtleState fields.
+func parseTurtleState(fields []string) (*api.TurtleState, error) {
+	m := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf("expected <field>=<value>, got %s", f)
+		}
+
+		var v interface{}
+		if b, err := strconv.ParseBool(kv[1]); err == nil {
+			v = b
+		} else if n, err := strconv.ParseUint(kv[1], 10, 8); err == nil {
+			v = n
+		} else {
+			v = kv[1]
+		}
+		m[kv[0]] = v
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	ts := &api.TurtleState{}
+	if err := dec.Decode(ts); err != nil {
+		return nil, errors.Wrap(err, "invalid turtle state")
+	}
+	if err := ts.Validate(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// readToken reads the token from in, if stdin is not a terminal.
+// Otherwise, the token is read from the terminal without echo.
+func readToken(in *bufio.Scanner) (string, error) {
+	fmt.Print("Token: ")
+
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		b, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read token")
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	if !in.Scan() {
+		return "", errors.New("failed to read token")
+	}
+	return strings.TrimSpace(in.Text()), nil
+}
+
+// execute executes a single line of operator input.
+// execute returns false if the client should exit.
+func execute(c *webclient.Client, s *screen, line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		s.setStatus("")
+		return true
+	}
+
+	switch fields[0] {
+	case "quit", "exit":
+		return false
+
+	case "help":
+		s.setStatus(usage)
+
+	case "turtle":
+		if len(fields) < 3 {
+			s.setStatus("Usage: turtle <id> <field>=<value> ...")
+			return true
+		}
+
+		ts, err := parseTurtleState(fields[2:])
+		if err != nil {
+			s.setStatus("Error: %s", err)
+			return true
+		}
+
+		if err := c.SetTurtles(map[string]*api.TurtleState{
+			fields[1]: ts,
+		}); err != nil {
+			s.setStatus("Error: %s", err)
+			return true
+		}
+		s.setStatus("Updated turtle %s", fields[1])
+
+	case "control":
+		var f func() error
+		if len(fields) == 2 {
+			f = map[string]func() error{
+				"request": c.RequestControl,
+				"accept":  c.AcceptControl,
+				"deny":    c.DenyControl,
+				"release": c.ReleaseControl,
+			}[fields[1]]
+		}
+		if f == nil {
+			s.setStatus("Usage: control request|accept|deny|release")
+			return true
+		}
+
+		if err := f(); err != nil {
+			s.setStatus("Error: %s", err)
+			return true
+		}
+		s.setStatus("Control %s succeeded", fields[1])
+
+	default:
+		cmd := api.Command(fields[0])
+		if err := cmd.Validate(); err != nil && !cmd.IsRelative() {
+			s.setStatus("Error: %s (type \"help\" for usage)", err)
+			return true
+		}
+
+		if err := c.SendCommand(cmd); err != nil {
+			s.setStatus("Error: %s", err)
+			return true
+		}
+		s.setStatus("Sent command %s", cmd)
+	}
+	return true
+}
+
+func main() {
+	flag.Parse()
+
+	conf := zap.NewProductionConfig()
+	conf.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	conf.Level.SetLevel(zap.WarnLevel)
+	if *debug {
+		conf = zap.NewDevelopmentConfig()
+		conf.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	conf.OutputPaths = []string{"stderr"}
+
+	logger, err := conf.Build()
+	if err != nil {
+		panic(err)
+	}
+
+	zap.RedirectStdLog(logger)
+	zap.ReplaceGlobals(logger)
+
+	if err := func() error {
+		defer logger.Sync() //nolint
+
+		scheme := "http"
+		if *secure {
+			scheme = "https"
+		}
+
+		tlsConf := &tls.Config{
+			InsecureSkipVerify: *insecure,
+		}
+		if *certPath != "" {
+			cert, err := tls.LoadX509KeyPair(*certPath, *keyPath)
+			if err != nil {
+				return errors.Wrap(err, "failed to load client certificate")
+			}
+			tlsConf.Certificates = []tls.Certificate{cert}
+		}
+		opts := []webclient.Option{
+			webclient.WithHTTPClient(&http.Client{
+				Timeout: webclient.DefaultTimeout,
+				Transport: &http.Transport{
+					TLSClientConfig: tlsConf,
+				},
+			}),
+			webclient.WithDialer(&websocket.Dialer{
+				HandshakeTimeout: webclient.DefaultTimeout,
+				TLSClientConfig:  tlsConf,
+			}),
+			webclient.WithOperator(*operator),
+		}
+		if *trc != "" {
+			opts = append(opts, webclient.WithTRC(*trc))
+		}
+
+		c, err := webclient.New(scheme+"://"+*addr, opts...)
+		if err != nil {
+			return err
+		}
+
+		in := bufio.NewScanner(os.Stdin)
+
+		tok := *token
+		if tok == "" && *certPath == "" {
+			if tok, err = readToken(in); err != nil {
+				return err
+			}
+		}
+
+		logger.Debug("Authenticating...", zap.String("addr", *addr))
+		if err := c.Authenticate(tok); err != nil {
+			return err
+		}
+
+		s := &screen{
+			out:    os.Stdout,
+			status: `Type "help" for usage`,
+		}
+		s.setStatus(s.status)
+
+		stCh, err := c.WatchState(context.Background())
+		if err != nil {
+			return err
+		}
+
+		lineCh := make(chan string)
+		go func() {
+			for in.Scan() {
+				lineCh <- in.Text()
+			}
+			close(lineCh)
+		}()
+
+		for {
+			select {
+			case st := <-stCh:
+				s.setState(st)
+
+			case line, ok := <-lineCh:
+				if !ok || !execute(c, s, line) {
+					return nil
+				}
+			}
+		}
+	}(); err != nil {
+		logger.With(zap.Error(err)).Fatal("SRRSCTL failed")
+	}
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"math/rand"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"github.com/rvolosatovs/turtlitto/pkg/api"
 	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/rvolosatovs/turtlitto/pkg/config"
+	"github.com/rvolosatovs/turtlitto/pkg/transport"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
 	"go.uber.org/zap"
@@ -20,23 +27,51 @@ import (
 )
 
 var (
-	debug    = flag.Bool("debug", false, "Debug mode")
-	unixSock = flag.String("unixSocket", DefaultUnixSocket, "Path to the unix socket")
-	tcpSock  = flag.String("tcpSocket", DefaultTCPSocket, "Service address of tcp socket. TCP will be used instead of a Unix socket when this is set")
-	silent   = flag.Bool("silent", false, "Disables automatic sending of random state updates")
+	conf = config.DefaultTRCD(DefaultUnixSocket, DefaultTCPSocket)
+
+	configPath = flag.String("config", os.Getenv("TRCD_CONFIG"), "Path to the YAML configuration file. Settings can be overridden by TRCD_* environment variables and flags")
 )
 
+func init() {
+	flag.BoolVar(&conf.Debug, "debug", conf.Debug, "Debug mode")
+	flag.StringVar(&conf.UnixSocket, "unixSocket", conf.UnixSocket, "Path to the unix socket")
+	flag.StringVar(&conf.TCPSocket, "tcpSocket", conf.TCPSocket, "Service address of tcp socket. TCP will be used instead of a Unix socket when this is set")
+	flag.BoolVar(&conf.Silent, "silent", conf.Silent, "Disables automatic sending of random state updates")
+	flag.StringVar(&conf.Cert, "cert", conf.Cert, "Path to the TLS certificate. TLS is used on the socket when set")
+	flag.StringVar(&conf.Key, "key", conf.Key, "Path to the private key of the certificate")
+	flag.StringVar(&conf.Secret, "secret", conf.Secret, "Secret shared with SRRS. The challenge-response handshake is performed when set")
+	flag.IntVar(&conf.FleetSize, "fleetSize", conf.FleetSize, "Amount of simulated turtles")
+	flag.DurationVar(&conf.StateInterval, "stateInterval", conf.StateInterval, "Minimum interval, at which random state updates are sent")
+	flag.DurationVar(&conf.PingInterval, "pingInterval", conf.PingInterval, "Minimum interval, at which SRRS is pinged")
+	flag.DurationVar(&conf.ShutdownTimeout, "shutdownTimeout", conf.ShutdownTimeout, "Time, within which connections must be closed on shutdown")
+	flag.StringVar(&conf.SRRSAddress, "srrs", conf.SRRSAddress, "TCP address or WebSocket URL(ws:// or wss://) of SRRS accepting connections from TRC. SRRS is dialed instead of listening on the socket when set")
+	flag.StringVar(&conf.SRRSFingerprint, "srrsFingerprint", conf.SRRSFingerprint, "SHA-256 fingerprint of SRRS's certificate. TLS is used on the connection to the TCP address of SRRS when set")
+	flag.StringVar(&conf.FleetID, "fleetID", conf.FleetID, "Fleet ID sent to SRRS in the handshake")
+	flag.DurationVar(&conf.RedialInterval, "redialInterval", conf.RedialInterval, "Interval, at which SRRS is redialed")
+}
+
+// handshakeTimeout is the time, within which the challenge-response handshake must complete.
+const handshakeTimeout = 10 * time.Second
+
 func main() {
 	flag.Parse()
+	if err := config.Parse(flag.CommandLine, *configPath, config.TRCDEnvPrefix, &conf); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %s\n", err)
+		os.Exit(2)
+	}
+	if err := conf.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %s\n", err)
+		os.Exit(2)
+	}
 
-	conf := zap.NewProductionConfig()
-	conf.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
-	if *debug {
-		conf = zap.NewDevelopmentConfig()
-		conf.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	logConf := zap.NewProductionConfig()
+	logConf.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	if conf.Debug {
+		logConf = zap.NewDevelopmentConfig()
+		logConf.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
 
-	logger, err := conf.Build()
+	logger, err := logConf.Build()
 	if err != nil {
 		panic(err)
 	}
@@ -48,182 +83,388 @@ func main() {
 		defer logger.Sync() //nolint
 
 		var netLst net.Listener
+		var srrsTLS *tls.Config
 		switch {
-		case *unixSock != "" && *tcpSock != "":
-			return errors.New("At most one of tcpSocket and unixSocket must be specified")
+		case conf.SRRSAddress != "":
+			logger.Info("Dialing SRRS instead of listening",
+				zap.String("srrs_addr", conf.SRRSAddress),
+				zap.String("fleet_id", conf.FleetID),
+			)
+			if conf.SRRSFingerprint != "" {
+				srrsTLS, err = trcapi.PinnedTLSConfig(conf.SRRSFingerprint)
+				if err != nil {
+					return err
+				}
+			}
 
-		case *unixSock != "":
-			logger := logger.With(zap.String("path", *unixSock))
+		case conf.UnixSocket != "":
+			logger := logger.With(zap.String("path", conf.UnixSocket))
 
 			logger.Info("Listening on Unix socket...")
-			netLst, err = net.Listen("unix", *unixSock)
+			netLst, err = net.Listen("unix", conf.UnixSocket)
 			if err != nil {
 				return errors.Wrap(err, "failed to listen on Unix socket")
 			}
 
-		case *tcpSock != "":
-			logger := logger.With(zap.String("addr", *tcpSock))
+		case conf.TCPSocket != "":
+			logger := logger.With(zap.String("addr", conf.TCPSocket))
 
 			logger.Info("Listening on TCP socket...")
-			netLst, err = net.Listen("tcp", *tcpSock)
+			netLst, err = net.Listen("tcp", conf.TCPSocket)
 			if err != nil {
 				return errors.Wrap(err, "failed to listen on TCP socket")
 			}
 		}
 
-		defer netLst.Close()
+		if netLst != nil && conf.Cert != "" {
+			cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+			if err != nil {
+				return errors.Wrap(err, "failed to load certificate")
+			}
+			netLst = tls.NewListener(netLst, &tls.Config{
+				Certificates: []tls.Certificate{cert},
+			})
+			logger.Info("Using TLS",
+				zap.String("fingerprint", trcapi.CertificateFingerprint(cert.Certificate[0])),
+			)
+		}
 
-		closeCh := make(chan struct{})
+		if netLst != nil {
+			defer netLst.Close()
+		}
 
-		go func() {
-			for {
-				sockConn, err := netLst.Accept()
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
-				select {
-				case <-closeCh:
-					if sockConn != nil {
-						err = sockConn.Close()
-						if err != nil {
-							logger.With(zap.Error(err)).Error("Failed to close connection")
-						}
-					}
-					return
+		closeCh := make(chan struct{})
 
-				default:
+		// connsMu guards conns and the registration of connections in connWG with respect to closeCh.
+		connsMu := &sync.Mutex{}
+		conns := make(map[net.Conn]struct{})
+		connWG := &sync.WaitGroup{}
+
+		// track registers sockConn, unless TRCD is closed.
+		// track reports whether sockConn was registered, if it was not, sockConn is closed.
+		track := func(sockConn net.Conn) bool {
+			connsMu.Lock()
+			defer connsMu.Unlock()
+
+			select {
+			case <-closeCh:
+				if err := sockConn.Close(); err != nil {
+					logger.With(zap.Error(err)).Error("Failed to close connection")
 				}
+				return false
+			default:
+			}
+			conns[sockConn] = struct{}{}
+			connWG.Add(1)
+			return true
+		}
+
+		// handle serves the tracked connection sockConn until it is closed or TRCD is closed.
+		handle := func(sockConn net.Conn) {
+			defer connWG.Done()
+			defer func() {
+				connsMu.Lock()
+				delete(conns, sockConn)
+				connsMu.Unlock()
+			}()
+			defer sockConn.Close()
+
+			logger := logger.With(zap.Stringer("addr", sockConn.RemoteAddr()))
+
+			logger.Info("Connection established")
+
+			hs := &api.Handshake{
+				Version: trcapi.DefaultVersion,
+				Token:   "test",
+			}
+			handleHandshake := trctest.DefaultHandshakeHandler
 
+			// hsDoneCh is closed once the challenge-response handshake is completed.
+			hsDoneCh := make(chan struct{})
+			if conf.Secret != "" {
+				ch, err := trctest.NewChallengeHandshake([]byte(conf.Secret))
 				if err != nil {
-					logger.Error("Failed to accept connection",
+					logger.Error("Failed to initialize challenge-response handshake",
 						zap.Error(err),
 					)
-					continue
+					return
+				}
+				hs = ch.Request()
+				handleHandshake = func(msg *api.Message) (*api.Message, error) {
+					resp, err := ch.Handle(msg)
+					if err != nil {
+						return nil, err
+					}
+					// The response is sent here to ensure it is sent before hsDoneCh is closed.
+					if err := json.NewEncoder(sockConn).Encode(resp); err != nil {
+						return nil, errors.Wrap(err, "failed to send challenge response")
+					}
+					logger.Info("Challenge-response handshake completed",
+						zap.String("token", ch.Token()),
+					)
+					close(hsDoneCh)
+					return nil, nil
 				}
+			}
+			hs.FleetID = conf.FleetID
+
+			trcConn := trctest.Connect(sockConn, sockConn,
+				trctest.WithHandler(api.MessageTypeState, func(msg *api.Message) (*api.Message, error) {
+					logger.With(zap.Any("state", msg)).Info("Received state")
+
+					reply, err := trctest.DefaultStateHandler(msg)
+					logger.With(zap.Any("reply", reply)).Debug("Sending reply...")
+					return reply, err
+				}),
+
+				trctest.WithHandler(api.MessageTypePing, func(msg *api.Message) (*api.Message, error) {
+					logger.Debug("Received ping")
+					return trctest.DefaultPingHandler(msg)
+				}),
+
+				trctest.WithHandler(api.MessageTypeHandshake, func(msg *api.Message) (*api.Message, error) {
+					logger.Debug("Received handshake")
+					return handleHandshake(msg)
+				}),
+			)
+			defer trcConn.Close()
+
+			// errCh is closed once the connection fails.
+			errCh := make(chan struct{})
+			go func() {
+				defer close(errCh)
+				for err := range trcConn.Errors() {
+					logger.Error("Internal TRCD error",
+						zap.Error(err),
+					)
+					return
+				}
+			}()
 
-				go func() {
-					defer sockConn.Close()
+			if err := trcConn.SendHandshake(hs); err != nil {
+				logger.Error("Failed to send handshake",
+					zap.Error(err),
+				)
+				return
+			}
+			logger.Info("Sent handshake",
+				zap.Reflect("handshake", hs),
+			)
 
-					logger := logger.With(zap.Stringer("addr", sockConn.RemoteAddr()))
+			if conf.Secret != "" {
+				// SRRS expects the challenge response before any other message.
+				select {
+				case <-hsDoneCh:
+				case <-errCh:
+					return
+				case <-closeCh:
+					return
+				case <-time.After(handshakeTimeout):
+					logger.Error("Timed out waiting for challenge-response handshake to complete")
+					return
+				}
+			}
 
-					logger.Info("Connection accepted")
+			st := apitest.RandomFleetState(conf.FleetSize)
+			if err := trcConn.SendState(st); err != nil {
+				logger.Error("Failed to send initial state",
+					zap.Error(err),
+				)
+				return
+			}
+			logger.Info("Sent initial state",
+				zap.Reflect("state", st),
+			)
 
-					trcConn := trctest.Connect(sockConn, sockConn,
-						trctest.WithHandler(api.MessageTypeState, func(msg *api.Message) (*api.Message, error) {
-							logger.With(zap.Any("state", msg)).Info("Received state")
+			if conf.Silent {
+				select {
+				case <-closeCh:
+				case <-errCh:
+				}
+				return
+			}
 
-							reply, err := trctest.DefaultStateHandler(msg)
-							logger.With(zap.Any("reply", reply)).Debug("Sending reply...")
-							return reply, err
-						}),
+			wg := &sync.WaitGroup{}
+			wg.Add(2)
 
-						trctest.WithHandler(api.MessageTypePing, func(msg *api.Message) (*api.Message, error) {
-							logger.Debug("Received ping")
-							return trctest.DefaultPingHandler(msg)
-						}),
+			go func() {
+				defer wg.Done()
 
-						trctest.WithHandler(api.MessageTypeHandshake, func(msg *api.Message) (*api.Message, error) {
-							logger.Debug("Received handshake")
-							return trctest.DefaultPingHandler(msg)
-						}),
-					)
-					defer trcConn.Close()
+				for {
+					select {
+					case <-time.After(conf.StateInterval + time.Millisecond*time.Duration(rand.Intn(7000))):
+						st := apitest.RandomFleetState(conf.FleetSize)
+						if err := trcConn.SendState(st); err != nil {
+							logger.Error("Failed to send state",
+								zap.Error(err),
+							)
+							return
+						}
+						logger.Info("Sent state",
+							zap.Reflect("state", st),
+						)
+
+					case <-closeCh:
+						logger.Debug("TRCD closed, stopping state-sending goroutine")
+						return
+					}
+				}
+			}()
 
-					go func() {
-						for err := range trcConn.Errors() {
-							logger.Error("Internal TRCD error",
+			go func() {
+				defer wg.Done()
+
+				for {
+					select {
+					case <-time.After(conf.PingInterval + time.Millisecond*time.Duration(rand.Intn(3000))):
+						if err := trcConn.Ping(); err != nil {
+							logger.Error("Failed to send ping",
 								zap.Error(err),
 							)
 							return
 						}
-					}()
+						logger.Info("Sent ping")
 
-					hs := &api.Handshake{
-						Version: trcapi.DefaultVersion,
-						Token:   "test",
+					case <-closeCh:
+						logger.Debug("TRCD closed, stopping ping-sending goroutine")
+						return
 					}
-					if err := trcConn.SendHandshake(hs); err != nil {
-						logger.Error("Failed to send handshake",
+				}
+			}()
+
+			wg.Wait()
+		}
+
+		if netLst != nil {
+			go func() {
+				for {
+					sockConn, err := netLst.Accept()
+					if err != nil {
+						select {
+						case <-closeCh:
+							return
+						default:
+						}
+
+						logger.Error("Failed to accept connection",
 							zap.Error(err),
 						)
+						continue
+					}
+					if !track(sockConn) {
 						return
 					}
-					logger.Info("Sent handshake",
-						zap.Reflect("handshake", hs),
-					)
-
-					st := apitest.RandomState()
-					if err := trcConn.SendState(st); err != nil {
-						logger.Error("Failed to send initial state",
+					go handle(sockConn)
+				}
+			}()
+		} else {
+			go func() {
+				for {
+					logger.Debug("Dialing SRRS...")
+					sockConn, err := dialSRRS(conf.SRRSAddress, srrsTLS, conf.RedialInterval)
+					if err != nil {
+						logger.Warn("Failed to dial SRRS",
 							zap.Error(err),
 						)
-						return
+					} else {
+						if !track(sockConn) {
+							return
+						}
+						handle(sockConn)
 					}
-					logger.Info("Sent initial state",
-						zap.Reflect("state", st),
-					)
 
-					if *silent {
-						<-closeCh
+					select {
+					case <-closeCh:
 						return
+					case <-time.After(conf.RedialInterval):
 					}
+				}
+			}()
+		}
 
-					wg := &sync.WaitGroup{}
-					wg.Add(2)
-
-					go func() {
-						defer wg.Done()
-
-						for {
-							select {
-							case <-time.After(10*time.Second + time.Millisecond*time.Duration(rand.Intn(7000))):
-								st := apitest.RandomState()
-								if err := trcConn.SendState(st); err != nil {
-									logger.Error("Failed to send state",
-										zap.Error(err),
-									)
-									return
-								}
-								logger.Info("Sent state",
-									zap.Reflect("state", st),
-								)
-
-							case <-closeCh:
-								logger.Debug("TRCD closed, stopping state-sending goroutine")
-								return
-							}
-						}
-					}()
-
-					go func() {
-						defer wg.Done()
-
-						for {
-							select {
-							case <-time.After(time.Second + time.Millisecond*time.Duration(rand.Intn(3000))):
-								if err := trcConn.Ping(); err != nil {
-									logger.Error("Failed to send ping",
-										zap.Error(err),
-									)
-									return
-								}
-								logger.Info("Sent ping")
-
-							case <-closeCh:
-								logger.Debug("TRCD closed, stopping ping-sending goroutine")
-								return
-							}
-						}
-					}()
+		if conf.Secret != "" {
+			logger.Info("Token is derived from the challenge-response handshake and logged on every connection")
+		} else {
+			printToken()
+		}
+
+		sig := <-c
+		logger.Info("Received signal, shutting down...",
+			zap.Stringer("signal", sig),
+		)
+		// A second signal terminates TRCD immediately.
+		signal.Stop(c)
+
+		connsMu.Lock()
+		close(closeCh)
+		connsMu.Unlock()
 
-					wg.Wait()
-				}()
+		if netLst != nil {
+			if err := netLst.Close(); err != nil {
+				logger.Error("Failed to close listener", zap.Error(err))
 			}
+		}
+
+		doneCh := make(chan struct{})
+		go func() {
+			connWG.Wait()
+			close(doneCh)
 		}()
 
-		fmt.Println(`********************************************************************************
+		select {
+		case <-doneCh:
+			logger.Info("All connections closed")
+			return nil
+
+		case <-time.After(conf.ShutdownTimeout):
+		}
+
+		connsMu.Lock()
+		logger.Warn("Connections did not close in time, closing forcibly...",
+			zap.Int("count", len(conns)),
+		)
+		for sockConn := range conns {
+			if err := sockConn.Close(); err != nil {
+				logger.Error("Failed to close connection", zap.Error(err))
+			}
+		}
+		connsMu.Unlock()
+		<-doneCh
+		return nil
+	}(); err != nil {
+		logger.With(zap.Error(err)).Fatal("TRCD failed")
+	}
+}
+
+// dialSRRS dials SRRS at addr, which is either a TCP address or a WebSocket URL, within timeout.
+// TLS configured by tlsConf is used on TCP addresses and wss:// URLs, if tlsConf is not nil.
+func dialSRRS(addr string, tlsConf *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://") {
+		ws, err := transport.DialWebSocket(&websocket.Dialer{
+			HandshakeTimeout: timeout,
+			TLSClientConfig:  tlsConf,
+		}, addr, nil)
+		if err != nil {
+			return nil, err
+		}
+		return ws, nil
+	}
+	if tlsConf != nil {
+		return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConf)
+	}
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+// printToken announces the plaintext token.
+func printToken() {
+	fmt.Println(`********************************************************************************
                                         TOKEN INCOMING...
 ********************************************************************************`)
-		time.Sleep(time.Second)
-		fmt.Println(`********************************************************************************
+	time.Sleep(time.Second)
+	fmt.Println(`********************************************************************************
 ********************************************************************************
                               STILL COMING...
 ********************************************************************************
@@ -242,40 +483,24 @@ func main() {
 ********************************************************************************
 ********************************************************************************
 ********************************************************************************`)
-		time.Sleep(time.Second)
-		fmt.Println(`********************************************************************************
+	time.Sleep(time.Second)
+	fmt.Println(`********************************************************************************
 ********************************************************************************
                               STILL COMING...
 ********************************************************************************`)
-		time.Sleep(time.Second)
-		fmt.Println(`********************************************************************************
+	time.Sleep(time.Second)
+	fmt.Println(`********************************************************************************
 ****************BEHOLD........UNLEASHING THE TOKEN.....*************************
 ********************************************************************************
 ********************************************************************************
 ********************************************************************************
 ********************************************************************************`)
-		time.Sleep(time.Second)
-		fmt.Println(`********************************************************************************
+	time.Sleep(time.Second)
+	fmt.Println(`********************************************************************************
 ********************************************************************************
                   TOKEN IS:                test
 ********************************************************************************
 ********************************************************************************
 ********************************************************************************
 		`)
-
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-
-		select {
-		case <-closeCh:
-		case sig := <-c:
-			close(closeCh)
-			logger.Info("Received signal, exiting...",
-				zap.Stringer("signal", sig),
-			)
-		}
-		return nil
-	}(); err != nil {
-		logger.With(zap.Error(err)).Fatal("TRCD failed")
-	}
 }
@@ -117,6 +117,11 @@ class App extends Component {
 
   onConnectionMessage(event) {
     const data = JSON.parse(event.data);
+    if (data.seq !== undefined) {
+      // Ignore states older than the last one received
+      if (this.lastSeq !== undefined && data.seq < this.lastSeq) return;
+      this.lastSeq = data.seq;
+    }
     if (data.turtles !== undefined)
       this.setState(prev => {
         const turtleChanges = Object.keys(data.turtles).reduce((acc, id) => {
@@ -136,6 +141,7 @@ class App extends Component {
   }
 
   onConnectionOpen(event) {
+    this.lastSeq = undefined;
     this.connection.send(JSON.stringify(this.state.session));
     this.setState({ connectionStatus: connectionTypes.CONNECTED });
   }
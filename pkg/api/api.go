@@ -188,12 +188,23 @@ const (
 	MessageTypeState     MessageType = "state"
 	MessageTypePing      MessageType = "ping"
 	MessageTypeHandshake MessageType = "handshake"
+	// MessageTypeGetState is the type of requests of the complete state.
+	// The payload of the response is the complete State.
+	MessageTypeGetState MessageType = "get_state"
 )
 
 // Handshake represents the handshake message payload.
+// If Challenge is set, the challenge-response handshake is performed:
+// TRC sends its Challenge, SRRS responds with the Response to it and its own Challenge
+// and TRC completes the handshake with the Response to the challenge of SRRS.
+// The token is derived from the challenges in that case.
 type Handshake struct {
-	Version semver.Version `json:"version"`
-	Token   string         `json:"token"`
+	Version   semver.Version `json:"version"`
+	Token     string         `json:"token"`
+	Challenge string         `json:"challenge,omitempty"`
+	Response  string         `json:"response,omitempty"`
+	// FleetID identifies the fleet of turtles controlled by TRC.
+	FleetID string `json:"fleet_id,omitempty"`
 }
 
 // State represents the state of the TRC.
@@ -208,6 +219,10 @@ type Message struct {
 	MessageID ulid.ULID       `json:"message_id"`
 	ParentID  *ulid.ULID      `json:"parent_id,omitempty"`
 	Payload   json.RawMessage `json:"payload,omitempty"`
+	// Seq is the sequence number of state messages sent by TRC, which is incremented by one
+	// on every state message. The response to a state request carries the sequence number of
+	// the last state message sent. Zero means the message is not numbered.
+	Seq uint64 `json:"seq,omitempty"`
 }
 
 // NewMessage returns a new Message.
@@ -231,8 +231,14 @@ func RandomTurtleState() *api.TurtleState {
 
 // RandomTurtleStateMap returns a random valid *api.TurtleState map.
 func RandomTurtleStateMap() map[string]*api.TurtleState {
+	return RandomFleetTurtleStateMap(MaxTurtles)
+}
+
+// RandomFleetTurtleStateMap returns a random valid *api.TurtleState map
+// of a fleet of n turtles.
+func RandomFleetTurtleStateMap(n int) map[string]*api.TurtleState {
 	ret := map[string]*api.TurtleState{}
-	perm := rand.Perm(MaxTurtles)[:rand.Intn(MaxTurtles+1)]
+	perm := rand.Perm(n)[:rand.Intn(n+1)]
 	for _, i := range perm {
 		ret[strconv.Itoa(i+1)] = RandomTurtleState()
 	}
@@ -241,11 +247,16 @@ func RandomTurtleStateMap() map[string]*api.TurtleState {
 
 // RandomState returns a random valid *api.State.
 func RandomState() *api.State {
+	return RandomFleetState(MaxTurtles)
+}
+
+// RandomFleetState returns a random valid *api.State of a fleet of n turtles.
+func RandomFleetState(n int) *api.State {
 	var pld api.State
 	if rand.Intn(2) == 0 {
 		pld.Command = RandomCommand()
 	}
-	pld.Turtles = RandomTurtleStateMap()
+	pld.Turtles = RandomFleetTurtleStateMap(n)
 	return &pld
 }
 
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Field identifies a field of State.
+// Fields of TurtleState are identified by their JSON names.
+type Field string
+
+const (
+	FieldCommand                Field = "command"
+	FieldVisionStatus           Field = "visionstatus"
+	FieldMotionStatus           Field = "motionstatus"
+	FieldWorldmodelStatus       Field = "worldmodelstatus"
+	FieldAppmanStatus           Field = "appmanstatus"
+	FieldRestartCountMotion     Field = "restartcountmotion"
+	FieldRestartCountVision     Field = "restartcountvision"
+	FieldRestartCountWorldmodel Field = "restartcountworldmodel"
+	FieldBallFound              Field = "ballfound"
+	FieldLocalizationStatus     Field = "localizationstatus"
+	FieldCPB                    Field = "cpb"
+	FieldBatteryVoltage         Field = "batteryvoltage"
+	FieldEmergencyStatus        Field = "emergencystatus"
+	FieldRole                   Field = "role"
+	FieldRefBoxRole             Field = "refboxrole"
+	FieldRobotInField           Field = "robotinfield"
+	FieldRobotEmergencyButton   Field = "robotembutton"
+	FieldHomeGoal               Field = "homegoal"
+	FieldTeamColor              Field = "teamcolor"
+	FieldActiveDevPC            Field = "activedevpc"
+	FieldKinect1State           Field = "kinect1_state"
+	FieldKinect2State           Field = "kinect2_state"
+)
+
+// turtleFields are the Fields of TurtleState in the order of declaration.
+var turtleFields = func() []Field {
+	rt := reflect.TypeOf(TurtleState{})
+	fields := make([]Field, rt.NumField())
+	for i := range fields {
+		fields[i] = Field(strings.Split(rt.Field(i).Tag.Get("json"), ",")[0])
+	}
+	return fields
+}()
+
+// Change represents a change of a field of State.
+type Change struct {
+	// TurtleID is the ID of the turtle, the state of which changed, or empty if the command changed.
+	TurtleID string `json:"turtle_id,omitempty"`
+	// Field is the field, which changed.
+	Field Field `json:"field"`
+	// Old is the value of the field before the change, e.g. uint8 for FieldBatteryVoltage, or nil if it was not set.
+	Old interface{} `json:"old,omitempty"`
+	// New is the value of the field after the change or nil if it is not set.
+	New interface{} `json:"new,omitempty"`
+}
+
+// fieldValue returns the value of fv, which is dereferenced if it is a pointer, or nil if it is not set.
+// Pointers are set if they are not nil, other values are set if they are not zero.
+func fieldValue(fv reflect.Value) interface{} {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return nil
+		}
+		return fv.Elem().Interface()
+	}
+	if reflect.DeepEqual(fv.Interface(), reflect.Zero(fv.Type()).Interface()) {
+		return nil
+	}
+	return fv.Interface()
+}
+
+// Diff returns the changes of fields, which turn the state from into the state to.
+// A nil State and turtles missing in a State are treated as having no fields set.
+// The change of the command, if any, comes first, followed by the changes of turtles
+// ordered by turtle ID and the changes of fields of each turtle in the order of declaration in TurtleState.
+func Diff(from, to *State) []Change {
+	if from == nil {
+		from = &State{}
+	}
+	if to == nil {
+		to = &State{}
+	}
+
+	var changes []Change
+	if from.Command != to.Command {
+		changes = append(changes, Change{
+			Field: FieldCommand,
+			Old:   fieldValue(reflect.ValueOf(from.Command)),
+			New:   fieldValue(reflect.ValueOf(to.Command)),
+		})
+	}
+
+	ids := make([]string, 0, len(from.Turtles)+len(to.Turtles))
+	for id := range from.Turtles {
+		ids = append(ids, id)
+	}
+	for id := range to.Turtles {
+		if _, ok := from.Turtles[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		ov := reflect.ValueOf(TurtleState{})
+		if ts := from.Turtles[id]; ts != nil {
+			ov = reflect.ValueOf(*ts)
+		}
+		nv := reflect.ValueOf(TurtleState{})
+		if ts := to.Turtles[id]; ts != nil {
+			nv = reflect.ValueOf(*ts)
+		}
+
+		for i, f := range turtleFields {
+			o := fieldValue(ov.Field(i))
+			n := fieldValue(nv.Field(i))
+			if reflect.DeepEqual(o, n) {
+				continue
+			}
+			changes = append(changes, Change{
+				TurtleID: id,
+				Field:    f,
+				Old:      o,
+				New:      n,
+			})
+		}
+	}
+	return changes
+}
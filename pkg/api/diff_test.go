@@ -0,0 +1,76 @@
+package api_test
+
+import (
+	"testing"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Diff() in diff.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestDiff(t *testing.T) {
+	for _, tc := range []struct {
+		Name     string
+		From     *State
+		To       *State
+		Expected []Change
+	}{
+		{
+			Name: "nil",
+		},
+		{
+			Name: "equal",
+			From: &State{
+				Command: CommandStop,
+				Turtles: map[string]*TurtleState{
+					"1": {BatteryVoltage: apitest.Uint8Ptr(42)},
+				},
+			},
+			To: &State{
+				Command: CommandStop,
+				Turtles: map[string]*TurtleState{
+					"1": {BatteryVoltage: apitest.Uint8Ptr(42)},
+				},
+			},
+		},
+		{
+			Name: "command",
+			From: &State{Command: CommandStop},
+			To:   &State{Command: CommandStart},
+			Expected: []Change{
+				{Field: FieldCommand, Old: CommandStop, New: CommandStart},
+			},
+		},
+		{
+			Name: "fields",
+			From: &State{
+				Turtles: map[string]*TurtleState{
+					"1": {BatteryVoltage: apitest.Uint8Ptr(42), Role: RoleGoalkeeper},
+					"2": {HomeGoal: HomeGoalBlue},
+				},
+			},
+			To: &State{
+				Command: CommandGoIn,
+				Turtles: map[string]*TurtleState{
+					"1": {BatteryVoltage: apitest.Uint8Ptr(41), Role: RoleGoalkeeper, RobotInField: apitest.BoolPtr(false)},
+					"3": {TeamColor: TeamColorCyan},
+				},
+			},
+			Expected: []Change{
+				{Field: FieldCommand, New: CommandGoIn},
+				{TurtleID: "1", Field: FieldBatteryVoltage, Old: uint8(42), New: uint8(41)},
+				{TurtleID: "1", Field: FieldRobotInField, New: false},
+				{TurtleID: "2", Field: FieldHomeGoal, Old: HomeGoalBlue},
+				{TurtleID: "3", Field: FieldTeamColor, New: TeamColorCyan},
+			},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, Diff(tc.From, tc.To))
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Relative commands refer to "our" or "their" team instead of a team color.
+// Relative commands are not understood by TRC and must be resolved using Resolve first.
+const (
+	CommandKickOffOurs    Command = "kick_off_ours"
+	CommandKickOffTheirs  Command = "kick_off_theirs"
+	CommandFreeKickOurs   Command = "free_kick_ours"
+	CommandFreeKickTheirs Command = "free_kick_theirs"
+	CommandGoalKickOurs   Command = "goal_kick_ours"
+	CommandGoalKickTheirs Command = "goal_kick_theirs"
+	CommandThrowInOurs    Command = "throw_in_ours"
+	CommandThrowInTheirs  Command = "throw_in_theirs"
+	CommandCornerOurs     Command = "corner_ours"
+	CommandCornerTheirs   Command = "corner_theirs"
+	CommandPenaltyOurs    Command = "penalty_ours"
+	CommandPenaltyTheirs  Command = "penalty_theirs"
+)
+
+// teamCommands contains the concrete commands for team magenta and cyan per set piece.
+type teamCommands struct {
+	magenta Command
+	cyan    Command
+}
+
+// relativeCommands maps relative commands to the concrete commands and whether they refer to our team.
+var relativeCommands = map[Command]struct {
+	teamCommands
+	ours bool
+}{
+	CommandKickOffOurs:    {teamCommands{CommandKickOffMagenta, CommandKickOffCyan}, true},
+	CommandKickOffTheirs:  {teamCommands{CommandKickOffMagenta, CommandKickOffCyan}, false},
+	CommandFreeKickOurs:   {teamCommands{CommandFreeKickMagenta, CommandFreeKickCyan}, true},
+	CommandFreeKickTheirs: {teamCommands{CommandFreeKickMagenta, CommandFreeKickCyan}, false},
+	CommandGoalKickOurs:   {teamCommands{CommandGoalKickMagenta, CommandGoalKickCyan}, true},
+	CommandGoalKickTheirs: {teamCommands{CommandGoalKickMagenta, CommandGoalKickCyan}, false},
+	CommandThrowInOurs:    {teamCommands{CommandThrowInMagenta, CommandThrowInCyan}, true},
+	CommandThrowInTheirs:  {teamCommands{CommandThrowInMagenta, CommandThrowInCyan}, false},
+	CommandCornerOurs:     {teamCommands{CommandCornerMagenta, CommandCornerCyan}, true},
+	CommandCornerTheirs:   {teamCommands{CommandCornerMagenta, CommandCornerCyan}, false},
+	CommandPenaltyOurs:    {teamCommands{CommandPenaltyMagenta, CommandPenaltyCyan}, true},
+	CommandPenaltyTheirs:  {teamCommands{CommandPenaltyMagenta, CommandPenaltyCyan}, false},
+}
+
+// IsRelative reports whether c is a relative command.
+func (c Command) IsRelative() bool {
+	_, ok := relativeCommands[c]
+	return ok
+}
+
+// Resolve returns the concrete command corresponding to c given the color tc of our team.
+// Concrete commands are returned unchanged.
+func (c Command) Resolve(tc TeamColor) (Command, error) {
+	rc, ok := relativeCommands[c]
+	if !ok {
+		return c, nil
+	}
+	if err := tc.Validate(); err != nil {
+		return "", err
+	}
+
+	if (tc == TeamColorMagenta) == rc.ours {
+		return rc.magenta, nil
+	}
+	return rc.cyan, nil
+}
+
+// TeamColor returns the color of our team as reported by the turtles.
+// Only turtles in the field are considered, unless none of them is.
+// TeamColor returns an error if the turtles disagree or no turtle reports a team color.
+func (s *State) TeamColor() (TeamColor, error) {
+	ids := make([]string, 0, len(s.Turtles))
+	for id, ts := range s.Turtles {
+		if ts != nil && ts.TeamColor != "" && ts.RobotInField != nil && *ts.RobotInField {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		for id, ts := range s.Turtles {
+			if ts != nil && ts.TeamColor != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return "", errors.New("no turtle reports a team color")
+	}
+	sort.Strings(ids)
+
+	tc := s.Turtles[ids[0]].TeamColor
+	for _, id := range ids[1:] {
+		if s.Turtles[id].TeamColor != tc {
+			return "", errors.Errorf("turtles disagree on team color: turtle %s is %s, turtle %s is %s",
+				ids[0], tc, id, s.Turtles[id].TeamColor,
+			)
+		}
+	}
+	return tc, nil
+}
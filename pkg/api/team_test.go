@@ -0,0 +1,103 @@
+package api_test
+
+import (
+	"testing"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Resolve() in team.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestResolve(t *testing.T) {
+	for _, tc := range []struct {
+		Command   Command
+		TeamColor TeamColor
+		Expected  Command
+	}{
+		{CommandFreeKickOurs, TeamColorMagenta, CommandFreeKickMagenta},
+		{CommandFreeKickOurs, TeamColorCyan, CommandFreeKickCyan},
+		{CommandFreeKickTheirs, TeamColorMagenta, CommandFreeKickCyan},
+		{CommandPenaltyTheirs, TeamColorCyan, CommandPenaltyMagenta},
+		{CommandKickOffOurs, TeamColorCyan, CommandKickOffCyan},
+		{CommandStart, TeamColorCyan, CommandStart},
+	} {
+		t.Run(string(tc.Command)+"/"+string(tc.TeamColor), func(t *testing.T) {
+			a := assert.New(t)
+
+			cmd, err := tc.Command.Resolve(tc.TeamColor)
+			a.NoError(err)
+			a.Equal(tc.Expected, cmd)
+			a.NoError(cmd.Validate())
+		})
+	}
+
+	_, err := CommandCornerOurs.Resolve("")
+	assert.Error(t, err)
+}
+
+//Test_items: TeamColor() in team.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestTeamColor(t *testing.T) {
+	for _, tc := range []struct {
+		Name        string
+		Turtles     map[string]*TurtleState
+		Expected    TeamColor
+		ShouldError bool
+	}{
+		{
+			Name:        "no turtles",
+			ShouldError: true,
+		},
+		{
+			Name: "agreement",
+			Turtles: map[string]*TurtleState{
+				"1": {TeamColor: TeamColorCyan, RobotInField: apitest.BoolPtr(true)},
+				"2": {TeamColor: TeamColorCyan, RobotInField: apitest.BoolPtr(true)},
+				"3": {},
+			},
+			Expected: TeamColorCyan,
+		},
+		{
+			Name: "disagreement",
+			Turtles: map[string]*TurtleState{
+				"1": {TeamColor: TeamColorCyan, RobotInField: apitest.BoolPtr(true)},
+				"2": {TeamColor: TeamColorMagenta, RobotInField: apitest.BoolPtr(true)},
+			},
+			ShouldError: true,
+		},
+		{
+			Name: "disagreement outside of the field",
+			Turtles: map[string]*TurtleState{
+				"1": {TeamColor: TeamColorCyan, RobotInField: apitest.BoolPtr(true)},
+				"2": {TeamColor: TeamColorMagenta, RobotInField: apitest.BoolPtr(false)},
+			},
+			Expected: TeamColorCyan,
+		},
+		{
+			Name: "no turtles in the field",
+			Turtles: map[string]*TurtleState{
+				"1": {TeamColor: TeamColorMagenta},
+				"2": {},
+			},
+			Expected: TeamColorMagenta,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			a := assert.New(t)
+
+			got, err := (&State{Turtles: tc.Turtles}).TeamColor()
+			if tc.ShouldError {
+				a.Error(err)
+				return
+			}
+			a.NoError(err)
+			a.Equal(tc.Expected, got)
+		})
+	}
+}
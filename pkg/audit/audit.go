@@ -0,0 +1,166 @@
+// Package audit implements an append-only log of actions performed by operators.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Entry is a single audit log entry.
+type Entry struct {
+	// Time is the time the action was requested.
+	Time time.Time `json:"time"`
+
+	// Operator is the name of the operator, who requested the action.
+	Operator string `json:"operator"`
+
+	// TRC is the name of the TRC, on which the action was performed, if SRRS manages several TRCs.
+	TRC string `json:"trc,omitempty"`
+
+	// Session is the ID of the session, in which the action was requested.
+	Session string `json:"session,omitempty"`
+
+	// ClientIP is the IP address of the client, which requested the action.
+	ClientIP string `json:"client_ip,omitempty"`
+
+	// Action is the kind of the action, e.g. "command" or "turtles".
+	Action string `json:"action"`
+
+	// Request is the request body describing the action.
+	Request json.RawMessage `json:"request,omitempty"`
+
+	// Error is the error returned by TRC or SRRS, if the action failed.
+	Error string `json:"error,omitempty"`
+
+	// Latency is the time it took to perform the action in milliseconds.
+	Latency int64 `json:"latency_ms"`
+}
+
+// Filter specifies the entries returned by Query.
+type Filter struct {
+	// TRC, if not empty, only matches entries of the TRC.
+	TRC string
+
+	// Operator, if not empty, only matches entries of the operator.
+	Operator string
+
+	// Since, if not zero, only matches entries at or after Since.
+	Since time.Time
+
+	// Until, if not zero, only matches entries before Until.
+	Until time.Time
+
+	// Limit, if positive, limits the amount of the latest entries returned.
+	Limit int
+}
+
+// matches reports whether e is matched by f.
+func (f Filter) matches(e *Entry) bool {
+	switch {
+	case f.TRC != "" && e.TRC != f.TRC:
+		return false
+	case f.Operator != "" && e.Operator != f.Operator:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Log is an append-only audit log.
+// If the Log is backed by a file, every entry is appended to it as a line of JSON.
+// Log is safe for concurrent use by multiple goroutines.
+type Log struct {
+	mu      sync.RWMutex
+	file    *os.File
+	entries []*Entry
+}
+
+// Open opens the *Log backed by the file at path.
+// Existing entries in the file are loaded.
+// If path is empty, the entries are only stored in memory.
+func Open(path string) (*Log, error) {
+	l := &Log{}
+	if path == "" {
+		return l, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit log")
+	}
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		e := &Entry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "failed to decode audit log entry %d", len(l.entries))
+		}
+		l.entries = append(l.entries, e)
+	}
+	if err := s.Err(); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to read audit log")
+	}
+
+	l.file = f
+	return l, nil
+}
+
+// Append appends e to the log.
+func (l *Log) Append(e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		if _, err := l.file.Write(append(b, '\n')); err != nil {
+			return errors.Wrap(err, "failed to write audit log entry")
+		}
+	}
+	l.entries = append(l.entries, e)
+	return nil
+}
+
+// Query returns the entries matched by f in chronological order.
+func (l *Log) Query(f Filter) []*Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	es := make([]*Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		if f.matches(e) {
+			es = append(es, e)
+		}
+	}
+	if f.Limit > 0 && len(es) > f.Limit {
+		es = es[len(es)-f.Limit:]
+	}
+	return es
+}
+
+// Close closes the file backing the log, if any.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,81 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Log in audit.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestLog(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "audit-test")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	l, err := Open(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	start := time.Unix(1000, 0).UTC()
+	entries := []*Entry{
+		{
+			Time:     start,
+			Operator: "alice",
+			Session:  "1",
+			ClientIP: "10.0.0.1",
+			Action:   "command",
+			Request:  json.RawMessage(`"start"`),
+			Latency:  3,
+		},
+		{
+			Time:     start.Add(time.Second),
+			Operator: "bob",
+			TRC:      "field2",
+			Session:  "2",
+			Action:   "turtles",
+			Request:  json.RawMessage(`{"1":{"role":"goalkeeper"}}`),
+			Error:    "failed to send turtle state to TRC",
+			Latency:  5,
+		},
+		{
+			Time:     start.Add(2 * time.Second),
+			Operator: "alice",
+			Session:  "1",
+			Action:   "command",
+			Request:  json.RawMessage(`"stop"`),
+		},
+	}
+	for _, e := range entries {
+		a.NoError(l.Append(e))
+	}
+	a.NoError(l.Close())
+
+	l, err = Open(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer l.Close()
+
+	a.Equal(entries, l.Query(Filter{}))
+	a.Equal([]*Entry{entries[0], entries[2]}, l.Query(Filter{Operator: "alice"}))
+	a.Equal([]*Entry{entries[1], entries[2]}, l.Query(Filter{Since: start.Add(time.Second)}))
+	a.Equal([]*Entry{entries[0]}, l.Query(Filter{Until: start.Add(time.Second)}))
+	a.Equal([]*Entry{entries[2]}, l.Query(Filter{Limit: 1}))
+	a.Equal([]*Entry{entries[1]}, l.Query(Filter{TRC: "field2"}))
+}
@@ -0,0 +1,228 @@
+// Package config implements loading of the configuration of SRRS and TRCD
+// from YAML files and environment variables.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Strings is a list of strings, which can be used as a flag.Value.
+// The list is represented as a comma-separated string.
+type Strings []string
+
+// String implements flag.Value.
+func (s *Strings) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set implements flag.Value.
+func (s *Strings) Set(v string) error {
+	*s = splitList(v)
+	return nil
+}
+
+// splitList splits comma-separated list v and trims spaces around the elements.
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	ss := strings.Split(v, ",")
+	for i, s := range ss {
+		ss[i] = strings.TrimSpace(s)
+	}
+	return ss
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv sets the fields of the struct pointed to by v from the environment variables.
+// The name of the variable of a field is prefix followed by an underscore and the upper-cased
+// YAML key of the field. Fields of nested structs are named by the path of YAML keys.
+// Lists are read as comma-separated strings.
+func applyEnv(prefix string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(name, fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Struct {
+			return errors.Errorf("%s can not be set by an environment variable", name)
+		}
+
+		var err error
+		switch {
+		case fv.Type() == durationType:
+			var d time.Duration
+			d, err = time.ParseDuration(s)
+			fv.SetInt(int64(d))
+
+		case fv.Kind() == reflect.String:
+			fv.SetString(s)
+
+		case fv.Kind() == reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(s)
+			fv.SetBool(b)
+
+		case fv.Kind() == reflect.Int:
+			var n int64
+			n, err = strconv.ParseInt(s, 10, 0)
+			fv.SetInt(n)
+
+		case fv.Kind() == reflect.Float64:
+			var f float64
+			f, err = strconv.ParseFloat(s, 64)
+			fv.SetFloat(f)
+
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String:
+			fv.Set(reflect.ValueOf(splitList(s)).Convert(fv.Type()))
+
+		default:
+			panic(errors.Errorf("unsupported type of field %s: %s", sf.Name, fv.Type()))
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse %s", name)
+		}
+	}
+	return nil
+}
+
+// Load decodes the YAML file at path into the struct pointed to by v, if path is not empty,
+// and overrides the fields of v by the environment variables prefixed by prefix.
+// Fields, which are not present in the file or the environment, are left untouched.
+func Load(path, prefix string, v interface{}) error {
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to read configuration file")
+		}
+		if err := yaml.UnmarshalStrict(b, v); err != nil {
+			return errors.Wrap(err, "failed to decode configuration file")
+		}
+	}
+	return applyEnv(prefix, reflect.ValueOf(v).Elem())
+}
+
+// Parse loads the configuration pointed to by v as Load does.
+// The flags of fs must be bound to the fields of v and fs must be parsed.
+// Flags set explicitly take precedence over the file and the environment.
+func Parse(fs *flag.FlagSet, path, prefix string, v interface{}) error {
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	if err := Load(path, prefix, v); err != nil {
+		return err
+	}
+
+	for name, val := range set {
+		if err := fs.Set(name, val); err != nil {
+			return errors.Wrapf(err, "failed to set flag %s", name)
+		}
+	}
+	return nil
+}
+
+// redacted replaces the values of secret settings in a Change.
+const redacted = "<redacted>"
+
+// Change is a setting, which differs between two configurations.
+type Change struct {
+	// Key is the path of YAML keys of the setting, e.g. `webapi.session_timeout`.
+	Key string
+
+	// Old is the old value of the setting.
+	Old string
+
+	// New is the new value of the setting.
+	New string
+}
+
+// diff appends the Changes between structs a and b to changes.
+func diff(changes []Change, prefix string, a, b reflect.Value) []Change {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		av, bv := a.Field(i), b.Field(i)
+		if av.Kind() == reflect.Struct {
+			changes = diff(changes, key, av, bv)
+			continue
+		}
+		if av.Kind() == reflect.Slice && av.Type().Elem().Kind() == reflect.Struct {
+			changes = diffSlice(changes, key, av, bv)
+			continue
+		}
+		if reflect.DeepEqual(av.Interface(), bv.Interface()) ||
+			av.Kind() == reflect.Slice && av.Len() == 0 && bv.Len() == 0 {
+			continue
+		}
+
+		c := Change{
+			Key: key,
+			Old: fmt.Sprint(av.Interface()),
+			New: fmt.Sprint(bv.Interface()),
+		}
+		if strings.HasSuffix(key, "secret") {
+			c.Old, c.New = redacted, redacted
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// diffSlice appends the Changes between slices of structs a and b to changes.
+// The elements are compared by index, added and removed elements are compared to the zero value.
+func diffSlice(changes []Change, key string, a, b reflect.Value) []Change {
+	zero := reflect.Zero(a.Type().Elem())
+	for i := 0; i < a.Len() || i < b.Len(); i++ {
+		av, bv := zero, zero
+		if i < a.Len() {
+			av = a.Index(i)
+		}
+		if i < b.Len() {
+			bv = b.Index(i)
+		}
+		changes = diff(changes, fmt.Sprintf("%s[%d]", key, i), av, bv)
+	}
+	return changes
+}
+
+// Diff returns the settings, which differ between the configurations pointed to by a and b.
+// a and b must point to values of the same type. The values of secrets are redacted.
+func Diff(a, b interface{}) []Change {
+	return diff(nil, "", reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem())
+}
@@ -0,0 +1,253 @@
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Load(), Parse(), SRRS.Validate() in config.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestParse(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "config")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "srrs.yml")
+	a.NoError(ioutil.WriteFile(path, []byte(`
+http:
+  tcp_address: ":8080"
+  allowed_origins:
+    - http://ui.example.com
+trc:
+  fleet_size: 5
+  ping_interval: 2s
+webapi:
+  session_timeout: 1m
+trcs:
+  - name: field1
+    unix_socket: /trc/field1.sock
+  - name: field2
+    tcp_socket: 10.0.0.2:4243
+    fleet_size: 4
+    refbox: 10.0.0.2:28097
+macros: /var/lib/srrs/macros.json
+`), 0600))
+
+	defer os.Unsetenv("SRRS_WEBAPI_REQUEST_RATE")
+	defer os.Unsetenv("SRRS_TRC_FLEET_SIZE")
+	defer os.Unsetenv("SRRS_HTTP_ALLOWED_ORIGINS")
+	os.Setenv("SRRS_WEBAPI_REQUEST_RATE", "2.5")
+	os.Setenv("SRRS_TRC_FLEET_SIZE", "4")
+	os.Setenv("SRRS_HTTP_ALLOWED_ORIGINS", "http://a.example.com, http://b.example.com")
+
+	conf := DefaultSRRS()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&conf.Macros, "macros", conf.Macros, "")
+	fs.IntVar(&conf.TRC.FleetSize, "fleetSize", conf.TRC.FleetSize, "")
+	fs.StringVar(&conf.HTTP.Static, "static", conf.HTTP.Static, "")
+	a.NoError(fs.Parse([]string{"-fleetSize", "3"}))
+
+	if !a.NoError(Parse(fs, path, SRRSEnvPrefix, &conf)) {
+		t.FailNow()
+	}
+	a.NoError(conf.Validate())
+
+	a.Equal(":8080", conf.HTTP.TCPAddress)
+	a.Equal(DefaultSRRS().HTTP.TLSAddress, conf.HTTP.TLSAddress)
+	a.Equal(Strings{"http://a.example.com", "http://b.example.com"}, conf.HTTP.AllowedOrigins)
+	a.Equal(3, conf.TRC.FleetSize)
+	a.Equal(2*time.Second, conf.TRC.PingInterval)
+	a.Equal(time.Minute, conf.WebAPI.SessionTimeout)
+	a.Equal(2.5, conf.WebAPI.RequestRate)
+	a.Equal("/var/lib/srrs/macros.json", conf.Macros)
+	a.True(conf.TRC.TokenAuth)
+
+	if a.Len(conf.TRCs, 2) {
+		trc := conf.TRCs[0].Client(conf.TRC)
+		a.Equal("/trc/field1.sock", trc.UnixSocket)
+		a.Equal(3, trc.FleetSize)
+		a.Equal(2*time.Second, trc.PingInterval)
+
+		trc = conf.TRCs[1].Client(conf.TRC)
+		a.Empty(trc.UnixSocket)
+		a.Equal("10.0.0.2:4243", trc.TCPSocket)
+		a.Equal(4, trc.FleetSize)
+		a.Equal("10.0.0.2:28097", conf.TRCs[1].RefBox)
+	}
+
+	os.Setenv("SRRS_TRCS", "field1")
+	a.Error(Load("", SRRSEnvPrefix, &conf))
+	os.Unsetenv("SRRS_TRCS")
+
+	os.Setenv("SRRS_TRC_FLEET_SIZE", "many")
+	a.Error(Load("", SRRSEnvPrefix, &conf))
+	os.Unsetenv("SRRS_TRC_FLEET_SIZE")
+
+	a.NoError(ioutil.WriteFile(path, []byte("unknown: true\n"), 0600))
+	a.Error(Load(path, SRRSEnvPrefix, &conf))
+
+	a.Error(Load(filepath.Join(dir, "missing.yml"), SRRSEnvPrefix, &conf))
+}
+
+//Test_items: SRRS.Validate(), TRCD.Validate() in config.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestValidate(t *testing.T) {
+	a := assert.New(t)
+
+	a.NoError(DefaultSRRS().Validate())
+
+	multi := DefaultSRRS()
+	multi.TRC.UnixSocket = ""
+	multi.TRCs = []NamedTRC{{Name: "field1", UnixSocket: "1.sock"}, {Name: "field_2", TCPSocket: ":4243"}}
+	a.NoError(multi.Validate())
+
+	serial := DefaultSRRS()
+	serial.TRC.UnixSocket = ""
+	serial.TRC.SerialDevice = "/dev/ttyUSB0"
+	a.NoError(serial.Validate())
+
+	listen := DefaultSRRS()
+	listen.TRC.UnixSocket = ""
+	listen.TRC.ListenAddress = ":4245"
+	a.Error(listen.Validate())
+
+	listen.TRC.Secret = "hunter2"
+	a.NoError(listen.Validate())
+
+	listen.TRC.ListenCert = "cert.pem"
+	listen.TRC.ListenKey = "key.pem"
+	a.NoError(listen.Validate())
+
+	listen.TRC.ListenAddress = ""
+	listen.TRC.WebSocketPath = "/trc"
+	a.Error(listen.Validate())
+
+	listen.TRC.ListenCert = ""
+	listen.TRC.ListenKey = ""
+	a.NoError(listen.Validate())
+
+	listen.TRCs = []NamedTRC{{Name: "field1"}, {Name: "field2", Secret: "hunter3"}}
+	a.NoError(listen.Validate())
+	a.Equal("field2", listen.TRCs[1].Client(listen.TRC).FleetID)
+
+	listen.TRC.Secret = ""
+	a.Error(listen.Validate())
+
+	listen.TRCs[0].Secret = "hunter2"
+	a.NoError(listen.Validate())
+
+	for _, f := range []func(*SRRS){
+		func(c *SRRS) { c.HTTP.TCPAddress = "" },
+		func(c *SRRS) { c.HTTP.Cert = "cert.pem" },
+		func(c *SRRS) { c.HTTP.ClientCA = "ca.pem" },
+		func(c *SRRS) { c.HTTP.AllowedOrigins = Strings{"example.com"} },
+		func(c *SRRS) { c.TRC.UnixSocket = "" },
+		func(c *SRRS) { c.TRC.FleetSize = 0 },
+		func(c *SRRS) { c.WebAPI.WriteTimeout = 0 },
+		func(c *SRRS) { c.WebAPI.RequestBurst = 0 },
+		func(c *SRRS) { c.ShutdownCommand = "explode" },
+		func(c *SRRS) { c.ShutdownTimeout = 0 },
+		func(c *SRRS) { c.TRCs = []NamedTRC{{Name: "field/1", UnixSocket: "trc.sock"}} },
+		func(c *SRRS) { c.TRCs = []NamedTRC{{Name: "field1"}} },
+		func(c *SRRS) {
+			c.TRCs = []NamedTRC{{Name: "field1", UnixSocket: "1.sock"}, {Name: "field1", UnixSocket: "2.sock"}}
+		},
+		func(c *SRRS) {
+			c.TRCs = []NamedTRC{{Name: "field1", UnixSocket: "1.sock"}}
+			c.RefBox = "localhost:28097"
+		},
+		func(c *SRRS) {
+			c.TRC.ListenAddress = ":4245"
+			c.TRC.Fingerprint = strings.Repeat("00", 32)
+		},
+		func(c *SRRS) { c.TRC.ListenAddress = ":4245" },
+		func(c *SRRS) {
+			c.TRC.Secret = "hunter2"
+			c.TRC.ListenAddress = ":4245"
+			c.TRC.ListenCert = "cert.pem"
+		},
+		func(c *SRRS) { c.TRC.WebSocketPath = "trc" },
+		func(c *SRRS) {
+			c.TRC.SerialDevice = "/dev/ttyUSB0"
+			c.TRC.SerialBaudRate = 0
+		},
+		func(c *SRRS) {
+			c.TRC.SerialDevice = "/dev/ttyUSB0"
+			c.TRC.ListenAddress = ":4245"
+		},
+		func(c *SRRS) {
+			c.TRC.ListenAddress = ":4245"
+			c.TRCs = []NamedTRC{{Name: "field1", Fingerprint: strings.Repeat("00", 32)}}
+		},
+	} {
+		conf := DefaultSRRS()
+		f(&conf)
+		a.Error(conf.Validate())
+	}
+
+	a.NoError(DefaultTRCD("trc.sock", "").Validate())
+	a.Error(DefaultTRCD("trc.sock", ":4243").Validate())
+	a.Error(DefaultTRCD("", "").Validate())
+
+	conf := DefaultTRCD("", ":4243")
+	conf.FleetSize = -1
+	a.Error(conf.Validate())
+
+	conf = DefaultTRCD("", ":4243")
+	conf.ShutdownTimeout = 0
+	a.Error(conf.Validate())
+
+	conf = DefaultTRCD("trc.sock", ":4243")
+	conf.SRRSAddress = "localhost:4245"
+	conf.SRRSFingerprint = strings.Repeat("00", 32)
+	a.NoError(conf.Validate())
+
+	conf.Cert = "cert.pem"
+	conf.Key = "key.pem"
+	a.Error(conf.Validate())
+
+	conf = DefaultTRCD("trc.sock", "")
+	conf.SRRSFingerprint = strings.Repeat("00", 32)
+	a.Error(conf.Validate())
+}
+
+//Test_items: Diff() in config.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestDiff(t *testing.T) {
+	a := assert.New(t)
+
+	old := DefaultSRRS()
+	a.Empty(Diff(&old, &old))
+
+	conf := DefaultSRRS()
+	conf.HTTP.AllowedOrigins = Strings{"http://ui.example.com"}
+	conf.TRC.Secret = "hunter2"
+	conf.WebAPI.SessionTimeout = time.Minute
+	conf.TRCs = []NamedTRC{{Name: "field1", Secret: "hunter3"}}
+	a.Equal([]Change{
+		{Key: "http.allowed_origins", Old: "[]", New: "[http://ui.example.com]"},
+		{Key: "trc.secret", Old: "<redacted>", New: "<redacted>"},
+		{Key: "webapi.session_timeout", Old: "5m0s", New: "1m0s"},
+		{Key: "trcs[0].name", Old: "", New: "field1"},
+		{Key: "trcs[0].secret", Old: "<redacted>", New: "<redacted>"},
+	}, Diff(&old, &conf))
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/match"
+	"github.com/rvolosatovs/turtlitto/pkg/transport"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/webapi"
+)
+
+// SRRSEnvPrefix is the prefix of the environment variables configuring SRRS.
+const SRRSEnvPrefix = "SRRS"
+
+// HTTP is the configuration of the web servers of SRRS.
+type HTTP struct {
+	// TCPAddress is the address of the insecure web server. The server is disabled if empty.
+	TCPAddress string `yaml:"tcp_address"`
+
+	// TLSAddress is the address of the secure web server.
+	TLSAddress string `yaml:"tls_address"`
+
+	// Static is the path to the static assets.
+	Static string `yaml:"static"`
+
+	// Cert is the path to the TLS certificate.
+	Cert string `yaml:"cert"`
+
+	// Key is the path to the private key of the certificate.
+	Key string `yaml:"key"`
+
+	// ClientCA is the path to the PEM-encoded CA certificates, which client certificates must be signed by.
+	ClientCA string `yaml:"client_ca"`
+
+	// AllowedOrigins are the origins, from which cross-origin requests are accepted.
+	AllowedOrigins Strings `yaml:"allowed_origins"`
+}
+
+// TRCClient is the configuration of the connection of SRRS to TRC.
+type TRCClient struct {
+	// UnixSocket is the path to the Unix socket of TRC.
+	UnixSocket string `yaml:"unix_socket"`
+
+	// TCPSocket is the address of the TCP socket of TRC. It is used instead of UnixSocket when set.
+	TCPSocket string `yaml:"tcp_socket"`
+
+	// SerialDevice is the path to the serial device, to which TRC is connected.
+	// It is used instead of the sockets when set.
+	SerialDevice string `yaml:"serial_device"`
+
+	// SerialBaudRate is the baud rate of the serial device.
+	SerialBaudRate int `yaml:"serial_baud_rate"`
+
+	// Secret is the secret shared with TRC.
+	Secret string `yaml:"secret"`
+
+	// Fingerprint is the SHA-256 fingerprint of the certificate of TRC.
+	Fingerprint string `yaml:"fingerprint"`
+
+	// TokenAuth specifies whether the token received from TRC is accepted for authentication.
+	TokenAuth bool `yaml:"token_auth"`
+
+	// PingInterval is the interval, at which TRC is pinged.
+	PingInterval time.Duration `yaml:"ping_interval"`
+
+	// FleetSize is the amount of turtles controlled by TRC.
+	FleetSize int `yaml:"fleet_size"`
+
+	// ListenAddress is the TCP address, on which SRRS accepts connections from TRC.
+	// The sockets of TRC are not dialed when set.
+	ListenAddress string `yaml:"listen_address"`
+
+	// ListenCert is the path to the TLS certificate used on ListenAddress.
+	// TLS is used on ListenAddress when set.
+	ListenCert string `yaml:"listen_cert"`
+
+	// ListenKey is the path to the private key of ListenCert.
+	ListenKey string `yaml:"listen_key"`
+
+	// WebSocketPath is the path on the web servers, on which SRRS accepts connections from TRC via WebSocket.
+	// The sockets of TRC are not dialed when set.
+	WebSocketPath string `yaml:"websocket_path"`
+
+	// FleetID is the fleet ID, which TRC connecting to SRRS must send in the handshake.
+	// Any fleet ID is accepted if empty.
+	FleetID string `yaml:"fleet_id"`
+}
+
+// Accepts reports whether SRRS accepts connections from TRC instead of dialing it.
+func (c TRCClient) Accepts() bool {
+	return c.ListenAddress != "" || c.WebSocketPath != ""
+}
+
+// NamedTRC is the configuration of one of several TRCs managed by SRRS.
+type NamedTRC struct {
+	// Name identifies the TRC in the web API.
+	// If SRRS accepts connections from TRCs, Name is the fleet ID, which TRC must send in the handshake.
+	Name string `yaml:"name"`
+
+	// UnixSocket is the path to the Unix socket of TRC.
+	UnixSocket string `yaml:"unix_socket"`
+
+	// TCPSocket is the address of the TCP socket of TRC. It is used instead of UnixSocket when set.
+	TCPSocket string `yaml:"tcp_socket"`
+
+	// SerialDevice is the path to the serial device, to which TRC is connected.
+	// It is used instead of the sockets when set.
+	SerialDevice string `yaml:"serial_device"`
+
+	// Secret is the secret shared with TRC. The secret of TRCClient is used if empty.
+	Secret string `yaml:"secret"`
+
+	// Fingerprint is the SHA-256 fingerprint of the certificate of TRC.
+	Fingerprint string `yaml:"fingerprint"`
+
+	// FleetSize is the amount of turtles controlled by TRC. The fleet size of TRCClient is used if zero.
+	FleetSize int `yaml:"fleet_size"`
+
+	// RefBox is the TCP address of the referee box of the field of TRC.
+	RefBox string `yaml:"refbox"`
+}
+
+// Client returns the configuration of the connection to t.
+// Settings, which are not specified for t, default to those of c.
+func (t NamedTRC) Client(c TRCClient) TRCClient {
+	c.UnixSocket = t.UnixSocket
+	c.TCPSocket = t.TCPSocket
+	c.SerialDevice = t.SerialDevice
+	c.Fingerprint = t.Fingerprint
+	c.FleetID = t.Name
+	if t.Secret != "" {
+		c.Secret = t.Secret
+	}
+	if t.FleetSize > 0 {
+		c.FleetSize = t.FleetSize
+	}
+	return c
+}
+
+// trcNameRegexp matches valid names of TRCs.
+var trcNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// WebAPI is the configuration of the web API.
+type WebAPI struct {
+	// PingInterval is the interval, at which pings are sent on WebSockets.
+	PingInterval time.Duration `yaml:"ping_interval"`
+
+	// WriteTimeout is the timeout of WebSocket writes.
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// ReadTimeout is the timeout of WebSocket reads.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+
+	// InactivityTimeout is the time after which TRC is stopped, if no client is active.
+	InactivityTimeout time.Duration `yaml:"inactivity_timeout"`
+
+	// SessionTimeout is the time after which a session without an open WebSocket expires.
+	SessionTimeout time.Duration `yaml:"session_timeout"`
+
+	// RequestRate is the amount of requests per second each session may send to TRC.
+	RequestRate float64 `yaml:"request_rate"`
+
+	// RequestBurst is the amount of requests each session may send to TRC at once.
+	RequestBurst int `yaml:"request_burst"`
+
+	// DebounceInterval is the interval, within which identical consecutive requests are ignored.
+	DebounceInterval time.Duration `yaml:"debounce_interval"`
+
+	// AuthAttempts is the amount of failed authentication attempts, after which a client is locked out.
+	AuthAttempts int `yaml:"auth_attempts"`
+
+	// AuthLockout is the duration of the lockout.
+	AuthLockout time.Duration `yaml:"auth_lockout"`
+}
+
+// SRRS is the configuration of SRRS.
+type SRRS struct {
+	// Debug enables debug mode.
+	Debug bool `yaml:"debug"`
+
+	HTTP   HTTP      `yaml:"http"`
+	TRC    TRCClient `yaml:"trc"`
+	WebAPI WebAPI    `yaml:"webapi"`
+
+	// TRCs are the TRCs managed by SRRS, if SRRS manages several TRCs.
+	// If not empty, the sockets of TRC and RefBox are ignored and the web API of each TRC
+	// is served under `/api/v1/trcs/{name}/`.
+	TRCs []NamedTRC `yaml:"trcs"`
+
+	// Macros is the path to the file, in which macros are stored.
+	Macros string `yaml:"macros"`
+
+	// AuditLog is the path to the file, to which the audit log is appended.
+	AuditLog string `yaml:"audit_log"`
+
+	// Operators is the path to the file, in which operator credentials are stored.
+	Operators string `yaml:"operators"`
+
+	// HalfDuration is the duration of a half of the match.
+	HalfDuration time.Duration `yaml:"half_duration"`
+
+	// RefBox is the TCP address of the referee box.
+	RefBox string `yaml:"refbox"`
+
+	// ShutdownCommand is the command sent to TRC on shutdown. No command is sent if empty.
+	ShutdownCommand string `yaml:"shutdown_command"`
+
+	// ShutdownTimeout is the time, within which in-flight requests must complete on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// DefaultSRRS returns the default configuration of SRRS.
+func DefaultSRRS() SRRS {
+	return SRRS{
+		HTTP: HTTP{
+			TCPAddress: ":4242",
+			TLSAddress: ":4244",
+		},
+		TRC: TRCClient{
+			UnixSocket:     filepath.Join(os.TempDir(), "trc.sock"),
+			TokenAuth:      true,
+			PingInterval:   5 * time.Second,
+			FleetSize:      trcapi.DefaultFleetSize,
+			SerialBaudRate: transport.DefaultBaudRate,
+		},
+		WebAPI: WebAPI{
+			PingInterval:      webapi.DefaultTimeouts.Ping,
+			WriteTimeout:      webapi.DefaultTimeouts.Write,
+			ReadTimeout:       webapi.DefaultTimeouts.Read,
+			InactivityTimeout: webapi.DefaultTimeouts.Inactivity,
+			SessionTimeout:    webapi.DefaultTimeouts.Session,
+			RequestRate:       webapi.DefaultRequestRate,
+			RequestBurst:      webapi.DefaultRequestBurst,
+			DebounceInterval:  webapi.DefaultDebounceInterval,
+			AuthAttempts:      webapi.DefaultAuthAttempts,
+			AuthLockout:       webapi.DefaultAuthLockout,
+		},
+		HalfDuration:    match.DefaultHalfDuration,
+		ShutdownCommand: string(api.CommandStop),
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+// Timeouts returns the webapi.Timeouts configured by c.
+func (c WebAPI) Timeouts() webapi.Timeouts {
+	return webapi.Timeouts{
+		Ping:       c.PingInterval,
+		Write:      c.WriteTimeout,
+		Read:       c.ReadTimeout,
+		Inactivity: c.InactivityTimeout,
+		Session:    c.SessionTimeout,
+	}
+}
+
+// Validate validates the configuration.
+func (c SRRS) Validate() error {
+	switch {
+	case c.HTTP.TCPAddress == "" && (c.HTTP.Cert == "" || c.HTTP.Key == ""):
+		return errors.New("either the TCP address or both the certificate and key must be specified")
+	case (c.HTTP.Cert == "") != (c.HTTP.Key == ""):
+		return errors.New("certificate and key must be specified together")
+	case c.HTTP.ClientCA != "" && c.HTTP.Cert == "":
+		return errors.New("client CA requires a certificate")
+	case len(c.TRCs) == 0 && !c.TRC.Accepts() && c.TRC.UnixSocket == "" && c.TRC.TCPSocket == "" && c.TRC.SerialDevice == "":
+		return errors.New("either the Unix or TCP socket or the serial device of TRC, the listen address or the WebSocket path must be specified")
+	case c.TRC.Accepts() && c.TRC.SerialDevice != "":
+		return errors.New("serial device of TRC is not supported, if SRRS accepts connections from TRC")
+	case c.TRC.SerialDevice != "" && c.TRC.Fingerprint != "":
+		return errors.New("TRC fingerprint is not supported on serial devices")
+	case c.TRC.SerialBaudRate <= 0:
+		return errors.New("serial baud rate must be positive")
+	case c.TRC.WebSocketPath != "" && !strings.HasPrefix(c.TRC.WebSocketPath, "/"):
+		return errors.New("TRC WebSocket path must be absolute")
+	case c.TRC.Accepts() && c.TRC.Fingerprint != "":
+		return errors.New("TRC fingerprint is not supported, if SRRS accepts connections from TRC")
+	case len(c.TRCs) == 0 && c.TRC.Accepts() && c.TRC.Secret == "":
+		return errors.New("TRC secret must be specified, if SRRS accepts connections from TRC")
+	case (c.TRC.ListenCert == "") != (c.TRC.ListenKey == ""):
+		return errors.New("TRC listen certificate and key must be specified together")
+	case c.TRC.ListenCert != "" && c.TRC.ListenAddress == "":
+		return errors.New("TRC listen certificate requires a listen address")
+	case len(c.TRCs) > 0 && c.RefBox != "":
+		return errors.New("referee box must be specified per TRC, if several TRCs are configured")
+	case c.TRC.FleetSize <= 0:
+		return errors.New("fleet size must be positive")
+	case c.TRC.PingInterval <= 0:
+		return errors.New("TRC ping interval must be positive")
+	case c.WebAPI.PingInterval <= 0:
+		return errors.New("WebSocket ping interval must be positive")
+	case c.WebAPI.WriteTimeout <= 0:
+		return errors.New("write timeout must be positive")
+	case c.WebAPI.ReadTimeout <= 0:
+		return errors.New("read timeout must be positive")
+	case c.WebAPI.InactivityTimeout <= 0:
+		return errors.New("inactivity timeout must be positive")
+	case c.WebAPI.SessionTimeout <= 0:
+		return errors.New("session timeout must be positive")
+	case c.WebAPI.RequestRate > 0 && c.WebAPI.RequestBurst <= 0:
+		return errors.New("request burst must be positive")
+	case c.WebAPI.AuthAttempts > 0 && c.WebAPI.AuthLockout <= 0:
+		return errors.New("authentication lockout must be positive")
+	case c.HalfDuration <= 0:
+		return errors.New("half duration must be positive")
+	case c.ShutdownTimeout <= 0:
+		return errors.New("shutdown timeout must be positive")
+	}
+
+	names := make(map[string]struct{}, len(c.TRCs))
+	for _, t := range c.TRCs {
+		switch _, ok := names[t.Name]; {
+		case !trcNameRegexp.MatchString(t.Name):
+			return errors.Errorf("invalid TRC name: `%s`", t.Name)
+		case ok:
+			return errors.Errorf("duplicate TRC name: %s", t.Name)
+		case !c.TRC.Accepts() && t.UnixSocket == "" && t.TCPSocket == "" && t.SerialDevice == "":
+			return errors.Errorf("either the Unix or TCP socket or the serial device of TRC %s must be specified", t.Name)
+		case c.TRC.Accepts() && t.SerialDevice != "":
+			return errors.Errorf("serial device of TRC %s is not supported, if SRRS accepts connections from TRC", t.Name)
+		case t.SerialDevice != "" && t.Fingerprint != "":
+			return errors.Errorf("fingerprint of TRC %s is not supported on serial devices", t.Name)
+		case c.TRC.Accepts() && t.Fingerprint != "":
+			return errors.Errorf("fingerprint of TRC %s is not supported, if SRRS accepts connections from TRC", t.Name)
+		case c.TRC.Accepts() && t.Secret == "" && c.TRC.Secret == "":
+			return errors.Errorf("secret of TRC %s must be specified, if SRRS accepts connections from TRC", t.Name)
+		case t.FleetSize < 0:
+			return errors.Errorf("fleet size of TRC %s must not be negative", t.Name)
+		}
+		names[t.Name] = struct{}{}
+	}
+
+	if c.ShutdownCommand != "" {
+		if err := api.Command(c.ShutdownCommand).Validate(); err != nil {
+			return errors.Wrap(err, "invalid shutdown command")
+		}
+	}
+
+	for _, o := range c.HTTP.AllowedOrigins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.Errorf("invalid allowed origin: %s", o)
+		}
+	}
+	return nil
+}
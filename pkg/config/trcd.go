@@ -0,0 +1,101 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+)
+
+// TRCDEnvPrefix is the prefix of the environment variables configuring TRCD.
+const TRCDEnvPrefix = "TRCD"
+
+// TRCD is the configuration of TRCD.
+type TRCD struct {
+	// Debug enables debug mode.
+	Debug bool `yaml:"debug"`
+
+	// UnixSocket is the path to the Unix socket.
+	UnixSocket string `yaml:"unix_socket"`
+
+	// TCPSocket is the address of the TCP socket.
+	TCPSocket string `yaml:"tcp_socket"`
+
+	// Cert is the path to the TLS certificate.
+	Cert string `yaml:"cert"`
+
+	// Key is the path to the private key of the certificate.
+	Key string `yaml:"key"`
+
+	// Secret is the secret shared with SRRS.
+	Secret string `yaml:"secret"`
+
+	// Silent disables automatic sending of random state updates.
+	Silent bool `yaml:"silent"`
+
+	// FleetSize is the amount of simulated turtles.
+	FleetSize int `yaml:"fleet_size"`
+
+	// StateInterval is the minimum interval, at which random state updates are sent.
+	StateInterval time.Duration `yaml:"state_interval"`
+
+	// PingInterval is the minimum interval, at which SRRS is pinged.
+	PingInterval time.Duration `yaml:"ping_interval"`
+
+	// ShutdownTimeout is the time, within which connections must be closed on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// SRRSAddress is the TCP address or the WebSocket URL of SRRS accepting connections from TRC.
+	// If set, TRCD dials SRRS instead of listening on the sockets and redials once the connection is lost.
+	SRRSAddress string `yaml:"srrs_address"`
+
+	// SRRSFingerprint is the SHA-256 fingerprint of the certificate of SRRS.
+	// TLS is used on the connection to a TCP address of SRRS when set.
+	SRRSFingerprint string `yaml:"srrs_fingerprint"`
+
+	// FleetID is the fleet ID sent to SRRS in the handshake.
+	FleetID string `yaml:"fleet_id"`
+
+	// RedialInterval is the interval, at which SRRS is redialed.
+	RedialInterval time.Duration `yaml:"redial_interval"`
+}
+
+// DefaultTRCD returns the default configuration of TRCD listening on unixSock or tcpSock.
+func DefaultTRCD(unixSock, tcpSock string) TRCD {
+	return TRCD{
+		UnixSocket:      unixSock,
+		TCPSocket:       tcpSock,
+		FleetSize:       apitest.MaxTurtles,
+		StateInterval:   10 * time.Second,
+		PingInterval:    time.Second,
+		ShutdownTimeout: 5 * time.Second,
+		RedialInterval:  time.Second,
+	}
+}
+
+// Validate validates the configuration.
+func (c TRCD) Validate() error {
+	switch {
+	case c.SRRSAddress == "" && c.UnixSocket != "" && c.TCPSocket != "":
+		return errors.New("at most one of TCP socket and Unix socket must be specified")
+	case c.SRRSAddress == "" && c.UnixSocket == "" && c.TCPSocket == "":
+		return errors.New("either TCP socket, Unix socket or SRRS address must be specified")
+	case c.SRRSAddress != "" && c.Cert != "":
+		return errors.New("certificate is not supported, if SRRS is dialed")
+	case c.SRRSFingerprint != "" && c.SRRSAddress == "":
+		return errors.New("SRRS fingerprint requires an SRRS address")
+	case c.SRRSAddress != "" && c.RedialInterval <= 0:
+		return errors.New("redial interval must be positive")
+	case (c.Cert == "") != (c.Key == ""):
+		return errors.New("certificate and key must be specified together")
+	case c.FleetSize <= 0:
+		return errors.New("fleet size must be positive")
+	case c.StateInterval <= 0:
+		return errors.New("state interval must be positive")
+	case c.PingInterval <= 0:
+		return errors.New("ping interval must be positive")
+	case c.ShutdownTimeout <= 0:
+		return errors.New("shutdown timeout must be positive")
+	}
+	return nil
+}
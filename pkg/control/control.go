@@ -0,0 +1,306 @@
+// Package control implements a lock, which grants control over the robots to a single session.
+package control
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTimeout is the default time after which a pending request is granted automatically.
+const DefaultTimeout = 10 * time.Second
+
+var (
+	// ErrHeld represents an error, which occurs when the lock is held by another session.
+	ErrHeld = errors.New("control is held by another session")
+
+	// ErrNotHolder represents an error, which occurs when a session, which does not hold the lock,
+	// attempts an action reserved for the holder.
+	ErrNotHolder = errors.New("control is not held by this session")
+
+	// ErrNoRequest represents an error, which occurs when there is no pending request.
+	ErrNoRequest = errors.New("no control request is pending")
+
+	// ErrRequestPending represents an error, which occurs when another session already requested control.
+	ErrRequestPending = errors.New("another control request is pending")
+)
+
+// Client identifies a session competing for control.
+type Client struct {
+	// Session is the ID of the session.
+	Session string `json:"session"`
+
+	// Operator is the name of the operator of the session.
+	Operator string `json:"operator,omitempty"`
+}
+
+// Request is a pending request for control.
+type Request struct {
+	Client
+
+	// Deadline is the time at which control is transferred to the requester,
+	// unless the holder accepts or denies the request before.
+	Deadline time.Time `json:"deadline"`
+}
+
+// State is the state of the lock.
+type State struct {
+	// Holder is the session holding control, if any.
+	Holder *Client `json:"holder,omitempty"`
+
+	// Request is the pending request for control, if any.
+	Request *Request `json:"request,omitempty"`
+}
+
+// Lock grants control to at most one session at a time.
+// Other sessions may request control, which the holder can accept or deny.
+// If the holder does not respond within the timeout, control is transferred to the requester.
+// Lock is safe for concurrent use by multiple goroutines.
+type Lock struct {
+	timeout time.Duration
+
+	mu      sync.RWMutex
+	holder  *Client
+	request *Request
+	timer   *time.Timer
+
+	subsMu sync.RWMutex
+	subs   map[chan struct{}]struct{}
+}
+
+// Option represents a Lock option.
+type Option func(*Lock)
+
+// WithTimeout allows to specify the time after which a pending request is granted automatically.
+func WithTimeout(d time.Duration) Option {
+	return func(l *Lock) {
+		l.timeout = d
+	}
+}
+
+// New returns a new unlocked *Lock.
+func New(opts ...Option) *Lock {
+	l := &Lock{
+		timeout: DefaultTimeout,
+		subs:    make(map[chan struct{}]struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// notify notifies the subscribers about a state change.
+func (l *Lock) notify() {
+	l.subsMu.RLock()
+	for ch := range l.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	l.subsMu.RUnlock()
+}
+
+// clearRequest removes the pending request, if any.
+// clearRequest must be called with l.mu held.
+func (l *Lock) clearRequest() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.request = nil
+}
+
+// grantRequest transfers control to the requester.
+// grantRequest must be called with l.mu held.
+func (l *Lock) grantRequest() {
+	c := l.request.Client
+	l.clearRequest()
+	l.holder = &c
+}
+
+// isHolder reports whether session holds control.
+// isHolder must be called with l.mu held.
+func (l *Lock) isHolder(session string) bool {
+	return l.holder != nil && l.holder.Session == session
+}
+
+// Holds reports whether session holds control.
+func (l *Lock) Holds(session string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.isHolder(session)
+}
+
+// Acquire grants control to c, if no session holds it.
+// Acquire succeeds, if c already holds control.
+func (l *Lock) Acquire(c Client) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.isHolder(c.Session):
+		return nil
+	case l.holder != nil:
+		return ErrHeld
+	}
+
+	if l.request != nil && l.request.Session == c.Session {
+		l.clearRequest()
+	}
+	l.holder = &c
+	l.notify()
+	return nil
+}
+
+// Request requests control for c.
+// If no session holds control, it is granted immediately.
+// Otherwise, control is transferred to c after the timeout,
+// unless the holder accepts or denies the request before.
+func (l *Lock) Request(c Client) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.holder == nil:
+		l.holder = &c
+		l.notify()
+		return nil
+
+	case l.isHolder(c.Session):
+		return nil
+
+	case l.request != nil && l.request.Session != c.Session:
+		return ErrRequestPending
+
+	case l.request != nil:
+		return nil
+	}
+
+	req := &Request{
+		Client:   c,
+		Deadline: time.Now().Add(l.timeout),
+	}
+	l.request = req
+	l.timer = time.AfterFunc(l.timeout, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.request != req {
+			// The request was accepted, denied or withdrawn in the meantime.
+			return
+		}
+		l.grantRequest()
+		l.notify()
+	})
+	l.notify()
+	return nil
+}
+
+// Accept transfers control from holder to the requester.
+func (l *Lock) Accept(holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case !l.isHolder(holder):
+		return ErrNotHolder
+	case l.request == nil:
+		return ErrNoRequest
+	}
+	l.grantRequest()
+	l.notify()
+	return nil
+}
+
+// Deny denies the pending request, if holder holds control.
+func (l *Lock) Deny(holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case !l.isHolder(holder):
+		return ErrNotHolder
+	case l.request == nil:
+		return ErrNoRequest
+	}
+	l.clearRequest()
+	l.notify()
+	return nil
+}
+
+// Release releases control held by holder.
+// If a request is pending, control is transferred to the requester.
+func (l *Lock) Release(holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.isHolder(holder) {
+		return ErrNotHolder
+	}
+	l.holder = nil
+	if l.request != nil {
+		l.grantRequest()
+	}
+	l.notify()
+	return nil
+}
+
+// Remove removes session from the lock.
+// Control is released, if session holds it, and the request of session is withdrawn, if any.
+func (l *Lock) Remove(session string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.isHolder(session):
+		l.holder = nil
+		if l.request != nil {
+			l.grantRequest()
+		}
+
+	case l.request != nil && l.request.Session == session:
+		l.clearRequest()
+
+	default:
+		return
+	}
+	l.notify()
+}
+
+// State returns the state of the lock.
+func (l *Lock) State() *State {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	st := &State{}
+	if l.holder != nil {
+		c := *l.holder
+		st.Holder = &c
+	}
+	if l.request != nil {
+		req := *l.request
+		st.Request = &req
+	}
+	return st
+}
+
+// Subscribe opens a subscription to state changes.
+// Subscribe returns a read-only channel, on which a value is sent
+// every time the state changes and a function, which must be used to close the subscription.
+func (l *Lock) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	l.subsMu.Lock()
+	l.subs[ch] = struct{}{}
+	l.subsMu.Unlock()
+
+	return ch, func() {
+		l.subsMu.Lock()
+		delete(l.subs, ch)
+		l.subsMu.Unlock()
+		close(ch)
+	}
+}
@@ -0,0 +1,100 @@
+package control_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/control"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	alice = Client{Session: "1", Operator: "alice"}
+	bob   = Client{Session: "2", Operator: "bob"}
+	carol = Client{Session: "3", Operator: "carol"}
+)
+
+//Test_items: Lock in control.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestLock(t *testing.T) {
+	a := assert.New(t)
+
+	l := New(WithTimeout(time.Hour))
+
+	ch, closeFn := l.Subscribe()
+	defer closeFn()
+
+	a.Equal(&State{}, l.State())
+
+	a.NoError(l.Acquire(alice))
+	a.True(l.Holds(alice.Session))
+	a.NoError(l.Acquire(alice))
+	a.Equal(ErrHeld, l.Acquire(bob))
+	a.Equal(&alice, l.State().Holder)
+
+	select {
+	case <-ch:
+	default:
+		t.Error("No notification received")
+	}
+
+	a.NoError(l.Request(bob))
+	a.Equal(ErrRequestPending, l.Request(carol))
+	if a.NotNil(l.State().Request) {
+		a.Equal(bob, l.State().Request.Client)
+	}
+
+	a.Equal(ErrNotHolder, l.Deny(bob.Session))
+	a.NoError(l.Deny(alice.Session))
+	a.Nil(l.State().Request)
+	a.Equal(ErrNoRequest, l.Accept(alice.Session))
+
+	a.NoError(l.Request(bob))
+	a.NoError(l.Accept(alice.Session))
+	a.True(l.Holds(bob.Session))
+	a.False(l.Holds(alice.Session))
+
+	a.NoError(l.Request(carol))
+	a.NoError(l.Release(bob.Session))
+	a.True(l.Holds(carol.Session))
+	a.Nil(l.State().Request)
+	a.Equal(ErrNotHolder, l.Release(bob.Session))
+
+	a.NoError(l.Request(alice))
+	l.Remove(alice.Session)
+	a.Nil(l.State().Request)
+
+	l.Remove(carol.Session)
+	a.Equal(&State{}, l.State())
+
+	a.NoError(l.Request(bob))
+	a.True(l.Holds(bob.Session))
+}
+
+//Test_items: Request() in control.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRequestTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	l := New(WithTimeout(10 * time.Millisecond))
+
+	ch, closeFn := l.Subscribe()
+	defer closeFn()
+
+	a.NoError(l.Acquire(alice))
+	a.NoError(l.Request(bob))
+
+	deadline := time.After(time.Second)
+	for !l.Holds(bob.Session) {
+		select {
+		case <-ch:
+		case <-deadline:
+			t.Fatal("Timed out waiting for control to be transferred")
+		}
+	}
+	a.Equal(&State{Holder: &bob}, l.State())
+}
@@ -0,0 +1,282 @@
+// Package credentials implements storage and verification of operator credentials.
+package credentials
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrNotFound represents an error, which occurs when an operator does not exist.
+	ErrNotFound = errors.New("operator not found")
+
+	// ErrInvalidCredentials represents an error, which occurs when the password does not match.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Role is the role of an operator, which determines the actions the operator may perform.
+type Role string
+
+const (
+	// RoleViewer may only observe the state.
+	RoleViewer Role = "viewer"
+
+	// RoleOperator may additionally issue commands and edit turtles.
+	RoleOperator Role = "operator"
+
+	// RoleAdmin may additionally manage operators.
+	RoleAdmin Role = "admin"
+)
+
+// roleLevels maps roles to their privilege levels.
+var roleLevels = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Validate implements api.Validator.
+func (r Role) Validate() error {
+	if _, ok := roleLevels[r]; !ok {
+		return errors.Errorf("invalid Role: %s", r)
+	}
+	return nil
+}
+
+// Allows reports whether r is allowed to perform actions, which require role req.
+func (r Role) Allows(req Role) bool {
+	lvl, ok := roleLevels[r]
+	return ok && lvl >= roleLevels[req]
+}
+
+// Operator is a named operator.
+type Operator struct {
+	// Name is the name of the operator.
+	Name string `json:"name"`
+
+	// Role is the role of the operator.
+	Role Role `json:"role"`
+
+	// Hash is the bcrypt hash of the password of the operator.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Validate implements api.Validator.
+func (op *Operator) Validate() error {
+	if op.Name == "" {
+		return errors.New("operator name must not be empty")
+	}
+	return op.Role.Validate()
+}
+
+// Store stores operators by name.
+// If the Store is backed by a file, every modification is persisted to it.
+// Store is safe for concurrent use by multiple goroutines.
+type Store struct {
+	path string
+
+	mu        sync.RWMutex
+	operators map[string]*Operator
+}
+
+// load loads the operators stored in the file at path.
+// If the file does not exist, no operators are returned.
+func load(path string) (map[string]*Operator, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]*Operator{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read operators")
+	}
+
+	var ops []*Operator
+	if err := json.Unmarshal(b, &ops); err != nil {
+		return nil, errors.Wrap(err, "failed to decode operators")
+	}
+
+	m := make(map[string]*Operator, len(ops))
+	for _, op := range ops {
+		if err := op.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid operator %s", op.Name)
+		}
+		m[op.Name] = op
+	}
+	return m, nil
+}
+
+// NewStore returns a new *Store backed by the file at path.
+// The operators stored in the file are loaded, if it exists.
+// If path is empty, the operators are only stored in memory.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:      path,
+		operators: make(map[string]*Operator),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	ops, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	s.operators = ops
+	return s, nil
+}
+
+// Reload replaces the stored operators by the ones stored in the file backing s.
+// If the file cannot be loaded, the stored operators are left untouched.
+// Reload is a no-op, if s is not backed by a file.
+func (s *Store) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+
+	ops, err := load(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.operators = ops
+	s.mu.Unlock()
+	return nil
+}
+
+// list returns the stored operators sorted by name.
+// list must be called with s.mu held.
+func (s *Store) list() []*Operator {
+	ops := make([]*Operator, 0, len(s.operators))
+	for _, op := range s.operators {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Name < ops[j].Name })
+	return ops
+}
+
+// save persists the stored operators.
+// save must be called with s.mu held.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.list(), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "failed to write operators")
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// List returns all stored operators sorted by name.
+// The password hashes are omitted.
+func (s *Store) List() []*Operator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ops := s.list()
+	for i, op := range ops {
+		ops[i] = &Operator{
+			Name: op.Name,
+			Role: op.Role,
+		}
+	}
+	return ops
+}
+
+// Put stores the operator named name with password and role,
+// replacing the operator with the same name, if such exists.
+// If password is empty, the operator cannot authenticate using a password,
+// but only using a client certificate.
+func (s *Store) Put(name, password string, role Role) error {
+	op := &Operator{
+		Name: name,
+		Role: role,
+	}
+	if err := op.Validate(); err != nil {
+		return err
+	}
+
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return errors.Wrap(err, "failed to hash password")
+		}
+		op.Hash = string(hash)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.operators[name]
+	s.operators[name] = op
+	if err := s.save(); err != nil {
+		if ok {
+			s.operators[name] = old
+		} else {
+			delete(s.operators, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the operator named name.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, ok := s.operators[name]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(s.operators, name)
+	if err := s.save(); err != nil {
+		s.operators[name] = op
+		return err
+	}
+	return nil
+}
+
+// Role returns the role of the operator named name.
+func (s *Store) Role(name string) (Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	op, ok := s.operators[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return op.Role, nil
+}
+
+// Authenticate returns the role of the operator named name, if password matches.
+func (s *Store) Authenticate(name, password string) (Role, error) {
+	s.mu.RLock()
+	op, ok := s.operators[name]
+	s.mu.RUnlock()
+
+	if !ok {
+		return "", ErrNotFound
+	}
+	if op.Hash == "" {
+		return "", ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(op.Hash), []byte(password)); err != nil {
+		return "", ErrInvalidCredentials
+	}
+	return op.Role, nil
+}
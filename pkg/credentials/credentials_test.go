@@ -0,0 +1,103 @@
+package credentials_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Allows() in credentials.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestAllows(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(RoleAdmin.Allows(RoleOperator))
+	a.True(RoleOperator.Allows(RoleOperator))
+	a.True(RoleViewer.Allows(RoleViewer))
+	a.False(RoleViewer.Allows(RoleOperator))
+	a.False(RoleOperator.Allows(RoleAdmin))
+	a.False(Role("").Allows(RoleViewer))
+}
+
+//Test_items: Store in credentials.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestStore(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "credentials-test")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "operators.json")
+
+	st, err := NewStore(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Empty(st.List())
+
+	a.NoError(st.Put("alice", "secret", RoleAdmin))
+	a.NoError(st.Put("bob", "hunter2", RoleViewer))
+	a.Error(st.Put("", "secret", RoleAdmin))
+	a.NoError(st.Put("carol", "", RoleOperator))
+	a.Error(st.Put("carol", "secret", "superuser"))
+
+	st, err = NewStore(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Equal([]*Operator{
+		{Name: "alice", Role: RoleAdmin},
+		{Name: "bob", Role: RoleViewer},
+		{Name: "carol", Role: RoleOperator},
+	}, st.List())
+
+	role, err := st.Authenticate("alice", "secret")
+	a.NoError(err)
+	a.Equal(RoleAdmin, role)
+
+	_, err = st.Authenticate("alice", "hunter2")
+	a.Equal(ErrInvalidCredentials, err)
+
+	_, err = st.Authenticate("carol", "")
+	a.Equal(ErrInvalidCredentials, err)
+
+	_, err = st.Authenticate("dave", "secret")
+	a.Equal(ErrNotFound, err)
+
+	role, err = st.Role("bob")
+	a.NoError(err)
+	a.Equal(RoleViewer, role)
+
+	a.NoError(st.Delete("bob"))
+	a.Equal(ErrNotFound, st.Delete("bob"))
+
+	_, err = st.Authenticate("bob", "hunter2")
+	a.Equal(ErrNotFound, err)
+
+	other, err := NewStore(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(other.Put("dave", "secret", RoleViewer))
+
+	a.NoError(st.Reload())
+	role, err = st.Role("dave")
+	a.NoError(err)
+	a.Equal(RoleViewer, role)
+
+	a.NoError(ioutil.WriteFile(path, []byte("invalid"), 0600))
+	a.Error(st.Reload())
+	_, err = st.Role("dave")
+	a.NoError(err)
+}
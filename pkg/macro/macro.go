@@ -0,0 +1,140 @@
+// Package macro implements named sequences of TRC commands and turtle state edits.
+package macro
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// DefaultWaitTimeout is the timeout of a step waiting for a state condition,
+// if none is specified.
+const DefaultWaitTimeout = 30 * time.Second
+
+// Duration is a time.Duration, which is encoded in JSON as a string, e.g. "1.5s".
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(err, "duration must be a string")
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Step is a single step of a Macro.
+// The parts of a step are executed in order: Delay, Command, Turtles, WaitFor.
+type Step struct {
+	// Delay is the time to wait before executing the step.
+	Delay Duration `json:"delay,omitempty"`
+
+	// Command is the command to send to TRC.
+	// Relative commands are resolved using the team color reported by the turtles.
+	Command api.Command `json:"command,omitempty"`
+
+	// Turtles are the turtle states to send to TRC.
+	Turtles map[string]*api.TurtleState `json:"turtles,omitempty"`
+
+	// WaitFor is the condition the state of TRC must satisfy before the step completes.
+	// Every non-empty field in WaitFor must be equal to the respective field of the state.
+	WaitFor *api.State `json:"wait_for,omitempty"`
+
+	// Timeout is the maximum time to wait for WaitFor to be satisfied.
+	// DefaultWaitTimeout is used if Timeout is zero.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// Validate implements api.Validator.
+func (s Step) Validate() error {
+	if s.Delay < 0 || s.Timeout < 0 {
+		return errors.New("durations must not be negative")
+	}
+	if s.Delay == 0 && s.Command == "" && len(s.Turtles) == 0 && s.WaitFor == nil {
+		return errors.New("step is empty")
+	}
+	if s.Command != "" && !s.Command.IsRelative() {
+		if err := s.Command.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(s.Turtles) > 0 {
+		if err := (&api.State{Turtles: s.Turtles}).Validate(); err != nil {
+			return err
+		}
+	}
+	if s.WaitFor != nil {
+		if err := s.WaitFor.Validate(); err != nil {
+			return errors.Wrap(err, "invalid wait condition")
+		}
+	}
+	return nil
+}
+
+// Macro is a named sequence of steps.
+type Macro struct {
+	Name  string `json:"name"`
+	Steps []Step `json:"steps"`
+}
+
+// Validate implements api.Validator.
+func (m *Macro) Validate() error {
+	if m.Name == "" {
+		return errors.New("macro name is empty")
+	}
+	if len(m.Steps) == 0 {
+		return errors.New("macro has no steps")
+	}
+	for i, s := range m.Steps {
+		if err := s.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid step %d", i)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether st satisfies the condition cond, i.e. whether
+// every non-empty field of cond is equal to the respective field of st.
+func Matches(st, cond *api.State) bool {
+	if cond.Command != "" && st.Command != cond.Command {
+		return false
+	}
+
+	for id, cts := range cond.Turtles {
+		if cts == nil {
+			continue
+		}
+
+		ts, ok := st.Turtles[id]
+		if !ok || ts == nil {
+			return false
+		}
+
+		cv := reflect.ValueOf(cts).Elem()
+		tv := reflect.ValueOf(ts).Elem()
+		for i := 0; i < cv.NumField(); i++ {
+			cf := cv.Field(i)
+			if reflect.DeepEqual(cf.Interface(), reflect.Zero(cf.Type()).Interface()) {
+				continue
+			}
+			if !reflect.DeepEqual(cf.Interface(), tv.Field(i).Interface()) {
+				return false
+			}
+		}
+	}
+	return true
+}
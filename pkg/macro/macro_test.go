@@ -0,0 +1,320 @@
+package macro_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	. "github.com/rvolosatovs/turtlitto/pkg/macro"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockExecutor is a mock Executor, which applies received commands and states to its own state.
+type mockExecutor struct {
+	mu       sync.Mutex
+	state    *api.State
+	commands []api.Command
+	subs     map[chan struct{}]struct{}
+}
+
+func newMockExecutor() *mockExecutor {
+	return &mockExecutor{
+		state: &api.State{
+			Turtles: map[string]*api.TurtleState{},
+		},
+		subs: make(map[chan struct{}]struct{}),
+	}
+}
+
+func (e *mockExecutor) notify() {
+	for ch := range e.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *mockExecutor) SetCommand(_ context.Context, cmd api.Command) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.commands = append(e.commands, cmd)
+	e.state.Command = cmd
+	e.notify()
+	return nil
+}
+
+func (e *mockExecutor) SetTurtleState(_ context.Context, st map[string]*api.TurtleState) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for id, ts := range st {
+		e.state.Turtles[id] = ts
+	}
+	e.notify()
+	return nil
+}
+
+func (e *mockExecutor) State(_ context.Context) *api.State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, err := json.Marshal(e.state)
+	if err != nil {
+		panic(err)
+	}
+	st := &api.State{}
+	if err := json.Unmarshal(b, st); err != nil {
+		panic(err)
+	}
+	return st
+}
+
+func (e *mockExecutor) SubscribeStateChanges(_ context.Context) (<-chan struct{}, func(), error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	e.subs[ch] = struct{}{}
+	return ch, func() {
+		e.mu.Lock()
+		delete(e.subs, ch)
+		e.mu.Unlock()
+	}, nil
+}
+
+//Test_items: Matches() in macro.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMatches(t *testing.T) {
+	st := &api.State{
+		Command: api.CommandStart,
+		Turtles: map[string]*api.TurtleState{
+			"1": {
+				Role:         api.RoleGoalkeeper,
+				RobotInField: apitest.BoolPtr(true),
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		Name      string
+		Condition *api.State
+		Expected  bool
+	}{
+		{
+			Name:      "empty",
+			Condition: &api.State{},
+			Expected:  true,
+		},
+		{
+			Name:      "command",
+			Condition: &api.State{Command: api.CommandStart},
+			Expected:  true,
+		},
+		{
+			Name:      "wrong command",
+			Condition: &api.State{Command: api.CommandStop},
+			Expected:  false,
+		},
+		{
+			Name: "turtle field",
+			Condition: &api.State{Turtles: map[string]*api.TurtleState{
+				"1": {RobotInField: apitest.BoolPtr(true)},
+			}},
+			Expected: true,
+		},
+		{
+			Name: "wrong turtle field",
+			Condition: &api.State{Turtles: map[string]*api.TurtleState{
+				"1": {RobotInField: apitest.BoolPtr(false)},
+			}},
+			Expected: false,
+		},
+		{
+			Name: "missing turtle",
+			Condition: &api.State{Turtles: map[string]*api.TurtleState{
+				"2": {},
+			}},
+			Expected: false,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, Matches(st, tc.Condition))
+		})
+	}
+}
+
+//Test_items: Runner in runner.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRunner(t *testing.T) {
+	a := assert.New(t)
+
+	exec := newMockExecutor()
+	r := NewRunner()
+
+	progressCh, closeFn := r.SubscribeProgress()
+	defer closeFn()
+
+	done, err := r.Start(context.Background(), exec, &Macro{
+		Name: "kick_off",
+		Steps: []Step{
+			{Command: api.CommandStop},
+			{Command: api.CommandGoIn},
+			{Turtles: map[string]*api.TurtleState{
+				"1": {Role: api.RoleAttackerMain, TeamColor: api.TeamColorCyan},
+			}},
+			{Command: api.CommandCornerTheirs},
+			{
+				Delay:   Duration(time.Millisecond),
+				Command: api.CommandKickOffCyan,
+				WaitFor: &api.State{Command: api.CommandKickOffCyan},
+			},
+		},
+	})
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for macro to finish")
+	}
+
+	a.Equal([]api.Command{api.CommandStop, api.CommandGoIn, api.CommandCornerMagenta, api.CommandKickOffCyan}, exec.commands)
+	a.Equal(api.RoleAttackerMain, exec.State(context.Background()).Turtles["1"].Role)
+	a.Equal(&Progress{
+		Macro:  "kick_off",
+		Step:   4,
+		Steps:  5,
+		Status: StatusSucceeded,
+	}, r.Progress())
+
+	select {
+	case <-progressCh:
+	default:
+		t.Error("No progress notification received")
+	}
+
+	done, err = r.Start(context.Background(), exec, &Macro{
+		Name: "wait",
+		Steps: []Step{
+			{WaitFor: &api.State{Command: api.CommandPenaltyCyan}},
+		},
+	})
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	_, err = r.Start(context.Background(), exec, &Macro{
+		Name:  "another",
+		Steps: []Step{{Command: api.CommandStart}},
+	})
+	a.Equal(ErrRunning, err)
+
+	a.NoError(r.Cancel())
+	<-done
+	a.Equal(StatusCancelled, r.Progress().Status)
+	a.Equal(ErrNotRunning, r.Cancel())
+
+	done, err = r.Start(context.Background(), exec, &Macro{
+		Name: "wait",
+		Steps: []Step{
+			{WaitFor: &api.State{Command: api.CommandPenaltyCyan}},
+		},
+	})
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	go r.Cancel()
+
+	// Start the next macro as soon as the cancelled one stops running.
+	for {
+		done, err = r.Start(context.Background(), exec, &Macro{
+			Name: "next",
+			Steps: []Step{
+				{WaitFor: &api.State{Command: api.CommandPenaltyCyan}},
+			},
+		})
+		if err != ErrRunning {
+			break
+		}
+	}
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	for p := r.Progress(); p.Macro != "next"; p = r.Progress() {
+		select {
+		case <-progressCh:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for progress of the next macro")
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	a.Equal(&Progress{
+		Macro:  "next",
+		Steps:  1,
+		Status: StatusRunning,
+	}, r.Progress())
+
+	a.NoError(r.Cancel())
+	<-done
+}
+
+//Test_items: Store in store.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestStore(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "macro-test")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "macros.json")
+
+	st, err := NewStore(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Empty(st.List())
+
+	m := &Macro{
+		Name:  "stop",
+		Steps: []Step{{Command: api.CommandStop}},
+	}
+	a.NoError(st.Put(m))
+	a.Error(st.Put(&Macro{Name: "empty"}))
+
+	st, err = NewStore(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Equal([]*Macro{m}, st.List())
+
+	got, err := st.Get("stop")
+	a.NoError(err)
+	a.Equal(m, got)
+
+	a.NoError(st.Delete("stop"))
+	a.Equal(ErrNotFound, st.Delete("stop"))
+
+	_, err = st.Get("stop")
+	a.Equal(ErrNotFound, err)
+}
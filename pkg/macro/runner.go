@@ -0,0 +1,293 @@
+package macro
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrRunning represents an error, which occurs when a macro is started while another one is running.
+	ErrRunning = errors.New("a macro is already running")
+
+	// ErrNotRunning represents an error, which occurs when no macro is running.
+	ErrNotRunning = errors.New("no macro is running")
+)
+
+// Executor executes the steps of a macro.
+// *trcapi.Conn implements Executor.
+type Executor interface {
+	SetCommand(ctx context.Context, cmd api.Command) error
+	SetTurtleState(ctx context.Context, st map[string]*api.TurtleState) error
+	State(ctx context.Context) *api.State
+	SubscribeStateChanges(ctx context.Context) (<-chan struct{}, func(), error)
+}
+
+// Status is the status of a macro execution.
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Progress is the progress of a macro execution.
+type Progress struct {
+	// Macro is the name of the macro.
+	Macro string `json:"macro"`
+
+	// Step is the index of the step being executed.
+	Step int `json:"step"`
+
+	// Steps is the total amount of steps in the macro.
+	Steps int `json:"steps"`
+
+	// Status is the status of the execution.
+	Status Status `json:"status"`
+
+	// Error is the error the execution failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// waitFor blocks until the state of exec satisfies cond, ctx is done or timeout expires.
+func waitFor(ctx context.Context, exec Executor, cond *api.State, timeout time.Duration) error {
+	ch, closeFn, err := exec.SubscribeStateChanges(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to subscribe to state changes")
+	}
+	defer closeFn()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for !Matches(exec.State(ctx), cond) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-timer.C:
+			return errors.New("timed out waiting for state condition")
+
+		case _, ok := <-ch:
+			if !ok {
+				return errors.New("state subscription closed")
+			}
+		}
+	}
+	return nil
+}
+
+// Run executes the steps of m using exec.
+// f is called with the index of every step before it is executed.
+// Run blocks until all steps are executed, a step fails or ctx is done.
+func Run(ctx context.Context, exec Executor, m *Macro, f func(step int)) error {
+	logger := logcontext.Logger(ctx).With(zap.String("macro", m.Name))
+
+	for i, s := range m.Steps {
+		if f != nil {
+			f(i)
+		}
+
+		logger := logger.With(zap.Int("step", i))
+		if s.Delay > 0 {
+			logger.Debug("Delaying step...", zap.Duration("delay", time.Duration(s.Delay)))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(s.Delay)):
+			}
+		}
+
+		if s.Command != "" {
+			cmd := s.Command
+			if cmd.IsRelative() {
+				tc, err := exec.State(ctx).TeamColor()
+				if err != nil {
+					return errors.Wrapf(err, "step %d: failed to determine team color", i)
+				}
+				if cmd, err = cmd.Resolve(tc); err != nil {
+					return errors.Wrapf(err, "step %d", i)
+				}
+			}
+
+			logger.Debug("Sending command...", zap.String("command", string(cmd)))
+			if err := exec.SetCommand(ctx, cmd); err != nil {
+				return errors.Wrapf(err, "step %d: failed to send command", i)
+			}
+		}
+
+		if len(s.Turtles) > 0 {
+			logger.Debug("Sending turtle state...", zap.Reflect("turtles", s.Turtles))
+			if err := exec.SetTurtleState(ctx, s.Turtles); err != nil {
+				return errors.Wrapf(err, "step %d: failed to send turtle state", i)
+			}
+		}
+
+		if s.WaitFor != nil {
+			timeout := time.Duration(s.Timeout)
+			if timeout == 0 {
+				timeout = DefaultWaitTimeout
+			}
+
+			logger.Debug("Waiting for state condition...", zap.Reflect("condition", s.WaitFor))
+			if err := waitFor(ctx, exec, s.WaitFor, timeout); err != nil {
+				return errors.Wrapf(err, "step %d", i)
+			}
+		}
+	}
+	return nil
+}
+
+// Runner runs at most one macro at a time and tracks its progress.
+// Runner is safe for concurrent use by multiple goroutines.
+type Runner struct {
+	mu       sync.RWMutex
+	cancel   context.CancelFunc
+	done     chan struct{}
+	progress *Progress
+
+	subsMu sync.RWMutex
+	subs   map[chan struct{}]struct{}
+}
+
+// NewRunner returns a new *Runner.
+func NewRunner() *Runner {
+	return &Runner{
+		subs: make(map[chan struct{}]struct{}),
+	}
+}
+
+// setProgress updates the progress and notifies the subscribers.
+func (r *Runner) setProgress(p *Progress) {
+	r.mu.Lock()
+	r.progress = p
+	r.mu.Unlock()
+	r.notify()
+}
+
+// notify notifies the subscribers about a progress change.
+func (r *Runner) notify() {
+	r.subsMu.RLock()
+	for ch := range r.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	r.subsMu.RUnlock()
+}
+
+// Start starts executing m using exec in a separate goroutine.
+// Start returns a channel, which is closed once the execution is finished.
+func (r *Runner) Start(ctx context.Context, exec Executor, m *Macro) (<-chan struct{}, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.mu.Unlock()
+		return nil, ErrRunning
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	r.cancel = cancel
+	r.done = done
+	r.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		defer cancel()
+
+		p := Progress{
+			Macro:  m.Name,
+			Steps:  len(m.Steps),
+			Status: StatusRunning,
+		}
+		err := Run(ctx, exec, m, func(step int) {
+			p := p
+			p.Step = step
+			r.setProgress(&p)
+		})
+
+		switch {
+		case err == nil:
+			p.Status = StatusSucceeded
+		case ctx.Err() == context.Canceled:
+			p.Status = StatusCancelled
+		default:
+			p.Status = StatusFailed
+			p.Error = err.Error()
+		}
+		logcontext.Logger(ctx).Info("Macro finished",
+			zap.String("macro", m.Name),
+			zap.String("status", string(p.Status)),
+			zap.Error(err),
+		)
+
+		// The final progress must be published before another macro can be started,
+		// otherwise it could overwrite the progress of that macro.
+		r.mu.Lock()
+		if r.progress != nil {
+			p.Step = r.progress.Step
+		}
+		r.progress = &p
+		r.cancel = nil
+		r.done = nil
+		r.mu.Unlock()
+		r.notify()
+	}()
+	return done, nil
+}
+
+// Cancel cancels the running macro and waits for it to stop.
+func (r *Runner) Cancel() error {
+	r.mu.RLock()
+	cancel, done := r.cancel, r.done
+	r.mu.RUnlock()
+
+	if cancel == nil {
+		return ErrNotRunning
+	}
+	cancel()
+	<-done
+	return nil
+}
+
+// Progress returns the progress of the last macro execution or nil, if none was started.
+func (r *Runner) Progress() *Progress {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.progress == nil {
+		return nil
+	}
+	p := *r.progress
+	return &p
+}
+
+// SubscribeProgress opens a subscription to progress changes.
+// SubscribeProgress returns a read-only channel, on which a value is sent
+// every time the progress changes and a function, which must be used to close the subscription.
+func (r *Runner) SubscribeProgress() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	r.subsMu.Lock()
+	r.subs[ch] = struct{}{}
+	r.subsMu.Unlock()
+
+	return ch, func() {
+		r.subsMu.Lock()
+		delete(r.subs, ch)
+		r.subsMu.Unlock()
+		close(ch)
+	}
+}
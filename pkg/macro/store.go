@@ -0,0 +1,146 @@
+package macro
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound represents an error, which occurs when a macro does not exist.
+var ErrNotFound = errors.New("macro not found")
+
+// Store stores macros by name.
+// If the Store is backed by a file, every modification is persisted to it.
+// Store is safe for concurrent use by multiple goroutines.
+type Store struct {
+	path string
+
+	mu     sync.RWMutex
+	macros map[string]*Macro
+}
+
+// NewStore returns a new *Store backed by the file at path.
+// The macros stored in the file are loaded, if it exists.
+// If path is empty, the macros are only stored in memory.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:   path,
+		macros: make(map[string]*Macro),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read macros")
+	}
+
+	var ms []*Macro
+	if err := json.Unmarshal(b, &ms); err != nil {
+		return nil, errors.Wrap(err, "failed to decode macros")
+	}
+	for _, m := range ms {
+		if err := m.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid macro %s", m.Name)
+		}
+		s.macros[m.Name] = m
+	}
+	return s, nil
+}
+
+// list returns the stored macros sorted by name.
+// list must be called with s.mu held.
+func (s *Store) list() []*Macro {
+	ms := make([]*Macro, 0, len(s.macros))
+	for _, m := range s.macros {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Name < ms[j].Name })
+	return ms
+}
+
+// save persists the stored macros.
+// save must be called with s.mu held.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.list(), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrap(err, "failed to write macros")
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// List returns all stored macros sorted by name.
+func (s *Store) List() []*Macro {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list()
+}
+
+// Get returns the macro named name.
+func (s *Store) Get(name string) (*Macro, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.macros[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m, nil
+}
+
+// Put stores m, replacing the macro with the same name, if such exists.
+func (s *Store) Put(m *Macro) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.macros[m.Name]
+	s.macros[m.Name] = m
+	if err := s.save(); err != nil {
+		if ok {
+			s.macros[m.Name] = old
+		} else {
+			delete(s.macros, m.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the macro named name.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.macros[name]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(s.macros, name)
+	if err := s.save(); err != nil {
+		s.macros[name] = m
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+// Package match tracks the phase and the clock of a match from the stream of referee commands.
+package match
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// DefaultHalfDuration is the default duration of a half.
+const DefaultHalfDuration = 15 * time.Minute
+
+// Period is a period of a match.
+type Period string
+
+const (
+	PeriodPreGame    Period = "pre_game"
+	PeriodFirstHalf  Period = "first_half"
+	PeriodHalfTime   Period = "half_time"
+	PeriodSecondHalf Period = "second_half"
+	PeriodFullTime   Period = "full_time"
+)
+
+// Phase is a phase of play within a period.
+type Phase string
+
+const (
+	PhaseStopped  Phase = "stopped"
+	PhaseKickOff  Phase = "kick_off"
+	PhaseSetPiece Phase = "set_piece"
+	PhasePenalty  Phase = "penalty"
+	PhasePlaying  Phase = "playing"
+)
+
+// State is the state of a match.
+type State struct {
+	// Period is the current period.
+	Period Period `json:"period"`
+
+	// Phase is the current phase of play.
+	Phase Phase `json:"phase"`
+
+	// Command is the last referee command.
+	Command api.Command `json:"command,omitempty"`
+
+	// Elapsed is the time played in the current period in milliseconds.
+	Elapsed int64 `json:"elapsed_ms"`
+
+	// Running reports whether the clock is running.
+	Running bool `json:"running"`
+}
+
+// Tracker derives the State of a match from referee commands.
+// The clock runs in the first and second half between `start` and the next command.
+// Tracker is safe for concurrent use by multiple goroutines.
+type Tracker struct {
+	halfDuration time.Duration
+	now          func() time.Time
+
+	mu      sync.RWMutex
+	period  Period
+	phase   Phase
+	command api.Command
+	elapsed time.Duration
+	// startedAt is the time the clock was last resumed, zero if it is paused.
+	startedAt time.Time
+
+	subsMu sync.RWMutex
+	subs   map[chan struct{}]struct{}
+}
+
+// Option represents a Tracker option.
+type Option func(*Tracker)
+
+// WithHalfDuration allows to specify the duration of a half.
+func WithHalfDuration(d time.Duration) Option {
+	return func(t *Tracker) {
+		t.halfDuration = d
+	}
+}
+
+// WithClock allows to specify the function used to get the current time.
+func WithClock(now func() time.Time) Option {
+	return func(t *Tracker) {
+		t.now = now
+	}
+}
+
+// NewTracker returns a new *Tracker.
+func NewTracker(opts ...Option) *Tracker {
+	t := &Tracker{
+		halfDuration: DefaultHalfDuration,
+		now:          time.Now,
+		subs:         make(map[chan struct{}]struct{}),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.reset()
+	return t
+}
+
+// reset resets the match.
+// reset must be called with t.mu held.
+func (t *Tracker) reset() {
+	t.period = PeriodPreGame
+	t.phase = PhaseStopped
+	t.command = ""
+	t.elapsed = 0
+	t.startedAt = time.Time{}
+}
+
+// pause pauses the clock at now.
+// pause must be called with t.mu held.
+func (t *Tracker) pause(now time.Time) {
+	if t.startedAt.IsZero() {
+		return
+	}
+	t.elapsed += now.Sub(t.startedAt)
+	t.startedAt = time.Time{}
+}
+
+// inHalf reports whether a half is being played.
+// inHalf must be called with t.mu held.
+func (t *Tracker) inHalf() bool {
+	return t.period == PeriodFirstHalf || t.period == PeriodSecondHalf
+}
+
+// notify notifies the subscribers about a state change.
+func (t *Tracker) notify() {
+	t.subsMu.RLock()
+	for ch := range t.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	t.subsMu.RUnlock()
+}
+
+// Observe updates the state of the match given a referee command cmd.
+// Commands, which do not affect the match, e.g. `go_in` or demo commands, are ignored.
+func (t *Tracker) Observe(cmd api.Command) {
+	t.mu.Lock()
+
+	now := t.now()
+	switch cmd {
+	case api.CommandStart:
+		if !t.inHalf() {
+			t.mu.Unlock()
+			return
+		}
+		t.phase = PhasePlaying
+		if t.startedAt.IsZero() {
+			t.startedAt = now
+		}
+
+	case api.CommandStop:
+		t.pause(now)
+		t.phase = PhaseStopped
+		if t.inHalf() && t.elapsed >= t.halfDuration {
+			if t.period == PeriodFirstHalf {
+				t.period = PeriodHalfTime
+			} else {
+				t.period = PeriodFullTime
+			}
+		}
+
+	case api.CommandKickOffCyan, api.CommandKickOffMagenta:
+		t.pause(now)
+		switch t.period {
+		case PeriodPreGame, PeriodFullTime:
+			t.reset()
+			t.period = PeriodFirstHalf
+		case PeriodHalfTime:
+			t.period = PeriodSecondHalf
+			t.elapsed = 0
+		}
+		t.phase = PhaseKickOff
+
+	case api.CommandPenaltyCyan, api.CommandPenaltyMagenta:
+		t.pause(now)
+		t.phase = PhasePenalty
+
+	case api.CommandFreeKickCyan, api.CommandFreeKickMagenta,
+		api.CommandGoalKickCyan, api.CommandGoalKickMagenta,
+		api.CommandThrowInCyan, api.CommandThrowInMagenta,
+		api.CommandCornerCyan, api.CommandCornerMagenta,
+		api.CommandDroppedBall:
+		t.pause(now)
+		t.phase = PhaseSetPiece
+
+	default:
+		t.mu.Unlock()
+		return
+	}
+	t.command = cmd
+	t.mu.Unlock()
+
+	t.notify()
+}
+
+// Reset resets the match to the pre-game period.
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	t.reset()
+	t.mu.Unlock()
+
+	t.notify()
+}
+
+// State returns the current state of the match.
+func (t *Tracker) State() *State {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	elapsed := t.elapsed
+	if !t.startedAt.IsZero() {
+		elapsed += t.now().Sub(t.startedAt)
+	}
+	return &State{
+		Period:  t.period,
+		Phase:   t.phase,
+		Command: t.command,
+		Elapsed: int64(elapsed / time.Millisecond),
+		Running: !t.startedAt.IsZero(),
+	}
+}
+
+// Subscribe opens a subscription to state changes.
+// Subscribe returns a read-only channel, on which a value is sent every time
+// the period or phase changes and a function, which must be used to close the subscription.
+func (t *Tracker) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	t.subsMu.Lock()
+	t.subs[ch] = struct{}{}
+	t.subsMu.Unlock()
+
+	return ch, func() {
+		t.subsMu.Lock()
+		delete(t.subs, ch)
+		t.subsMu.Unlock()
+		close(ch)
+	}
+}
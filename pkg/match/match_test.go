@@ -0,0 +1,100 @@
+package match_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	. "github.com/rvolosatovs/turtlitto/pkg/match"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Tracker in match.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestTracker(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Unix(0, 0)
+	tr := NewTracker(
+		WithHalfDuration(10*time.Minute),
+		WithClock(func() time.Time { return now }),
+	)
+
+	ch, closeFn := tr.Subscribe()
+	defer closeFn()
+
+	a.Equal(&State{
+		Period: PeriodPreGame,
+		Phase:  PhaseStopped,
+	}, tr.State())
+
+	tr.Observe(api.CommandStart)
+	a.Equal(PeriodPreGame, tr.State().Period, "start before kick-off must be ignored")
+
+	tr.Observe(api.CommandKickOffCyan)
+	a.Equal(&State{
+		Period:  PeriodFirstHalf,
+		Phase:   PhaseKickOff,
+		Command: api.CommandKickOffCyan,
+	}, tr.State())
+
+	select {
+	case <-ch:
+	default:
+		t.Error("No state change notification received")
+	}
+
+	tr.Observe(api.CommandStart)
+	now = now.Add(4 * time.Minute)
+	a.Equal(&State{
+		Period:  PeriodFirstHalf,
+		Phase:   PhasePlaying,
+		Command: api.CommandStart,
+		Elapsed: (4 * time.Minute).Nanoseconds() / 1e6,
+		Running: true,
+	}, tr.State())
+
+	tr.Observe(api.CommandFreeKickMagenta)
+	now = now.Add(time.Minute)
+	st := tr.State()
+	a.Equal(PhaseSetPiece, st.Phase)
+	a.False(st.Running)
+	a.Equal((4 * time.Minute).Nanoseconds()/1e6, st.Elapsed)
+
+	tr.Observe(api.CommandGoIn)
+	a.Equal(api.CommandFreeKickMagenta, tr.State().Command, "go_in must be ignored")
+
+	tr.Observe(api.CommandStart)
+	now = now.Add(6 * time.Minute)
+	tr.Observe(api.CommandStop)
+	a.Equal(&State{
+		Period:  PeriodHalfTime,
+		Phase:   PhaseStopped,
+		Command: api.CommandStop,
+		Elapsed: (10 * time.Minute).Nanoseconds() / 1e6,
+	}, tr.State())
+
+	tr.Observe(api.CommandKickOffMagenta)
+	a.Equal(&State{
+		Period:  PeriodSecondHalf,
+		Phase:   PhaseKickOff,
+		Command: api.CommandKickOffMagenta,
+	}, tr.State())
+
+	tr.Observe(api.CommandStart)
+	now = now.Add(11 * time.Minute)
+	tr.Observe(api.CommandPenaltyCyan)
+	a.Equal(PhasePenalty, tr.State().Phase)
+	a.Equal(PeriodSecondHalf, tr.State().Period)
+
+	tr.Observe(api.CommandStop)
+	a.Equal(PeriodFullTime, tr.State().Period)
+
+	tr.Reset()
+	a.Equal(&State{
+		Period: PeriodPreGame,
+		Phase:  PhaseStopped,
+	}, tr.State())
+}
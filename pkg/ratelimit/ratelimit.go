@@ -0,0 +1,228 @@
+// Package ratelimit implements rate limiting, debouncing and lockout keyed by arbitrary strings.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter limits the rate of events per key using a token bucket.
+// Limiter is safe for concurrent use by multiple goroutines.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*bucket
+}
+
+// NewLimiter returns a new *Limiter, which allows rate events per second per key
+// with bursts of at most burst events.
+// If rate is not positive, all events are allowed.
+func NewLimiter(rate float64, burst int) *Limiter {
+	l := &Limiter{
+		buckets: make(map[string]*bucket),
+	}
+	l.SetLimit(rate, burst)
+	return l
+}
+
+// SetLimit changes the rate and burst size of l.
+func (l *Limiter) SetLimit(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	l.rate = rate
+	l.burst = burst
+	l.mu.Unlock()
+}
+
+// Allow reports whether an event for key may happen now and consumes a token, if so.
+// If the event is not allowed, Allow returns the duration after which it would be.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens: float64(l.burst),
+			last:   now,
+		}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > float64(l.burst) {
+		b.tokens = float64(l.burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Remove removes the state of key.
+func (l *Limiter) Remove(key string) {
+	l.mu.Lock()
+	delete(l.buckets, key)
+	l.mu.Unlock()
+}
+
+// event is the last event accepted by a Debouncer.
+type event struct {
+	value string
+	time  time.Time
+}
+
+// Debouncer suppresses identical consecutive events per key.
+// Debouncer is safe for concurrent use by multiple goroutines.
+type Debouncer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[string]event
+}
+
+// NewDebouncer returns a new *Debouncer, which suppresses an event if it is identical
+// to the previous event for the same key and happens within interval after it.
+// If interval is not positive, no events are suppressed.
+func NewDebouncer(interval time.Duration) *Debouncer {
+	return &Debouncer{
+		interval: interval,
+		last:     make(map[string]event),
+	}
+}
+
+// Allow reports whether the event with value for key should be processed.
+// Allow records the event as the last one for key, if so.
+func (d *Debouncer) Allow(key, value string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.interval <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	if ev, ok := d.last[key]; ok && ev.value == value && now.Sub(ev.time) < d.interval {
+		return false
+	}
+	d.last[key] = event{
+		value: value,
+		time:  now,
+	}
+	return true
+}
+
+// SetInterval changes the interval of d.
+func (d *Debouncer) SetInterval(interval time.Duration) {
+	d.mu.Lock()
+	d.interval = interval
+	d.mu.Unlock()
+}
+
+// Remove removes the state of key.
+func (d *Debouncer) Remove(key string) {
+	d.mu.Lock()
+	delete(d.last, key)
+	d.mu.Unlock()
+}
+
+// failures is the failure record of a key.
+type failures struct {
+	count int
+	until time.Time
+}
+
+// Lockout locks keys out after repeated failures.
+// Lockout is safe for concurrent use by multiple goroutines.
+type Lockout struct {
+	mu       sync.Mutex
+	attempts int
+	duration time.Duration
+	failures map[string]*failures
+}
+
+// NewLockout returns a new *Lockout, which locks a key out for duration
+// after attempts consecutive failures.
+// If attempts is not positive, keys are never locked out.
+func NewLockout(attempts int, duration time.Duration) *Lockout {
+	return &Lockout{
+		attempts: attempts,
+		duration: duration,
+		failures: make(map[string]*failures),
+	}
+}
+
+// Locked reports whether key is locked out and the duration after which the lockout expires.
+func (l *Lockout) Locked(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok || f.until.IsZero() {
+		return false, 0
+	}
+
+	d := time.Until(f.until)
+	if d <= 0 {
+		delete(l.failures, key)
+		return false, 0
+	}
+	return true, d
+}
+
+// Fail records a failure for key.
+// Fail reports whether key is locked out as a result.
+func (l *Lockout) Fail(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.attempts <= 0 {
+		return false
+	}
+
+	f, ok := l.failures[key]
+	if !ok {
+		f = &failures{}
+		l.failures[key] = f
+	}
+	f.count++
+	if f.count < l.attempts {
+		return false
+	}
+	f.count = 0
+	f.until = time.Now().Add(l.duration)
+	return true
+}
+
+// SetPolicy changes the amount of attempts and the duration of the lockout of l.
+// Keys, which are already locked out, stay locked out until their lockout expires.
+func (l *Lockout) SetPolicy(attempts int, duration time.Duration) {
+	l.mu.Lock()
+	l.attempts = attempts
+	l.duration = duration
+	l.mu.Unlock()
+}
+
+// Reset removes the failures recorded for key.
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	delete(l.failures, key)
+	l.mu.Unlock()
+}
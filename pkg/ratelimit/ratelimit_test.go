@@ -0,0 +1,99 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/rvolosatovs/turtlitto/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Limiter in ratelimit.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	l := NewLimiter(1, 2)
+
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Allow("a")
+		a.True(ok)
+	}
+	ok, d := l.Allow("a")
+	a.False(ok)
+	a.True(d > 0 && d <= time.Second, "unexpected retry duration %s", d)
+
+	ok, _ = l.Allow("b")
+	a.True(ok)
+
+	l.Remove("a")
+	ok, _ = l.Allow("a")
+	a.True(ok)
+
+	l.SetLimit(0, 0)
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow("a")
+		a.True(ok)
+	}
+}
+
+//Test_items: Debouncer in ratelimit.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestDebouncer(t *testing.T) {
+	a := assert.New(t)
+
+	d := NewDebouncer(50 * time.Millisecond)
+
+	a.True(d.Allow("a", "start"))
+	a.False(d.Allow("a", "start"))
+	a.True(d.Allow("b", "start"))
+	a.True(d.Allow("a", "stop"))
+	a.True(d.Allow("a", "start"))
+
+	time.Sleep(60 * time.Millisecond)
+	a.True(d.Allow("a", "start"))
+
+	d.Remove("a")
+	a.True(d.Allow("a", "start"))
+
+	d.SetInterval(0)
+	a.True(d.Allow("a", "start"))
+	a.True(d.Allow("a", "start"))
+}
+
+//Test_items: Lockout in ratelimit.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestLockout(t *testing.T) {
+	a := assert.New(t)
+
+	l := NewLockout(2, 50*time.Millisecond)
+
+	a.False(l.Fail("a"))
+	l.Reset("a")
+	a.False(l.Fail("a"))
+	locked, _ := l.Locked("a")
+	a.False(locked)
+
+	a.True(l.Fail("a"))
+	locked, d := l.Locked("a")
+	a.True(locked)
+	a.True(d > 0 && d <= 50*time.Millisecond, "unexpected lockout duration %s", d)
+
+	locked, _ = l.Locked("b")
+	a.False(locked)
+
+	time.Sleep(60 * time.Millisecond)
+	locked, _ = l.Locked("a")
+	a.False(locked)
+
+	l.SetPolicy(0, time.Hour)
+	for i := 0; i < 10; i++ {
+		a.False(l.Fail("a"))
+	}
+}
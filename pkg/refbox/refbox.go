@@ -0,0 +1,156 @@
+// Package refbox implements a client of the RoboCup MSL referee box.
+//
+// The referee box sends every referee signal as a single byte over TCP.
+// Signals concerning team cyan are upper-case, signals concerning team magenta are lower-case.
+package refbox
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"go.uber.org/zap"
+)
+
+// DefaultReconnectInterval is the default interval between reconnection attempts.
+const DefaultReconnectInterval = 5 * time.Second
+
+// signals maps the referee box signals to commands.
+var signals = map[byte]api.Command{
+	'S': api.CommandStop,
+	's': api.CommandStart,
+	'N': api.CommandDroppedBall,
+	'K': api.CommandKickOffCyan,
+	'k': api.CommandKickOffMagenta,
+	'F': api.CommandFreeKickCyan,
+	'f': api.CommandFreeKickMagenta,
+	'G': api.CommandGoalKickCyan,
+	'g': api.CommandGoalKickMagenta,
+	'T': api.CommandThrowInCyan,
+	't': api.CommandThrowInMagenta,
+	'C': api.CommandCornerCyan,
+	'c': api.CommandCornerMagenta,
+	'P': api.CommandPenaltyCyan,
+	'p': api.CommandPenaltyMagenta,
+}
+
+// Translate returns the command corresponding to the referee box signal b.
+// Translate returns false if b has no corresponding command, e.g. for goal
+// or half signals, which TRC does not need.
+func Translate(b byte) (api.Command, bool) {
+	cmd, ok := signals[b]
+	return cmd, ok
+}
+
+// Handler handles a command received from the referee box.
+type Handler func(ctx context.Context, cmd api.Command) error
+
+// Client is a client of the referee box.
+type Client struct {
+	addr              string
+	reconnectInterval time.Duration
+	dial              func(addr string) (net.Conn, error)
+}
+
+// Option represents a Client option.
+type Option func(*Client)
+
+// WithReconnectInterval allows to specify the interval between reconnection attempts.
+func WithReconnectInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.reconnectInterval = d
+	}
+}
+
+// New returns a new *Client of the referee box at TCP address addr.
+func New(addr string, opts ...Option) *Client {
+	c := &Client{
+		addr:              addr,
+		reconnectInterval: DefaultReconnectInterval,
+		dial: func(addr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, DefaultReconnectInterval)
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Addr returns the address of the referee box.
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// serve reads signals from r and calls h for every command until r fails or ctx is done.
+func (c *Client) serve(ctx context.Context, r io.Reader, h Handler) error {
+	logger := logcontext.Logger(ctx)
+
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			cmd, ok := Translate(b)
+			if !ok {
+				logger.Debug("Ignoring referee box signal", zap.String("signal", string(b)))
+				continue
+			}
+
+			logger.Info("Received referee box command", zap.String("command", string(cmd)))
+			if err := h(ctx, cmd); err != nil {
+				logger.Error("Failed to handle referee box command",
+					zap.String("command", string(cmd)),
+					zap.Error(err),
+				)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Run connects to the referee box and calls h for every command received.
+// Run reconnects if the connection fails and blocks until ctx is done.
+func (c *Client) Run(ctx context.Context, h Handler) error {
+	logger := logcontext.Logger(ctx).With(zap.String("refbox_addr", c.addr))
+	ctx = logcontext.WithLogger(ctx, logger)
+
+	for {
+		logger.Debug("Connecting to referee box...")
+		conn, err := c.dial(c.addr)
+		if err != nil {
+			logger.Warn("Failed to connect to referee box", zap.Error(err))
+		} else {
+			logger.Info("Connected to referee box")
+
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-ctx.Done():
+					conn.Close()
+				case <-done:
+				}
+			}()
+
+			err = c.serve(ctx, conn, h)
+			close(done)
+			conn.Close()
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warn("Connection to referee box lost", zap.Error(errors.Wrap(err, "failed to read signal")))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.reconnectInterval):
+		}
+	}
+}
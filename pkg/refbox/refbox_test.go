@@ -0,0 +1,98 @@
+package refbox_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	. "github.com/rvolosatovs/turtlitto/pkg/refbox"
+	"github.com/stretchr/testify/assert"
+)
+
+const timeout = time.Second
+
+//Test_items: Translate() in refbox.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestTranslate(t *testing.T) {
+	a := assert.New(t)
+
+	for b, expected := range map[byte]api.Command{
+		'K': api.CommandKickOffCyan,
+		'k': api.CommandKickOffMagenta,
+		'S': api.CommandStop,
+		's': api.CommandStart,
+		'N': api.CommandDroppedBall,
+		'c': api.CommandCornerMagenta,
+	} {
+		cmd, ok := Translate(b)
+		a.True(ok)
+		a.Equal(expected, cmd)
+	}
+
+	_, ok := Translate('A')
+	a.False(ok)
+}
+
+//Test_items: Run() in refbox.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: TCP on localhost
+func TestRun(t *testing.T) {
+	a := assert.New(t)
+
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer lst.Close()
+
+	// The referee box stand-in sends signals and drops the connection twice.
+	go func() {
+		for _, signals := range []string{"WKs", "1fS"} {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(signals))
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmdCh := make(chan api.Command)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- New(lst.Addr().String(), WithReconnectInterval(10*time.Millisecond)).Run(ctx, func(_ context.Context, cmd api.Command) error {
+			cmdCh <- cmd
+			return nil
+		})
+	}()
+
+	for _, expected := range []api.Command{
+		api.CommandKickOffCyan,
+		api.CommandStart,
+		api.CommandFreeKickMagenta,
+		api.CommandStop,
+	} {
+		select {
+		case cmd := <-cmdCh:
+			a.Equal(expected, cmd)
+		case <-time.After(timeout):
+			t.Fatalf("Timed out waiting for %s", expected)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		a.Equal(context.Canceled, err)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for Run to return")
+	}
+}
@@ -0,0 +1,190 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MaxFrameSize is the maximum size of the payload of a frame.
+const MaxFrameSize = 1 << 16
+
+const (
+	frameMagic0 = 0xAA
+	frameMagic1 = 0x55
+
+	// frameHeaderSize is the size of the magic and the length of the payload.
+	frameHeaderSize = 6
+	// frameTrailerSize is the size of the CRC.
+	frameTrailerSize = 4
+)
+
+// FrameError represents an error, which occurs when corrupt bytes are received.
+// The corrupt bytes are skipped and reading continues with the next valid frame.
+type FrameError struct {
+	// Skipped is the amount of skipped bytes.
+	Skipped int
+	// Reason describes the first corruption encountered.
+	Reason string
+}
+
+// Error implements error.
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("skipped %d corrupt bytes: %s", e.Skipped, e.Reason)
+}
+
+// Framed is a byte stream carried by length-prefixed frames with CRC over another stream.
+// Each write is sent as a single frame, hence each api.Message encoded by
+// a json.Encoder is carried by exactly one frame.
+//
+// A frame consists of the magic bytes 0xAA 0x55, the length of the payload as big-endian uint32,
+// the payload and the IEEE CRC-32 of the length and the payload as big-endian uint32.
+//
+// Corrupt frames are skipped and reported to the frame error handler, hence corrupt bytes
+// received on an unreliable link, such as a serial port, cause the loss of the affected messages,
+// but the stream of the remaining messages stays intact.
+type Framed struct {
+	rw io.ReadWriter
+
+	readMu       sync.Mutex
+	reader       *bufio.Reader
+	payload      []byte
+	handleErrors func(*FrameError)
+
+	writeMu sync.Mutex
+}
+
+// FramedOption represents a Framed option.
+type FramedOption func(*Framed)
+
+// WithFrameErrorHandler allows to specify the function, which is called with every FrameError.
+// By default, FrameErrors are ignored.
+// f is called from the goroutine calling Read and must not block.
+func WithFrameErrorHandler(f func(*FrameError)) FramedOption {
+	return func(c *Framed) {
+		c.handleErrors = f
+	}
+}
+
+// NewFramed returns a new *Framed carrying the stream over rw.
+func NewFramed(rw io.ReadWriter, opts ...FramedOption) *Framed {
+	c := &Framed{
+		rw:           rw,
+		reader:       bufio.NewReaderSize(rw, frameHeaderSize+MaxFrameSize+frameTrailerSize),
+		handleErrors: func(*FrameError) {},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Read reads the payloads of the incoming valid frames.
+func (c *Framed) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(c.payload) == 0 {
+		pld, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.payload = pld
+	}
+
+	n := copy(b, c.payload)
+	c.payload = c.payload[n:]
+	return n, nil
+}
+
+// readFrame returns the payload of the next valid frame.
+// readFrame must be called with c.readMu held.
+func (c *Framed) readFrame() ([]byte, error) {
+	ferr := &FrameError{}
+	// skip skips the first byte of the corrupt frame, so that a frame starting within it is found.
+	skip := func(reason string) {
+		if ferr.Skipped == 0 {
+			ferr.Reason = reason
+		}
+		ferr.Skipped++
+		c.reader.Discard(1) //nolint
+	}
+	defer func() {
+		if ferr.Skipped > 0 {
+			c.handleErrors(ferr)
+		}
+	}()
+
+	for {
+		hdr, err := c.reader.Peek(frameHeaderSize)
+		if err != nil {
+			if err == io.EOF && len(hdr) > 0 {
+				if ferr.Skipped == 0 {
+					ferr.Reason = "incomplete frame header"
+				}
+				ferr.Skipped += len(hdr)
+			}
+			return nil, err
+		}
+
+		if hdr[0] != frameMagic0 || hdr[1] != frameMagic1 {
+			skip("invalid magic")
+			continue
+		}
+
+		n := binary.BigEndian.Uint32(hdr[2:])
+		if n > MaxFrameSize {
+			skip(fmt.Sprintf("frame size %d exceeds maximum of %d", n, MaxFrameSize))
+			continue
+		}
+
+		frame, err := c.reader.Peek(frameHeaderSize + int(n) + frameTrailerSize)
+		if err != nil {
+			if err == io.EOF {
+				if ferr.Skipped == 0 {
+					ferr.Reason = "incomplete frame"
+				}
+				ferr.Skipped += len(frame)
+			}
+			return nil, err
+		}
+
+		body := frame[2 : frameHeaderSize+n]
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(frame[frameHeaderSize+n:]) {
+			skip("CRC mismatch")
+			continue
+		}
+
+		pld := make([]byte, n)
+		copy(pld, frame[frameHeaderSize:])
+		c.reader.Discard(len(frame)) //nolint
+		return pld, nil
+	}
+}
+
+// Write sends b as a single frame.
+func (c *Framed) Write(b []byte) (int, error) {
+	if len(b) > MaxFrameSize {
+		return 0, errors.Errorf("frame size %d exceeds maximum of %d", len(b), MaxFrameSize)
+	}
+
+	frame := make([]byte, frameHeaderSize+len(b)+frameTrailerSize)
+	frame[0] = frameMagic0
+	frame[1] = frameMagic1
+	binary.BigEndian.PutUint32(frame[2:], uint32(len(b)))
+	copy(frame[frameHeaderSize:], b)
+	binary.BigEndian.PutUint32(frame[frameHeaderSize+len(b):], crc32.ChecksumIEEE(frame[2:frameHeaderSize+len(b)]))
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if _, err := c.rw.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,12 @@
+package transport
+
+import "os"
+
+// DefaultBaudRate is the default baud rate of serial devices.
+const DefaultBaudRate = 115200
+
+// Serial is a serial device opened by OpenSerial.
+// Serial does not frame the stream, use NewFramed to detect corruption.
+type Serial struct {
+	*os.File
+}
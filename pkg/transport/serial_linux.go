@@ -0,0 +1,85 @@
+// +build linux
+
+package transport
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// cbaud is the mask of the baud rate bits of the control flags.
+const cbaud = 0x100f
+
+// baudRates are the supported baud rates.
+var baudRates = map[int]uint32{
+	1200:    syscall.B1200,
+	2400:    syscall.B2400,
+	4800:    syscall.B4800,
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	500000:  syscall.B500000,
+	576000:  syscall.B576000,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+	1500000: syscall.B1500000,
+	2000000: syscall.B2000000,
+	3000000: syscall.B3000000,
+	4000000: syscall.B4000000,
+}
+
+// ioctl performs the ioctl request req on fd with argument arg.
+func ioctl(fd uintptr, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// OpenSerial opens the serial device at path in raw mode with 8 data bits, no parity,
+// 1 stop bit and the baud rate baud.
+func OpenSerial(path string, baud int) (*Serial, error) {
+	speed, ok := baudRates[baud]
+	if !ok {
+		return nil, errors.Errorf("unsupported baud rate: %d", baud)
+	}
+
+	fd, err := syscall.Open(path, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open serial device")
+	}
+
+	var t syscall.Termios
+	if err := ioctl(uintptr(fd), syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrap(err, "failed to get serial device attributes")
+	}
+
+	// Equivalent of cfmakeraw(3).
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | cbaud
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+	t.Ispeed = speed
+	t.Ospeed = speed
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(uintptr(fd), syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrap(err, "failed to set serial device attributes")
+	}
+
+	// The file descriptor is non-blocking, hence reads are interrupted by Close.
+	return &Serial{
+		File: os.NewFile(uintptr(fd), path),
+	}, nil
+}
@@ -0,0 +1,114 @@
+// +build linux
+
+package transport_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	. "github.com/rvolosatovs/turtlitto/pkg/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// openPTY opens a pseudo-terminal pair and returns the master and the path to the slave.
+func openPTY(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("Pseudo-terminals are not available: %s", err)
+	}
+
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Fatalf("Failed to unlock pseudo-terminal: %s", errno)
+	}
+
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		t.Fatalf("Failed to get pseudo-terminal number: %s", errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+//Test_items: OpenSerial() in serial_linux.go, Framed in framed.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: Linux pseudo-terminals
+func TestSerial(t *testing.T) {
+	a := assert.New(t)
+
+	master, slave := openPTY(t)
+	defer master.Close()
+
+	_, err := OpenSerial(slave, 42)
+	a.Error(err)
+
+	serial, err := OpenSerial(slave, DefaultBaudRate)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer serial.Close()
+
+	trcFramed := NewFramed(master)
+	trc := trctest.Connect(trcFramed, trcFramed,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		trctest.WithHandler(api.MessageTypeState, trctest.DefaultStateHandler),
+	)
+	defer trc.Close()
+
+	errCh := make(chan *FrameError, 1)
+	srrsFramed := NewFramed(serial, WithFrameErrorHandler(func(err *FrameError) {
+		errCh <- err
+	}))
+
+	go trc.SendHandshake(&api.Handshake{
+		Version: trcapi.DefaultVersion,
+		Token:   "test",
+	})
+
+	conn, err := trcapi.Connect(trcapi.DefaultVersion, srrsFramed, srrsFramed)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	a.NoError(conn.SetCommand(ctx, api.CommandStop))
+	a.Equal(api.CommandStop, conn.State(ctx).Command)
+
+	// Corrupt bytes received on the serial line do not break the connection.
+	_, err = master.Write([]byte("noise"))
+	a.NoError(err)
+
+	subCh, unsubscribe, err := conn.SubscribeStateChanges(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer unsubscribe()
+
+	a.NoError(trc.SendState(&api.State{Command: api.CommandGoIn}))
+	select {
+	case <-subCh:
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for state update")
+	}
+	a.Equal(api.CommandGoIn, conn.State(ctx).Command)
+
+	select {
+	case err := <-errCh:
+		a.Equal(len("noise"), err.Skipped)
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for frame error")
+	}
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package transport
+
+import "github.com/pkg/errors"
+
+// OpenSerial opens the serial device at path.
+// Serial devices are only supported on Linux.
+func OpenSerial(path string, baud int) (*Serial, error) {
+	return nil, errors.New("serial devices are only supported on Linux")
+}
@@ -0,0 +1,243 @@
+package transport_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	. "github.com/rvolosatovs/turtlitto/pkg/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// transportCase establishes connections of SRRS and TRC over a transport.
+type transportCase struct {
+	Name string
+
+	// Listen starts accepting connections, connCh receives the SRRS-side connection.
+	// Listen returns the function, which dials the TRC side, and the cleanup function.
+	Listen func(t *testing.T, connCh chan<- net.Conn) (dial func(opts ...trctest.Option) (*trctest.Conn, func(), error), cleanup func())
+}
+
+// listenNet returns the Listen function of a transportCase, which uses net.Listen and net.Dial.
+func listenNet(network string, addr func(t *testing.T) string) func(t *testing.T, connCh chan<- net.Conn) (func(opts ...trctest.Option) (*trctest.Conn, func(), error), func()) {
+	return func(t *testing.T, connCh chan<- net.Conn) (func(opts ...trctest.Option) (*trctest.Conn, func(), error), func()) {
+		lst, err := net.Listen(network, addr(t))
+		if err != nil {
+			t.Fatalf("Failed to listen: %s", err)
+		}
+		go func() {
+			for {
+				conn, err := lst.Accept()
+				if err != nil {
+					return
+				}
+				connCh <- conn
+			}
+		}()
+
+		return func(opts ...trctest.Option) (*trctest.Conn, func(), error) {
+			netConn, err := net.Dial(network, lst.Addr().String())
+			if err != nil {
+				return nil, nil, err
+			}
+			trc := trctest.Connect(netConn, netConn, opts...)
+			return trc, func() {
+				trc.Close()
+				netConn.Close()
+			}, nil
+		}, func() { lst.Close() }
+	}
+}
+
+//Test_items: WebSocket, DialWebSocket(), WebSocketHandler() in websocket.go, DialWebSocket() in trctest
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestTransports(t *testing.T) {
+	for _, tc := range []transportCase{
+		{
+			Name: "unix",
+			Listen: listenNet("unix", func(*testing.T) string {
+				return filepath.Join(os.TempDir(), fmt.Sprintf("transport-%d.sock", os.Getpid()))
+			}),
+		},
+		{
+			Name: "tcp",
+			Listen: listenNet("tcp", func(*testing.T) string {
+				return "127.0.0.1:0"
+			}),
+		},
+		{
+			Name: "websocket",
+			Listen: func(t *testing.T, connCh chan<- net.Conn) (func(opts ...trctest.Option) (*trctest.Conn, func(), error), func()) {
+				srv := httptest.NewServer(WebSocketHandler(nil, func(ws *WebSocket) {
+					connCh <- ws
+				}))
+				return func(opts ...trctest.Option) (*trctest.Conn, func(), error) {
+					trc, err := trctest.DialWebSocket("ws"+strings.TrimPrefix(srv.URL, "http")+"/trc", opts...)
+					if err != nil {
+						return nil, nil, err
+					}
+					return trc, func() { trc.Close() }, nil
+				}, srv.Close
+			},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			a := assert.New(t)
+
+			connCh := make(chan net.Conn, 1)
+			dial, cleanup := tc.Listen(t, connCh)
+			defer cleanup()
+
+			trc, closeTRC, err := dial(
+				trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+				trctest.WithHandler(api.MessageTypePing, trctest.DefaultPingHandler),
+				trctest.WithHandler(api.MessageTypeState, trctest.DefaultStateHandler),
+			)
+			if !a.NoError(err) {
+				t.FailNow()
+			}
+
+			var netConn net.Conn
+			select {
+			case netConn = <-connCh:
+			case <-time.After(time.Second):
+				t.Fatal("Timed out waiting for the connection to be accepted")
+			}
+			defer netConn.Close()
+
+			go trc.SendHandshake(&api.Handshake{
+				Version: trcapi.DefaultVersion,
+				Token:   "test",
+				FleetID: "field1",
+			})
+
+			conn, err := trcapi.Connect(trcapi.DefaultVersion, netConn, netConn)
+			if !a.NoError(err) {
+				t.FailNow()
+			}
+			defer conn.Close()
+
+			tok, err := conn.Token()
+			a.NoError(err)
+			a.Equal("test", tok)
+			a.Equal("field1", conn.FleetID())
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			subCh, unsubscribe, err := conn.SubscribeStateChanges(ctx)
+			if !a.NoError(err) {
+				t.FailNow()
+			}
+			defer unsubscribe()
+
+			st := apitest.RandomFleetState(trcapi.DefaultFleetSize)
+			a.NoError(trc.SendState(st))
+			select {
+			case <-subCh:
+			case <-ctx.Done():
+				t.Fatal("Timed out waiting for state update")
+			}
+			got := conn.State(ctx)
+			a.Equal(st.Command, got.Command)
+			for id, ts := range st.Turtles {
+				a.Equal(ts, got.Turtles[id])
+			}
+
+			a.NoError(conn.Ping(ctx))
+			a.NoError(conn.SetCommand(ctx, api.CommandStop))
+			a.Equal(api.CommandStop, conn.State(ctx).Command)
+
+			// The connection is closed by TRC.
+			closeTRC()
+			select {
+			case _, ok := <-conn.Errors():
+				a.False(ok)
+			case <-time.After(time.Second):
+				t.Fatal("Timed out waiting for the connection to be closed")
+			}
+		})
+	}
+}
+
+// readWriter is an io.ReadWriter composed of r and w.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+//Test_items: Framed, NewFramed(), WithFrameErrorHandler() in framed.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestFramed(t *testing.T) {
+	a := assert.New(t)
+
+	msgs := []*api.Message{
+		api.NewMessage(api.MessageTypeState, []byte(`{"command":"stop"}`), nil),
+		api.NewMessage(api.MessageTypeState, []byte(`{"command":"start"}`), nil),
+		api.NewMessage(api.MessageTypePing, nil, nil),
+		api.NewMessage(api.MessageTypeState, []byte(`{"command":"go_in"}`), nil),
+	}
+
+	var frames [][]byte
+	for _, msg := range msgs {
+		buf := &bytes.Buffer{}
+		if !a.NoError(json.NewEncoder(NewFramed(buf)).Encode(msg)) {
+			t.FailNow()
+		}
+		frames = append(frames, buf.Bytes())
+	}
+
+	stream := &bytes.Buffer{}
+	stream.Write(frames[0])
+	// Garbage between frames.
+	stream.WriteString("garbage")
+	stream.Write(frames[1])
+	// Corrupt payload.
+	frames[2][len(frames[2])/2] ^= 0xff
+	stream.Write(frames[2])
+	stream.Write(frames[3])
+	// Truncated frame.
+	stream.Write(frames[0][:len(frames[0])-1])
+
+	var errs []*FrameError
+	dec := json.NewDecoder(NewFramed(readWriter{Reader: stream}, WithFrameErrorHandler(func(err *FrameError) {
+		errs = append(errs, err)
+	})))
+	for _, expected := range []*api.Message{msgs[0], msgs[1], msgs[3]} {
+		var msg api.Message
+		if !a.NoError(dec.Decode(&msg)) {
+			t.FailNow()
+		}
+		a.Equal(expected.MessageID, msg.MessageID)
+		a.Equal(expected.Type, msg.Type)
+	}
+	var msg api.Message
+	a.Equal(io.EOF, dec.Decode(&msg))
+
+	if a.Len(errs, 3) {
+		a.Equal(&FrameError{Skipped: len("garbage"), Reason: "invalid magic"}, errs[0])
+		a.Equal(len(frames[2]), errs[1].Skipped)
+		a.Equal("CRC mismatch", errs[1].Reason)
+		a.Equal(&FrameError{Skipped: len(frames[0]) - 1, Reason: "incomplete frame"}, errs[2])
+	}
+
+	_, err := NewFramed(&bytes.Buffer{}).Write(make([]byte, MaxFrameSize+1))
+	a.Error(err)
+}
@@ -0,0 +1,159 @@
+// Package transport implements transports of the TRC protocol, which is carried over byte streams.
+package transport
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// closeTimeout is the time, within which the close message must be written on Close.
+const closeTimeout = time.Second
+
+// WebSocket is a net.Conn, which carries a byte stream over a WebSocket.
+// Each write is sent as a single text message, hence each api.Message encoded by
+// a json.Encoder is carried by exactly one WebSocket message.
+// Reads return the contents of consecutive messages as one stream.
+type WebSocket struct {
+	ws *websocket.Conn
+
+	readMu  sync.Mutex
+	reader  io.Reader
+	readErr error
+
+	writeMu sync.Mutex
+}
+
+// NewWebSocket returns a new *WebSocket carrying the stream over ws.
+func NewWebSocket(ws *websocket.Conn) *WebSocket {
+	return &WebSocket{
+		ws: ws,
+	}
+}
+
+// DialWebSocket dials the WebSocket at url using d and returns a *WebSocket carrying the stream over it.
+// If d is nil, websocket.DefaultDialer is used.
+func DialWebSocket(d *websocket.Dialer, url string, h http.Header) (*WebSocket, error) {
+	if d == nil {
+		d = websocket.DefaultDialer
+	}
+	ws, _, err := d.Dial(url, h)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open WebSocket")
+	}
+	return NewWebSocket(ws), nil
+}
+
+// WebSocketHandler returns a http.Handler, which upgrades requests to WebSocket using u
+// and calls handle with the *WebSocket carrying the stream over it.
+// handle takes ownership of the *WebSocket, which stays open after handle returns.
+// If u is nil, an Upgrader with default options is used.
+func WebSocketHandler(u *websocket.Upgrader, handle func(*WebSocket)) http.Handler {
+	if u == nil {
+		u = &websocket.Upgrader{}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			zap.L().Debug("Failed to upgrade to WebSocket",
+				zap.String("addr", r.RemoteAddr),
+				zap.Error(err),
+			)
+			return
+		}
+		handle(NewWebSocket(ws))
+	})
+}
+
+// Read reads the contents of the incoming messages.
+// io.EOF is returned once the peer closes the WebSocket normally.
+func (c *WebSocket) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+
+		if c.reader == nil {
+			_, r, err := c.ws.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					err = io.EOF
+				}
+				// gorilla/websocket panics on repeated reads after a failure, hence the error is kept.
+				c.readErr = err
+				return 0, err
+			}
+			c.reader = r
+		}
+
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write sends b as a single text message.
+func (c *WebSocket) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.ws.WriteMessage(websocket.TextMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close sends a close message and closes the underlying connection.
+func (c *WebSocket) Close() error {
+	// The close message is best-effort, the peer may already be gone.
+	_ = c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(closeTimeout),
+	)
+	return c.ws.Close()
+}
+
+// LocalAddr returns the local network address.
+func (c *WebSocket) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *WebSocket) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *WebSocket) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *WebSocket) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *WebSocket) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
+
+var _ net.Conn = &WebSocket{}
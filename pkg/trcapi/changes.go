@@ -0,0 +1,120 @@
+package trcapi
+
+import (
+	"context"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"go.uber.org/zap"
+)
+
+// changeBufferSize is the amount of changes buffered per change subscription.
+const changeBufferSize = 64
+
+// ChangeFilter reports whether a change is sent to a subscriber.
+type ChangeFilter func(api.Change) bool
+
+// TurtleFilter returns a ChangeFilter, which passes the changes of turtles identified by ids.
+// Changes of the command are not passed.
+func TurtleFilter(ids ...string) ChangeFilter {
+	m := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		m[id] = struct{}{}
+	}
+	return func(ch api.Change) bool {
+		_, ok := m[ch.TurtleID]
+		return ok && ch.TurtleID != ""
+	}
+}
+
+// FieldFilter returns a ChangeFilter, which passes the changes of fields.
+func FieldFilter(fields ...api.Field) ChangeFilter {
+	m := make(map[api.Field]struct{}, len(fields))
+	for _, f := range fields {
+		m[f] = struct{}{}
+	}
+	return func(ch api.Change) bool {
+		_, ok := m[ch.Field]
+		return ok
+	}
+}
+
+// passes reports whether change passes all filters.
+func passes(change api.Change, filters []ChangeFilter) bool {
+	for _, f := range filters {
+		if !f(change) {
+			return false
+		}
+	}
+	return true
+}
+
+// SubscribeChanges opens a subscription to changes of fields of the state.
+// SubscribeChanges returns read-only channel, on which the changes passing all filters are sent
+// in the order they occur, and a function, which must be used to close the subscription.
+// E.g. SubscribeChanges(ctx, TurtleFilter("3"), FieldFilter(api.FieldBatteryVoltage)) subscribes to changes
+// of the battery voltage of turtle 3.
+// The changes are buffered, if the buffer is full, changes are missed. The channel is closed once the connection is closed.
+func (c *Conn) SubscribeChanges(ctx context.Context, filters ...ChangeFilter) (<-chan api.Change, func(), error) {
+	if c.isShutdown() {
+		return nil, nil, ErrClosed
+	}
+
+	c.closeChMu.RLock()
+	defer c.closeChMu.RUnlock()
+
+	select {
+	case <-c.closeCh:
+		return nil, nil, ErrClosed
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	default:
+	}
+
+	c.changeSubsMu.Lock()
+	ch := make(chan api.Change, changeBufferSize)
+	c.changeSubs[ch] = filters
+	c.changeSubsMu.Unlock()
+
+	return ch, func() {
+		c.changeSubsMu.Lock()
+		_, ok := c.changeSubs[ch]
+		delete(c.changeSubs, ch)
+		c.changeSubsMu.Unlock()
+
+		if !ok {
+			// Channel was already closed by Close
+			return
+		}
+		close(ch)
+	}, nil
+}
+
+// publishChanges sends the changes of the state from old to st to the change subscribers.
+func (c *Conn) publishChanges(old, st *api.State) {
+	logger := zap.L()
+
+	c.changeSubsMu.RLock()
+	defer c.changeSubsMu.RUnlock()
+
+	if len(c.changeSubs) == 0 {
+		return
+	}
+
+	changes := api.Diff(old, st)
+	for ch, filters := range c.changeSubs {
+		for _, change := range changes {
+			if !passes(change, filters) {
+				continue
+			}
+
+			select {
+			case ch <- change:
+			default:
+				logger.Debug("Change subscription buffer is full, skipping change...",
+					zap.String("turtle_id", change.TurtleID),
+					zap.String("field", string(change.Field)),
+				)
+			}
+		}
+	}
+}
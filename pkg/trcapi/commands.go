@@ -0,0 +1,66 @@
+package trcapi
+
+import (
+	"context"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"go.uber.org/zap"
+)
+
+// commandBufferSize is the amount of commands buffered per command subscription.
+const commandBufferSize = 16
+
+// SubscribeCommands opens a subscription to commands.
+// SubscribeCommands returns read-only channel, on which every command sent to TRC and acknowledged by it
+// and every command sent by TRC in a state message is sent, and a function, which must be used to close the subscription.
+// Contrary to SubscribeChanges, repeated identical commands are sent, e.g. a second `kick_off_cyan` in a row.
+// The commands are buffered, if the buffer is full, commands are missed. The channel is closed once the connection is closed.
+func (c *Conn) SubscribeCommands(ctx context.Context) (<-chan api.Command, func(), error) {
+	if c.isShutdown() {
+		return nil, nil, ErrClosed
+	}
+
+	c.closeChMu.RLock()
+	defer c.closeChMu.RUnlock()
+
+	select {
+	case <-c.closeCh:
+		return nil, nil, ErrClosed
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	default:
+	}
+
+	c.commandSubsMu.Lock()
+	ch := make(chan api.Command, commandBufferSize)
+	c.commandSubs[ch] = struct{}{}
+	c.commandSubsMu.Unlock()
+
+	return ch, func() {
+		c.commandSubsMu.Lock()
+		_, ok := c.commandSubs[ch]
+		delete(c.commandSubs, ch)
+		c.commandSubsMu.Unlock()
+
+		if !ok {
+			// Channel was already closed by Close
+			return
+		}
+		close(ch)
+	}, nil
+}
+
+// publishCommand sends cmd to the command subscribers.
+func (c *Conn) publishCommand(cmd api.Command) {
+	logger := zap.L()
+
+	c.commandSubsMu.RLock()
+	for ch := range c.commandSubs {
+		select {
+		case ch <- cmd:
+		default:
+			logger.Debug("Command subscription buffer is full, skipping command...", zap.String("command", string(cmd)))
+		}
+	}
+	c.commandSubsMu.RUnlock()
+}
@@ -4,9 +4,12 @@ package trcapi
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/blang/semver"
 	"github.com/mohae/deepcopy"
@@ -20,6 +23,16 @@ import (
 // DefaultVersion represents the default protocol version.
 var DefaultVersion = semver.MustParse("1.0.0")
 
+// DefaultFleetSize is the default amount of turtles controlled by TRC.
+const DefaultFleetSize = 6
+
+// errorBufferSize is the amount of errors buffered per error subscription.
+const errorBufferSize = 16
+
+// stateRequestTimeout is the time, within which TRC must respond to a state request sent
+// after the handshake or to resynchronize the state.
+const stateRequestTimeout = 10 * time.Second
+
 // ErrClosed represents an error, which occurs when the *Conn is closed.
 var ErrClosed = errors.New("Conn is closed")
 
@@ -36,59 +49,126 @@ type decoder interface {
 // Conn is a connection to TRC.
 // Conn is safe for concurrent use by multiple goroutines.
 type Conn struct {
-	version semver.Version
-	token   *atomic.Value
+	version   semver.Version
+	token     *atomic.Value
+	secret    []byte
+	fleetSize int
+	fleetID   string
+
+	// fleetOptions returns the options specific to the fleet identified in the handshake.
+	fleetOptions func(fleetID string) ([]Option, error)
 
 	decoder decoder
 	encoder encoder
 
+	// closeChMu is read-locked by pending requests and write-locked by Shutdown.
 	closeChMu *sync.RWMutex
 	closeCh   chan struct{}
+	closeOnce *sync.Once
+
+	// shutdownCh is closed once Shutdown is called, so that new requests fail
+	// without waiting for closeChMu, which is held by Shutdown while draining.
+	shutdownCh   chan struct{}
+	shutdownOnce *sync.Once
+
+	// synchronizedCh is closed once the complete state of TRC is received for the first time.
+	synchronizedCh   chan struct{}
+	synchronizedOnce *sync.Once
 
+	errSubsMu *sync.RWMutex
+	errSubs   map[chan<- error]struct{}
+	// errCh is the error subscription returned by Errors.
 	errCh chan error
 
 	stateMu *sync.RWMutex
 	// state is the current state of TRC.
 	state *api.State
+	// seq is the sequence number of the last state message applied to state.
+	seq uint64
 
 	stateSubsMu *sync.RWMutex
 	stateSubs   map[chan<- struct{}]struct{}
 
+	changeSubsMu *sync.RWMutex
+	changeSubs   map[chan<- api.Change][]ChangeFilter
+
+	commandSubsMu *sync.RWMutex
+	commandSubs   map[chan<- api.Command]struct{}
+
 	pendingReqsMu *sync.RWMutex
 	pendingReqs   map[ulid.ULID]chan *api.Message
+
+	// resyncCh is a semaphore, which ensures at most one resynchronization is performed at a time.
+	resyncCh chan struct{}
+}
+
+// Option represents a Conn option.
+type Option func(*Conn)
+
+// WithSecret allows to specify the secret shared with TRC.
+// If specified, the challenge-response handshake is required and TRC is
+// authenticated by it. Otherwise, the plaintext token sent by TRC is used.
+func WithSecret(secret []byte) Option {
+	return func(c *Conn) {
+		c.secret = secret
+	}
+}
+
+// WithFleetSize allows to specify the amount of turtles controlled by TRC.
+func WithFleetSize(n int) Option {
+	return func(c *Conn) {
+		c.fleetSize = n
+	}
+}
+
+// WithFleetOptions allows to specify options, which depend on the fleet ID sent by TRC in the handshake.
+// f is called with the fleet ID once the handshake request is received and the returned options are applied.
+// If f returns an error, the handshake fails.
+func WithFleetOptions(f func(fleetID string) ([]Option, error)) Option {
+	return func(c *Conn) {
+		c.fleetOptions = f
+	}
 }
 
 // Connect establishes the SRRS-side connection according to TRC API protocol
 // specification of version ver.
 // Messages are written to w and read from r.
-func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
+// Connect returns once the handshake is complete. The complete state of TRC is requested
+// in the background; until it is received, the state of every turtle is unknown.
+// See Synchronized.
+func Connect(ver semver.Version, w io.Writer, r io.Reader, opts ...Option) (*Conn, error) {
 	logger := zap.L()
 
-	dec := json.NewDecoder(r)
-	dec.DisallowUnknownFields()
 	conn := &Conn{
-		version:   ver,
-		token:     &atomic.Value{},
-		closeChMu: &sync.RWMutex{},
-		closeCh:   make(chan struct{}),
-		decoder:   dec,
-		encoder:   json.NewEncoder(w),
-		errCh:     make(chan error),
-		stateMu:   &sync.RWMutex{},
-		state: &api.State{
-			Turtles: map[string]*api.TurtleState{
-				"1": {},
-				"2": {},
-				"3": {},
-				"4": {},
-				"5": {},
-				"6": {},
-			},
-		},
-		stateSubsMu:   &sync.RWMutex{},
-		stateSubs:     make(map[chan<- struct{}]struct{}),
-		pendingReqsMu: &sync.RWMutex{},
-		pendingReqs:   make(map[ulid.ULID]chan *api.Message),
+		version:          ver,
+		token:            &atomic.Value{},
+		closeChMu:        &sync.RWMutex{},
+		closeCh:          make(chan struct{}),
+		closeOnce:        &sync.Once{},
+		shutdownCh:       make(chan struct{}),
+		shutdownOnce:     &sync.Once{},
+		synchronizedCh:   make(chan struct{}),
+		synchronizedOnce: &sync.Once{},
+		decoder:          newLineDecoder(r),
+		encoder:          json.NewEncoder(w),
+		errSubsMu:        &sync.RWMutex{},
+		errSubs:          make(map[chan<- error]struct{}),
+		errCh:            make(chan error, errorBufferSize),
+		stateMu:          &sync.RWMutex{},
+		fleetSize:        DefaultFleetSize,
+		stateSubsMu:      &sync.RWMutex{},
+		stateSubs:        make(map[chan<- struct{}]struct{}),
+		changeSubsMu:     &sync.RWMutex{},
+		changeSubs:       make(map[chan<- api.Change][]ChangeFilter),
+		commandSubsMu:    &sync.RWMutex{},
+		commandSubs:      make(map[chan<- api.Command]struct{}),
+		pendingReqsMu:    &sync.RWMutex{},
+		pendingReqs:      make(map[ulid.ULID]chan *api.Message),
+		resyncCh:         make(chan struct{}, 1),
+	}
+	conn.errSubs[conn.errCh] = struct{}{}
+	for _, opt := range opts {
+		opt(conn)
 	}
 
 	var req api.Message
@@ -118,8 +198,22 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 	}
 	logger.Debug("Handshake payload decoded successfully",
 		zap.Stringer("version", hs.Version),
+		zap.String("fleet_id", hs.FleetID),
 	)
 
+	conn.fleetID = hs.FleetID
+	if conn.fleetOptions != nil {
+		opts, err := conn.fleetOptions(hs.FleetID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to configure fleet `%s`", hs.FleetID)
+		}
+		for _, opt := range opts {
+			opt(conn)
+		}
+	}
+
+	conn.state = conn.newState()
+
 	resp := &api.Handshake{
 		Version: hs.Version,
 	}
@@ -131,8 +225,26 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 	}
 	conn.version = resp.Version
 
-	logger.Debug("Updating token...")
-	conn.token.Store(hs.Token)
+	switch {
+	case len(conn.secret) > 0 && hs.Challenge == "":
+		return nil, errors.New("TRC did not send a challenge, but challenge-response handshake is required")
+
+	case len(conn.secret) == 0 && hs.Challenge != "":
+		return nil, errors.New("TRC sent a challenge, but no secret is configured")
+
+	case hs.Challenge != "":
+		logger.Debug("Responding to challenge...")
+		nonce, err := NewNonce()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate challenge")
+		}
+		resp.Challenge = nonce
+		resp.Response = SRRSResponse(conn.secret, hs.Challenge, nonce)
+
+	default:
+		logger.Debug("Updating token...")
+		conn.token.Store(hs.Token)
+	}
 
 	b, err := json.Marshal(resp)
 	if err != nil {
@@ -142,30 +254,47 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 	logger.Debug("Encoding handshake response...",
 		zap.Stringer("version", resp.Version),
 	)
-	if err := conn.encoder.Encode(api.NewMessage(req.Type, b, &req.MessageID)); err != nil {
+	respMsg := api.NewMessage(req.Type, b, &req.MessageID)
+	if err := conn.encoder.Encode(respMsg); err != nil {
 		return nil, err
 	}
 
+	if resp.Challenge != "" {
+		if err := conn.verifyChallengeResponse(hs.Challenge, resp.Challenge, respMsg.MessageID); err != nil {
+			return nil, err
+		}
+	}
+
 	go func() {
 		for {
 			var msg api.Message
 			err := conn.decoder.Decode(&msg)
 			if err == io.EOF {
-				logger.Debug("EOF during decoding - closing error channel, return...")
-				close(conn.errCh)
+				logger.Debug("EOF during decoding - closing connection, return...")
+				conn.Close()
 				return
 			}
 
 			select {
 			case <-conn.closeCh:
-				logger.Debug("Conn closed - closing error channel, return...")
+				logger.Debug("Conn closed, return...")
 				// Don't handle err if connection is closed
-				close(conn.errCh)
 				return
 			default:
 			}
+			if derr, ok := err.(*DecodeError); ok {
+				logger.Warn("Skipping malformed message",
+					zap.ByteString("data", derr.Data),
+					zap.Error(derr.Err),
+				)
+				conn.reportError(derr)
+				go conn.resync()
+				continue
+			}
 			if err != nil {
-				conn.errCh <- errors.Wrap(err, "failed to decode incoming message")
+				logger.Error("Failed to read from TRC - closing connection, return...", zap.Error(err))
+				conn.reportError(&TransportError{Err: err})
+				conn.Close()
 				return
 			}
 			logger := logger.With(
@@ -180,38 +309,42 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 				}
 
 				if err := conn.encoder.Encode(api.NewMessage(api.MessageTypePing, nil, &msg.MessageID)); err != nil {
-					conn.errCh <- errors.Wrap(err, "failed to encode ping message")
+					conn.reportError(&TransportError{Err: errors.Wrap(err, "failed to encode ping message")})
 					continue
 				}
 
 			case api.MessageTypeState:
-				conn.stateMu.Lock()
-				st := deepcopy.Copy(conn.state).(*api.State)
-				if err := json.Unmarshal(msg.Payload, st); err != nil {
-					conn.stateMu.Unlock()
-					conn.errCh <- errors.Wrap(err, "failed to decode state message payload")
+				conn.applyState(logger, &msg)
+
+			case api.MessageTypeGetState:
+				if msg.ParentID == nil {
+					logger.Warn("Ignoring state request of TRC")
+					conn.reportError(&ProtocolError{Reason: "TRC sent a state request"})
 					continue
 				}
 
-				logger.Debug("Received state update", zap.Reflect("state", st))
+				// The state is replaced here, so that the state updates following the response are applied to it.
+				st := conn.newState()
+				if err := json.Unmarshal(msg.Payload, st); err != nil {
+					logger.Warn("Received state response with malformed payload", zap.Error(err))
+					conn.reportError(&DecodeError{Data: msg.Payload, Err: err})
+					break
+				}
+
+				logger.Debug("Received complete state", zap.Reflect("state", st))
 
+				conn.stateMu.Lock()
+				old := conn.state
 				conn.state = st
+				conn.seq = msg.Seq
 				conn.stateMu.Unlock()
 
-				conn.stateSubsMu.RLock()
-				for ch := range conn.stateSubs {
-					select {
-					case ch <- struct{}{}:
-						logger.Debug("Sending state update notification...")
-					default:
-						logger.Debug("Skipping state update...")
-					}
-				}
-				conn.stateSubsMu.RUnlock()
+				conn.notifyStateChange(old, st)
 
 			default:
-				logger.Error("Received message of unmatched type")
-				conn.errCh <- errors.Errorf("unmatched message type: %s", msg.Type)
+				logger.Error("Received message of unmatched type - closing connection, return...")
+				conn.reportError(&UnexpectedTypeError{Type: msg.Type})
+				conn.Close()
 				return
 			}
 
@@ -228,13 +361,169 @@ func Connect(ver semver.Version, w io.Writer, r io.Reader) (*Conn, error) {
 			conn.pendingReqsMu.RUnlock()
 		}
 	}()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), stateRequestTimeout)
+		defer cancel()
+
+		logger.Debug("Requesting initial state...")
+		if err := conn.RequestState(ctx); err != nil && err != ErrClosed {
+			logger.Warn("Failed to request initial state, the state is unknown until resynchronized", zap.Error(err))
+		}
+	}()
 	return conn, nil
 }
 
+// newState returns the state of a fleet of c.fleetSize turtles, the state of which is unknown.
+func (c *Conn) newState() *api.State {
+	st := &api.State{
+		Turtles: make(map[string]*api.TurtleState, c.fleetSize),
+	}
+	for i := 1; i <= c.fleetSize; i++ {
+		st.Turtles[strconv.Itoa(i)] = &api.TurtleState{}
+	}
+	return st
+}
+
+// verifyChallengeResponse reads the response of TRC to the challenge srrsNonce sent in the message
+// with ID parentID and verifies it. If the response is valid, the token derived from the nonces is stored.
+func (c *Conn) verifyChallengeResponse(trcNonce, srrsNonce string, parentID ulid.ULID) error {
+	var msg api.Message
+	if err := c.decoder.Decode(&msg); err != nil {
+		return errors.Wrap(err, "failed to decode challenge response message")
+	}
+	if msg.Type != api.MessageTypeHandshake {
+		return errors.Errorf("expected message of type %s, got %s", api.MessageTypeHandshake, msg.Type)
+	}
+	if msg.ParentID == nil || *msg.ParentID != parentID {
+		return errors.New("challenge response does not respond to the challenge")
+	}
+
+	var hs api.Handshake
+	if err := json.Unmarshal(msg.Payload, &hs); err != nil {
+		return errors.Wrap(err, "failed to decode challenge response")
+	}
+	if !validResponse(hs.Response, TRCResponse(c.secret, trcNonce, srrsNonce)) {
+		return errors.New("invalid challenge response")
+	}
+
+	zap.L().Debug("Challenge response verified, updating token...")
+	c.token.Store(HandshakeToken(c.secret, trcNonce, srrsNonce))
+	return nil
+}
+
+// applyState applies the state message msg to the state.
+// Stale state messages are skipped. If a gap in the sequence numbers is detected,
+// msg is applied and the state is resynchronized, since the missing messages may not be superseded by msg.
+func (c *Conn) applyState(logger *zap.Logger, msg *api.Message) {
+	logger = logger.With(zap.Uint64("seq", msg.Seq))
+
+	c.stateMu.Lock()
+	last := c.seq
+	if msg.Seq != 0 && msg.Seq <= last {
+		c.stateMu.Unlock()
+		logger.Warn("Skipping stale state message", zap.Uint64("last_seq", last))
+		c.reportError(&ProtocolError{Reason: fmt.Sprintf("stale state message %d received after %d", msg.Seq, last)})
+		return
+	}
+
+	st := deepcopy.Copy(c.state).(*api.State)
+	if err := json.Unmarshal(msg.Payload, st); err != nil {
+		c.stateMu.Unlock()
+		logger.Warn("Skipping state message with malformed payload", zap.Error(err))
+		c.reportError(&DecodeError{Data: msg.Payload, Err: err})
+		go c.resync()
+		return
+	}
+
+	logger.Debug("Received state update", zap.Reflect("state", st))
+
+	old := c.state
+	c.state = st
+	if msg.Seq != 0 {
+		c.seq = msg.Seq
+	}
+	c.stateMu.Unlock()
+
+	c.notifyStateChange(old, st)
+
+	if msg.ParentID == nil {
+		// The command is only present in the payload if TRC sent one, which may equal the current one.
+		var upd api.State
+		if err := json.Unmarshal(msg.Payload, &upd); err == nil && upd.Command != "" {
+			c.publishCommand(upd.Command)
+		}
+	}
+
+	if msg.Seq != 0 && msg.Seq != last+1 {
+		logger.Warn("Gap in state message sequence numbers detected", zap.Uint64("last_seq", last))
+		c.reportError(&ProtocolError{Reason: fmt.Sprintf("state message %d received after %d", msg.Seq, last)})
+		go c.resync()
+	}
+}
+
+// reportError sends err to the error subscribers.
+// Subscribers, the buffers of which are full, miss err, so that the reader goroutine never blocks.
+func (c *Conn) reportError(err error) {
+	logger := zap.L()
+
+	c.errSubsMu.RLock()
+	for ch := range c.errSubs {
+		select {
+		case ch <- err:
+		default:
+			logger.Debug("Error subscription buffer is full, skipping error...", zap.Error(err))
+		}
+	}
+	c.errSubsMu.RUnlock()
+}
+
+// notifyStateChange notifies the subscribers of state changes of the change of the state from old to st.
+// notifyStateChange must only be called by the reader goroutine, so that changes are published in order.
+func (c *Conn) notifyStateChange(old, st *api.State) {
+	logger := zap.L()
+
+	c.stateSubsMu.RLock()
+	for ch := range c.stateSubs {
+		select {
+		case ch <- struct{}{}:
+			logger.Debug("Sending state update notification...")
+		default:
+			logger.Debug("Skipping state update...")
+		}
+	}
+	c.stateSubsMu.RUnlock()
+
+	c.publishChanges(old, st)
+}
+
+// resync requests the complete state from TRC and replaces the current state with it.
+// resync is a no-op if another resynchronization is in progress.
+func (c *Conn) resync() {
+	select {
+	case c.resyncCh <- struct{}{}:
+	default:
+		return
+	}
+	defer func() { <-c.resyncCh }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stateRequestTimeout)
+	defer cancel()
+
+	zap.L().Debug("Resynchronizing state with TRC...")
+	if err := c.RequestState(ctx); err != nil && err != ErrClosed {
+		zap.L().Warn("Failed to resynchronize state with TRC", zap.Error(err))
+	}
+}
+
 // sendRequest sends a request of type typ with payload pld and waits for the response.
 func (c *Conn) sendRequest(ctx context.Context, typ api.MessageType, pld interface{}) (json.RawMessage, error) {
 	logger := zap.L()
 
+	if c.isShutdown() {
+		return nil, ErrClosed
+	}
+
 	c.closeChMu.RLock()
 	defer c.closeChMu.RUnlock()
 
@@ -283,6 +572,9 @@ func (c *Conn) sendRequest(ctx context.Context, typ api.MessageType, pld interfa
 	case <-ctx.Done():
 		logger.Debug("Context done, cancelling", zap.Error(err))
 		return nil, ctx.Err()
+	case <-c.closeCh:
+		logger.Debug("Conn closed, cancelling")
+		return nil, ErrClosed
 	case resp = <-ch:
 		logger.Debug("Response received",
 			zap.Reflect("resp", resp),
@@ -292,12 +584,11 @@ func (c *Conn) sendRequest(ctx context.Context, typ api.MessageType, pld interfa
 }
 
 // Close closes the connection.
+// Pending requests fail with ErrClosed, the state, change, command and error subscriptions are closed.
 func (c *Conn) Close() error {
-	select {
-	case <-c.closeCh:
-	default:
+	c.closeOnce.Do(func() {
 		close(c.closeCh)
-	}
+	})
 
 	c.stateSubsMu.Lock()
 	for ch := range c.stateSubs {
@@ -305,9 +596,67 @@ func (c *Conn) Close() error {
 		close(ch)
 	}
 	c.stateSubsMu.Unlock()
+
+	c.changeSubsMu.Lock()
+	for ch := range c.changeSubs {
+		delete(c.changeSubs, ch)
+		close(ch)
+	}
+	c.changeSubsMu.Unlock()
+
+	c.commandSubsMu.Lock()
+	for ch := range c.commandSubs {
+		delete(c.commandSubs, ch)
+		close(ch)
+	}
+	c.commandSubsMu.Unlock()
+
+	c.errSubsMu.Lock()
+	for ch := range c.errSubs {
+		delete(c.errSubs, ch)
+		close(ch)
+	}
+	c.errSubsMu.Unlock()
 	return nil
 }
 
+// Shutdown gracefully closes the connection.
+// Shutdown stops accepting new requests, waits for the pending requests to complete and closes the connection.
+// If ctx is done before the pending requests complete, the connection is closed immediately
+// and the error of ctx is returned.
+// Requests and subscriptions attempted after Shutdown is called fail with ErrClosed immediately.
+func (c *Conn) Shutdown(ctx context.Context) error {
+	c.shutdownOnce.Do(func() {
+		close(c.shutdownCh)
+	})
+
+	drainedCh := make(chan struct{})
+	go func() {
+		c.closeChMu.Lock()
+		c.Close()
+		c.closeChMu.Unlock()
+		close(drainedCh)
+	}()
+
+	select {
+	case <-drainedCh:
+		return nil
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	}
+}
+
+// isShutdown reports whether Shutdown was called.
+func (c *Conn) isShutdown() bool {
+	select {
+	case <-c.shutdownCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // State returns the current state of TRC and turtles.
 func (c *Conn) State(_ context.Context) *api.State {
 	c.stateMu.RLock()
@@ -316,10 +665,25 @@ func (c *Conn) State(_ context.Context) *api.State {
 	return st
 }
 
+// SequencedState returns the current state of TRC and turtles and the sequence number
+// of the last state message sent by TRC applied to it. The sequence number is 0 if TRC does not number
+// state messages. States with greater sequence numbers are more recent.
+func (c *Conn) SequencedState(_ context.Context) (*api.State, uint64) {
+	c.stateMu.RLock()
+	st := deepcopy.Copy(c.state).(*api.State)
+	seq := c.seq
+	c.stateMu.RUnlock()
+	return st, seq
+}
+
 // SubscribeStateChanges opens a subscription to state changes.
 // SubscribeStateChanges returns read-only channel, on which a value is sent
 // every time there is a state change and a function, which must be used to close the subscription.
 func (c *Conn) SubscribeStateChanges(ctx context.Context) (<-chan struct{}, func(), error) {
+	if c.isShutdown() {
+		return nil, nil, ErrClosed
+	}
+
 	c.closeChMu.RLock()
 	defer c.closeChMu.RUnlock()
 
@@ -338,9 +702,15 @@ func (c *Conn) SubscribeStateChanges(ctx context.Context) (<-chan struct{}, func
 
 	return ch, func() {
 		c.stateSubsMu.Lock()
+		_, ok := c.stateSubs[ch]
 		delete(c.stateSubs, ch)
 		c.stateSubsMu.Unlock()
 
+		if !ok {
+			// Channel was already closed by Close
+			return
+		}
+
 		for {
 			// Drain channel
 			select {
@@ -359,13 +729,42 @@ func (c *Conn) Ping(ctx context.Context) error {
 	return err
 }
 
+// RequestState requests the complete state from TRC and waits for response.
+// The current state is replaced by the complete state sent by TRC. Turtles not included
+// in the response are reset to the unknown state.
+// RequestState is called in the background by Connect after the handshake and after a message is skipped due to a *DecodeError.
+func (c *Conn) RequestState(ctx context.Context) error {
+	pld, err := c.sendRequest(ctx, api.MessageTypeGetState, nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(pld, &api.State{}); err != nil {
+		return errors.Wrap(err, "failed to decode state response payload")
+	}
+	c.synchronizedOnce.Do(func() {
+		close(c.synchronizedCh)
+	})
+	return nil
+}
+
+// Synchronized returns a channel, which is closed once the complete state of TRC
+// is received for the first time, i.e. once State reflects the state of TRC.
+func (c *Conn) Synchronized() <-chan struct{} {
+	return c.synchronizedCh
+}
+
 // SetState sends the state to TRC and waits for response.
 func (c *Conn) SetState(ctx context.Context, st *api.State) error {
 	logcontext.Logger(ctx).Debug("Sending state...",
 		zap.Reflect("state", st),
 	)
-	_, err := c.sendRequest(ctx, api.MessageTypeState, st)
-	return err
+	if _, err := c.sendRequest(ctx, api.MessageTypeState, st); err != nil {
+		return err
+	}
+	if st.Command != "" {
+		c.publishCommand(st.Command)
+	}
+	return nil
 }
 
 // SetCommand sends a command to TRC and waits for response.
@@ -385,8 +784,55 @@ func (c *Conn) SetTurtleState(ctx context.Context, st map[string]*api.TurtleStat
 	})
 }
 
-// Errors returns a channel, on which errors are sent.
-// There should be exactly one goroutine reading on the returned channel at all times.
+// FleetID returns the ID of the fleet sent by TRC in the handshake, if any.
+func (c *Conn) FleetID() string {
+	return c.fleetID
+}
+
+// SubscribeErrors opens a subscription to errors.
+// SubscribeErrors returns read-only channel, on which every error occurring on the connection is sent,
+// and a function, which must be used to close the subscription. The channel is closed once the connection is closed.
+// Errors are of types *TransportError, *DecodeError, *ProtocolError and *UnexpectedTypeError.
+// The connection is closed after fatal errors, the others only affect single messages.
+// The errors are buffered, if the buffer is full, errors are missed.
+func (c *Conn) SubscribeErrors(ctx context.Context) (<-chan error, func(), error) {
+	if c.isShutdown() {
+		return nil, nil, ErrClosed
+	}
+
+	c.closeChMu.RLock()
+	defer c.closeChMu.RUnlock()
+
+	select {
+	case <-c.closeCh:
+		return nil, nil, ErrClosed
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	default:
+	}
+
+	c.errSubsMu.Lock()
+	ch := make(chan error, errorBufferSize)
+	c.errSubs[ch] = struct{}{}
+	c.errSubsMu.Unlock()
+
+	return ch, func() {
+		c.errSubsMu.Lock()
+		_, ok := c.errSubs[ch]
+		delete(c.errSubs, ch)
+		c.errSubsMu.Unlock()
+
+		if !ok {
+			// Channel was already closed by Close
+			return
+		}
+		close(ch)
+	}, nil
+}
+
+// Errors returns a channel, on which errors are sent as on the channels returned by SubscribeErrors.
+// The channel is a subscription opened by Connect, hence each error is received by exactly one of
+// the goroutines reading on it. Use SubscribeErrors to receive all errors in several goroutines.
 func (c *Conn) Errors() <-chan error {
 	return c.errCh
 }
@@ -2,9 +2,13 @@ package trcapi_test
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -18,6 +22,15 @@ import (
 	"go.uber.org/zap"
 )
 
+// waitSynchronized waits until the complete state of TRC is received by conn.
+func waitSynchronized(t *testing.T, conn *Conn) {
+	select {
+	case <-conn.Synchronized():
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the state of TRC")
+	}
+}
+
 //Test_items: Connect(), SendHandshake(), SendState(), State(), SubscribeStateChanges() in conn.go
 //Input_spec: -
 //Output_spec: Pass or fail
@@ -101,6 +114,7 @@ func TestState(t *testing.T) {
 
 			conn, err := Connect(DefaultVersion, srrsOut, srrsIn)
 			a.Nil(err)
+			waitSynchronized(t, conn)
 
 			go func() {
 				defer wg.Done()
@@ -254,6 +268,7 @@ func TestSetState(t *testing.T) {
 
 			conn, err := Connect(DefaultVersion, srrsOut, srrsIn)
 			a.Nil(err)
+			waitSynchronized(t, conn)
 
 			go func() {
 				defer wg.Done()
@@ -322,3 +337,944 @@ func TestSetState(t *testing.T) {
 		})
 	}
 }
+
+//Test_items: Connect(), WithSecret(), Token() in conn.go, ChallengeHandshake in trctest
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestChallengeHandshake(t *testing.T) {
+	secret := []byte("secret")
+
+	for _, tc := range []struct {
+		Name        string
+		TRCSecret   []byte
+		Plaintext   bool
+		ShouldError bool
+	}{
+		{
+			Name:      "valid secret",
+			TRCSecret: secret,
+		},
+		{
+			Name:        "invalid secret",
+			TRCSecret:   []byte("invalid"),
+			ShouldError: true,
+		},
+		{
+			Name:        "plaintext token",
+			Plaintext:   true,
+			ShouldError: true,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			a := assert.New(t)
+
+			srrsIn, trcOut := io.Pipe()
+			trcIn, srrsOut := io.Pipe()
+			defer srrsIn.Close()
+			defer trcIn.Close()
+
+			hs, err := trctest.NewChallengeHandshake(tc.TRCSecret)
+			if !a.NoError(err) {
+				t.FailNow()
+			}
+
+			trc := trctest.Connect(trcOut, trcIn,
+				trctest.WithHandler(api.MessageTypeHandshake, hs.Handle),
+			)
+			defer trc.Close()
+
+			go func() {
+				for range trc.Errors() {
+					// TRC rejected the handshake, close the connection.
+					trcOut.Close()
+				}
+			}()
+
+			req := hs.Request()
+			if tc.Plaintext {
+				req = &api.Handshake{
+					Version: DefaultVersion,
+					Token:   "test",
+				}
+			}
+			go trc.SendHandshake(req)
+
+			type result struct {
+				conn *Conn
+				err  error
+			}
+			resCh := make(chan result, 1)
+			go func() {
+				conn, err := Connect(DefaultVersion, srrsOut, srrsIn, WithSecret(secret))
+				resCh <- result{conn, err}
+			}()
+
+			var res result
+			select {
+			case res = <-resCh:
+			case <-time.After(time.Second):
+				t.Fatal("Timed out waiting for handshake to complete")
+			}
+
+			if tc.ShouldError {
+				a.Error(res.err)
+				return
+			}
+			if !a.NoError(res.err) {
+				t.FailNow()
+			}
+			defer res.conn.Close()
+
+			tok, err := res.conn.Token()
+			a.NoError(err)
+			a.Len(tok, 64, "token must be the complete hex-encoded HMAC-SHA256")
+			a.Equal(hs.Token(), tok)
+		})
+	}
+}
+
+//Test_items: PinnedTLSConfig(), CertificateFingerprint() in tls.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestPinnedTLSConfig(t *testing.T) {
+	a := assert.New(t)
+
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := PinnedTLSConfig()
+	a.Error(err)
+
+	_, err = PinnedTLSConfig("invalid")
+	a.Error(err)
+
+	conf, err := PinnedTLSConfig(CertificateFingerprint(srv.Certificate().Raw))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), conf)
+	if a.NoError(err) {
+		conn.Close()
+	}
+
+	conf, err = PinnedTLSConfig(strings.Repeat("00", 32))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	_, err = tls.Dial("tcp", srv.Listener.Addr().String(), conf)
+	a.Error(err)
+}
+
+//Test_items: Conn.Shutdown() in conn.go, Pool.Shutdown() in pool.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestShutdown(t *testing.T) {
+	a := assert.New(t)
+
+	receivedCh := make(chan struct{}, 1)
+	replyCh := make(chan struct{})
+
+	pool := NewPool(func() (*Conn, func(), error) {
+		srrsIn, trcOut := io.Pipe()
+		trcIn, srrsOut := io.Pipe()
+
+		trc := trctest.Connect(trcOut, trcIn,
+			trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+			trctest.WithHandler(api.MessageTypeState, func(msg *api.Message) (*api.Message, error) {
+				receivedCh <- struct{}{}
+				<-replyCh
+				return trctest.DefaultStateHandler(msg)
+			}),
+		)
+		go trc.SendHandshake(&api.Handshake{
+			Version: DefaultVersion,
+			Token:   "test",
+		})
+
+		conn, err := Connect(DefaultVersion, srrsOut, srrsIn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() {
+			conn.Close()
+			trc.Close()
+			srrsIn.Close()
+			trcIn.Close()
+		}, nil
+	})
+
+	conn, err := pool.Conn()
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	// Pending request is waited for.
+	reqErrCh := make(chan error, 1)
+	go func() {
+		reqErrCh <- conn.SetCommand(context.Background(), api.CommandStop)
+	}()
+	select {
+	case <-receivedCh:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for TRC to receive the request")
+	}
+
+	shutdownErrCh := make(chan error, 1)
+	go func() {
+		shutdownErrCh <- conn.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-shutdownErrCh:
+		t.Fatalf("Shutdown returned before the pending request completed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// New requests fail without waiting for the pending request.
+	go func() {
+		reqErrCh <- conn.SetCommand(context.Background(), api.CommandStart)
+	}()
+	select {
+	case err := <-reqErrCh:
+		a.Equal(ErrClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the request attempted during Shutdown to fail")
+	}
+	_, _, err = conn.SubscribeStateChanges(context.Background())
+	a.Equal(ErrClosed, err)
+
+	close(replyCh)
+	select {
+	case err := <-reqErrCh:
+		a.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the pending request to complete")
+	}
+	select {
+	case err := <-shutdownErrCh:
+		a.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for Shutdown to return")
+	}
+	a.Equal(ErrClosed, conn.SetCommand(context.Background(), api.CommandStart))
+
+	// Pending request is aborted when the context is done.
+	replyCh = make(chan struct{})
+	defer close(replyCh)
+
+	conn, err = pool.Conn()
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	go func() {
+		reqErrCh <- conn.SetCommand(context.Background(), api.CommandStop)
+	}()
+	select {
+	case <-receivedCh:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for TRC to receive the request")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	a.Equal(context.DeadlineExceeded, pool.Shutdown(ctx))
+	select {
+	case err := <-reqErrCh:
+		a.Equal(ErrClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the pending request to be aborted")
+	}
+
+	_, err = pool.Conn()
+	a.Equal(ErrClosed, err)
+}
+
+//Test_items: WithFleetOptions(), Conn.FleetID() in conn.go, Pool.Set() in pool.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestPoolSet(t *testing.T) {
+	a := assert.New(t)
+
+	pool := NewPool(nil)
+
+	_, err := pool.Conn()
+	a.Equal(ErrNotConnected, err)
+
+	connect := func(fleetID string, fleetSize int) (*Conn, func(), error) {
+		srrsIn, trcOut := io.Pipe()
+		trcIn, srrsOut := io.Pipe()
+
+		trc := trctest.Connect(trcOut, trcIn,
+			trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		)
+		go trc.SendHandshake(&api.Handshake{
+			Version: DefaultVersion,
+			Token:   "test",
+			FleetID: fleetID,
+		})
+
+		closeFunc := func() {
+			trc.Close()
+			srrsIn.Close()
+			trcIn.Close()
+		}
+
+		conn, err := Connect(DefaultVersion, srrsOut, srrsIn, WithFleetOptions(func(id string) ([]Option, error) {
+			if id != fleetID {
+				return nil, errors.Errorf("unexpected fleet ID: %s", id)
+			}
+			if id == "unknown" {
+				return nil, errors.New("unknown fleet")
+			}
+			return []Option{WithFleetSize(fleetSize)}, nil
+		}))
+		if err != nil {
+			closeFunc()
+			return nil, nil, err
+		}
+		return conn, func() {
+			conn.Close()
+			closeFunc()
+		}, nil
+	}
+
+	_, _, err = connect("unknown", 1)
+	a.Error(err)
+
+	first, closeFirst, err := connect("first", 2)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Equal("first", first.FleetID())
+	a.Len(first.State(context.Background()).Turtles, 2)
+
+	a.NoError(pool.Set(first, closeFirst))
+
+	conn, err := pool.Conn()
+	a.NoError(err)
+	a.True(conn == first)
+
+	second, closeSecond, err := connect("second", 3)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Equal("second", second.FleetID())
+	a.Len(second.State(context.Background()).Turtles, 3)
+
+	// Stale connection is closed.
+	a.NoError(pool.Set(second, closeSecond))
+	select {
+	case <-first.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the stale connection to close")
+	}
+
+	conn, err = pool.Conn()
+	a.NoError(err)
+	a.True(conn == second)
+
+	a.NoError(second.Close())
+	_, err = pool.Conn()
+	a.Equal(ErrNotConnected, err)
+
+	third, closeThird, err := connect("third", 1)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(pool.Shutdown(context.Background()))
+	a.Equal(ErrClosed, pool.Set(third, closeThird))
+	select {
+	case <-third.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the connection to close after shutdown")
+	}
+}
+
+//Test_items: Connect(), DecodeError in decode.go, Errors() in conn.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestDecodeError(t *testing.T) {
+	a := assert.New(t)
+
+	srrsIn, trcOut := io.Pipe()
+	trcIn, srrsOut := io.Pipe()
+	defer srrsIn.Close()
+	defer trcIn.Close()
+
+	full := &api.State{
+		Command: api.CommandGoIn,
+		Turtles: map[string]*api.TurtleState{
+			"1": {
+				HomeGoal: api.HomeGoalBlue,
+			},
+		},
+	}
+	getStateCh := make(chan struct{}, 1)
+
+	trc := trctest.Connect(trcOut, trcIn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		trctest.WithHandler(api.MessageTypePing, trctest.DefaultPingHandler),
+		trctest.WithHandler(api.MessageTypeGetState, func(msg *api.Message) (*api.Message, error) {
+			getStateCh <- struct{}{}
+			b, err := json.Marshal(full)
+			if err != nil {
+				return nil, err
+			}
+			return api.NewMessage(api.MessageTypeGetState, b, &msg.MessageID), nil
+		}),
+	)
+	defer trc.Close()
+
+	go trc.SendHandshake(&api.Handshake{
+		Version: DefaultVersion,
+		Token:   "test",
+	})
+
+	conn, err := Connect(DefaultVersion, srrsOut, srrsIn)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The initial state request.
+	<-getStateCh
+	waitSynchronized(t, conn)
+
+	subCh, unsubscribe, err := conn.SubscribeStateChanges(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer unsubscribe()
+
+	full.Command = api.CommandStart
+
+	errCh, closeErrFn, err := conn.SubscribeErrors(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer closeErrFn()
+
+	_, err = trcOut.Write([]byte("{\"type\":\"state\",\"payload\":{garbage\n"))
+	a.NoError(err)
+
+	select {
+	case err := <-errCh:
+		if a.IsType(&DecodeError{}, err) {
+			a.Equal([]byte("{\"type\":\"state\",\"payload\":{garbage"), err.(*DecodeError).Data)
+			a.True(err.(*DecodeError).Is(ErrDecode))
+			a.False(err.(*DecodeError).Is(ErrTransport))
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for decode error")
+	}
+
+	select {
+	case <-getStateCh:
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for state request")
+	}
+
+	select {
+	case <-subCh:
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for state update")
+	}
+	a.Equal(api.CommandStart, conn.State(ctx).Command)
+	a.Equal(full.Turtles["1"], conn.State(ctx).Turtles["1"])
+
+	// The connection stays usable.
+	a.NoError(conn.Ping(ctx))
+
+	a.NoError(trc.SendState(&api.State{Command: api.CommandStop}))
+	select {
+	case <-subCh:
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for state update")
+	}
+	a.Equal(api.CommandStop, conn.State(ctx).Command)
+
+	// A message exceeding MaxMessageSize is skipped without being buffered completely.
+	oversized := append([]byte(`{"type":"state","payload":"`), make([]byte, MaxMessageSize)...)
+	go trcOut.Write(append(oversized, []byte("\"}\n")...))
+
+	select {
+	case err := <-errCh:
+		if a.IsType(&DecodeError{}, err) {
+			a.True(len(err.(*DecodeError).Data) < len(oversized))
+			a.Equal(oversized[:len(err.(*DecodeError).Data)], err.(*DecodeError).Data)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for decode error")
+	}
+
+	select {
+	case <-getStateCh:
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for state request")
+	}
+	a.NoError(conn.Ping(ctx))
+}
+
+//Test_items: Connect(), RequestState() in conn.go, WithState(), State() in trctest
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRequestState(t *testing.T) {
+	a := assert.New(t)
+
+	srrsIn, trcOut := io.Pipe()
+	trcIn, srrsOut := io.Pipe()
+	defer srrsIn.Close()
+	defer trcIn.Close()
+
+	trc := trctest.Connect(trcOut, trcIn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		trctest.WithHandler(api.MessageTypeState, trctest.DefaultStateHandler),
+		trctest.WithState(&api.State{
+			Command: api.CommandGoIn,
+			Turtles: map[string]*api.TurtleState{
+				"2": {
+					HomeGoal: api.HomeGoalBlue,
+				},
+			},
+		}),
+	)
+	defer trc.Close()
+
+	go trc.SendHandshake(&api.Handshake{
+		Version: DefaultVersion,
+		Token:   "test",
+	})
+
+	conn, err := Connect(DefaultVersion, srrsOut, srrsIn, WithFleetSize(3))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	waitSynchronized(t, conn)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The state of TRC is requested right after connecting.
+	a.Equal(&api.State{
+		Command: api.CommandGoIn,
+		Turtles: map[string]*api.TurtleState{
+			"1": {},
+			"2": {
+				HomeGoal: api.HomeGoalBlue,
+			},
+			"3": {},
+		},
+	}, conn.State(ctx))
+
+	a.NoError(conn.SetTurtleState(ctx, map[string]*api.TurtleState{
+		"3": {
+			TeamColor: api.TeamColorMagenta,
+		},
+	}))
+	a.NoError(trc.SendState(&api.State{Command: api.CommandStop}))
+
+	expected := trc.State()
+	a.Equal(api.CommandStop, expected.Command)
+	a.Equal(api.TeamColorMagenta, expected.Turtles["3"].TeamColor)
+
+	a.NoError(conn.RequestState(ctx))
+	st := conn.State(ctx)
+	a.Equal(expected.Command, st.Command)
+	for id, ts := range expected.Turtles {
+		a.Equal(ts, st.Turtles[id])
+	}
+}
+
+//Test_items: SequencedState() in conn.go, Seq() in trctest
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestSequence(t *testing.T) {
+	a := assert.New(t)
+
+	srrsIn, trcOut := io.Pipe()
+	trcIn, srrsOut := io.Pipe()
+	defer srrsIn.Close()
+	defer trcIn.Close()
+
+	trc := trctest.Connect(trcOut, trcIn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+	)
+	defer trc.Close()
+
+	go trc.SendHandshake(&api.Handshake{
+		Version: DefaultVersion,
+		Token:   "test",
+	})
+
+	conn, err := Connect(DefaultVersion, srrsOut, srrsIn)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	waitSynchronized(t, conn)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	subCh, unsubscribe, err := conn.SubscribeStateChanges(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer unsubscribe()
+
+	// waitFor waits until the sequence number of the state of conn is seq.
+	waitFor := func(seq uint64) *api.State {
+		for {
+			st, got := conn.SequencedState(ctx)
+			if got == seq {
+				return st
+			}
+			select {
+			case <-subCh:
+			case <-ctx.Done():
+				t.Fatalf("Timed out waiting for state with sequence number %d, got %d", seq, got)
+			}
+		}
+	}
+
+	// sendRaw sends a state message with sequence number seq bypassing trc.
+	sendRaw := func(st *api.State, seq uint64) {
+		b, err := json.Marshal(st)
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+		msg := api.NewMessage(api.MessageTypeState, b, nil)
+		msg.Seq = seq
+		if !a.NoError(json.NewEncoder(trcOut).Encode(msg)) {
+			t.FailNow()
+		}
+	}
+
+	_, seq := conn.SequencedState(ctx)
+	a.Equal(uint64(0), seq)
+
+	a.NoError(trc.SendState(&api.State{Command: api.CommandStart}))
+	a.NoError(trc.SendState(&api.State{Command: api.CommandStop}))
+	a.Equal(uint64(2), trc.Seq())
+	a.Equal(api.CommandStop, waitFor(2).Command)
+
+	// Stale state messages are skipped.
+	sendRaw(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"1": {HomeGoal: api.HomeGoalBlue},
+		},
+	}, 1)
+	a.NoError(trc.SendState(&api.State{Command: api.CommandGoIn}))
+	st := waitFor(3)
+	a.Equal(api.CommandGoIn, st.Command)
+	a.Equal(&api.TurtleState{}, st.Turtles["1"])
+
+	// A gap causes resynchronization with the state of TRC, the state message
+	// received out of order is either skipped or applied on top of the resynchronized state.
+	sendRaw(&api.State{Command: api.CommandCornerMagenta}, 5)
+	a.NoError(trc.SendState(&api.State{Command: api.CommandStop}))
+	st = waitFor(4)
+	a.Equal(api.CommandStop, st.Command)
+
+	a.NoError(trc.SendState(&api.State{Command: api.CommandStart}))
+	a.Equal(api.CommandStart, waitFor(5).Command)
+}
+
+//Test_items: SubscribeErrors(), Errors() in conn.go, ProtocolError, UnexpectedTypeError in errors.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestSubscribeErrors(t *testing.T) {
+	a := assert.New(t)
+
+	srrsIn, trcOut := io.Pipe()
+	trcIn, srrsOut := io.Pipe()
+	defer srrsIn.Close()
+	defer trcIn.Close()
+
+	trc := trctest.Connect(trcOut, trcIn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		trctest.WithHandler(api.MessageTypePing, trctest.DefaultPingHandler),
+	)
+	defer trc.Close()
+
+	go trc.SendHandshake(&api.Handshake{
+		Version: DefaultVersion,
+		Token:   "test",
+	})
+
+	conn, err := Connect(DefaultVersion, srrsOut, srrsIn)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	waitSynchronized(t, conn)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var subs []<-chan error
+	for i := 0; i < 2; i++ {
+		ch, closeFn, err := conn.SubscribeErrors(ctx)
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+		defer closeFn()
+		subs = append(subs, ch)
+	}
+	subs = append(subs, conn.Errors())
+
+	// expect asserts that every subscriber receives an error, which is of class class.
+	expect := func(class error) {
+		for _, ch := range subs {
+			select {
+			case err, ok := <-ch:
+				if !a.True(ok) {
+					return
+				}
+				a.True(err.(interface{ Is(error) bool }).Is(class), "%s is not of class %s", err, class)
+			case <-ctx.Done():
+				t.Fatalf("Timed out waiting for error of class %s", class)
+			}
+		}
+	}
+
+	enc := json.NewEncoder(trcOut)
+
+	// TRC must not request the state.
+	a.NoError(enc.Encode(api.NewMessage(api.MessageTypeGetState, nil, nil)))
+	expect(ErrProtocol)
+	a.NoError(conn.Ping(ctx))
+
+	// Messages of unexpected type close the connection.
+	a.NoError(enc.Encode(api.NewMessage(api.MessageType("unexpected"), nil, nil)))
+	expect(ErrUnexpectedType)
+	for _, ch := range subs {
+		select {
+		case _, ok := <-ch:
+			a.False(ok)
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for error subscription to be closed")
+		}
+	}
+	select {
+	case <-conn.Closed():
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for the connection to be closed")
+	}
+
+	_, _, err = conn.SubscribeErrors(ctx)
+	a.Equal(ErrClosed, err)
+}
+
+//Test_items: SubscribeChanges(), TurtleFilter(), FieldFilter() in changes.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestSubscribeChanges(t *testing.T) {
+	a := assert.New(t)
+
+	srrsIn, trcOut := io.Pipe()
+	trcIn, srrsOut := io.Pipe()
+	defer srrsIn.Close()
+	defer trcIn.Close()
+
+	trc := trctest.Connect(trcOut, trcIn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+	)
+	defer trc.Close()
+
+	go trc.SendHandshake(&api.Handshake{
+		Version: DefaultVersion,
+		Token:   "test",
+	})
+
+	conn, err := Connect(DefaultVersion, srrsOut, srrsIn)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	waitSynchronized(t, conn)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	allCh, closeAllFn, err := conn.SubscribeChanges(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer closeAllFn()
+
+	batteryCh, closeBatteryFn, err := conn.SubscribeChanges(ctx, TurtleFilter("3"), FieldFilter(api.FieldBatteryVoltage))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer closeBatteryFn()
+
+	commandCh, closeCommandFn, err := conn.SubscribeChanges(ctx, FieldFilter(api.FieldCommand))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer closeCommandFn()
+
+	// expect asserts that the changes received on ch are expected.
+	expect := func(ch <-chan api.Change, expected ...api.Change) {
+		for _, e := range expected {
+			select {
+			case change := <-ch:
+				a.Equal(e, change)
+			case <-ctx.Done():
+				t.Fatalf("Timed out waiting for change %v", e)
+			}
+		}
+	}
+
+	a.NoError(trc.SendState(&api.State{
+		Command: api.CommandStop,
+		Turtles: map[string]*api.TurtleState{
+			"1": {BatteryVoltage: apitest.Uint8Ptr(42)},
+			"3": {BatteryVoltage: apitest.Uint8Ptr(42)},
+		},
+	}))
+	a.NoError(trc.SendState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"3": {BatteryVoltage: apitest.Uint8Ptr(41), HomeGoal: api.HomeGoalBlue},
+		},
+	}))
+	a.NoError(trc.SendState(&api.State{
+		Command: api.CommandStart,
+	}))
+
+	expect(allCh,
+		api.Change{Field: api.FieldCommand, New: api.CommandStop},
+		api.Change{TurtleID: "1", Field: api.FieldBatteryVoltage, New: uint8(42)},
+		api.Change{TurtleID: "3", Field: api.FieldBatteryVoltage, New: uint8(42)},
+		api.Change{TurtleID: "3", Field: api.FieldBatteryVoltage, Old: uint8(42), New: uint8(41)},
+		api.Change{TurtleID: "3", Field: api.FieldHomeGoal, New: api.HomeGoalBlue},
+		api.Change{Field: api.FieldCommand, Old: api.CommandStop, New: api.CommandStart},
+	)
+	expect(batteryCh,
+		api.Change{TurtleID: "3", Field: api.FieldBatteryVoltage, New: uint8(42)},
+		api.Change{TurtleID: "3", Field: api.FieldBatteryVoltage, Old: uint8(42), New: uint8(41)},
+	)
+	expect(commandCh,
+		api.Change{Field: api.FieldCommand, New: api.CommandStop},
+		api.Change{Field: api.FieldCommand, Old: api.CommandStop, New: api.CommandStart},
+	)
+
+	a.NoError(conn.Close())
+	for _, ch := range []<-chan api.Change{allCh, batteryCh, commandCh} {
+		select {
+		case change, ok := <-ch:
+			a.False(ok, "unexpected change %v", change)
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for change subscription to be closed")
+		}
+	}
+}
+
+//Test_items: Conn.SubscribeCommands() in commands.go, Pool.OnConnect() in pool.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestSubscribeCommands(t *testing.T) {
+	a := assert.New(t)
+
+	srrsIn, trcOut := io.Pipe()
+	trcIn, srrsOut := io.Pipe()
+	defer srrsIn.Close()
+	defer trcIn.Close()
+
+	trc := trctest.Connect(trcOut, trcIn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		trctest.WithHandler(api.MessageTypeState, trctest.DefaultStateHandler),
+	)
+	defer trc.Close()
+
+	go trc.SendHandshake(&api.Handshake{
+		Version: DefaultVersion,
+		Token:   "test",
+	})
+
+	conn, err := Connect(DefaultVersion, srrsOut, srrsIn)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	waitSynchronized(t, conn)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	connCh := make(chan *Conn, 1)
+	pool := NewPool(nil)
+	pool.OnConnect(func(conn *Conn) {
+		connCh <- conn
+	})
+	a.NoError(pool.Set(conn, nil))
+	select {
+	case c := <-connCh:
+		a.Equal(conn, c)
+	default:
+		t.Error("OnConnect function not called on Set")
+	}
+
+	pool.OnConnect(func(conn *Conn) {
+		connCh <- conn
+	})
+	select {
+	case c := <-connCh:
+		a.Equal(conn, c)
+	default:
+		t.Error("OnConnect function not called with the existing connection")
+	}
+
+	ch, closeFn, err := conn.SubscribeCommands(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer closeFn()
+
+	a.NoError(conn.SetCommand(ctx, api.CommandStop))
+	a.NoError(trc.SendState(&api.State{
+		Command: api.CommandKickOffCyan,
+	}))
+	a.NoError(trc.SendState(&api.State{
+		Turtles: map[string]*api.TurtleState{
+			"1": {BatteryVoltage: apitest.Uint8Ptr(42)},
+		},
+	}))
+	a.NoError(trc.SendState(&api.State{
+		Command: api.CommandKickOffCyan,
+	}))
+
+	for _, expected := range []api.Command{api.CommandStop, api.CommandKickOffCyan, api.CommandKickOffCyan} {
+		select {
+		case cmd := <-ch:
+			a.Equal(expected, cmd)
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for command %s", expected)
+		}
+	}
+
+	a.NoError(conn.Close())
+	select {
+	case cmd, ok := <-ch:
+		a.False(ok, "unexpected command %s", cmd)
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for command subscription to be closed")
+	}
+}
@@ -0,0 +1,91 @@
+package trcapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// MaxMessageSize is the maximum size of a message received from TRC in bytes, including the delimiter.
+const MaxMessageSize = 1 << 20
+
+// oversizedPrefixLength is the amount of bytes of a message exceeding MaxMessageSize kept in the *DecodeError.
+const oversizedPrefixLength = 256
+
+// errMessageTooLarge is the error, with which decoding of a message exceeding MaxMessageSize fails.
+var errMessageTooLarge = errors.New("message exceeds maximum size")
+
+// lineDecoder decodes newline-delimited JSON values.
+// Since every value is delimited, a malformed value does not affect decoding of the following ones.
+type lineDecoder struct {
+	reader *bufio.Reader
+}
+
+// newLineDecoder returns a new *lineDecoder reading from r.
+func newLineDecoder(r io.Reader) *lineDecoder {
+	return &lineDecoder{
+		reader: bufio.NewReader(r),
+	}
+}
+
+// readLine reads the next line including the delimiter.
+// If the line exceeds MaxMessageSize, it is discarded, only its beginning is returned
+// and the error is errMessageTooLarge.
+func (d *lineDecoder) readLine() ([]byte, error) {
+	var line []byte
+	var n int
+	for {
+		frag, err := d.reader.ReadSlice('\n')
+		n += len(frag)
+		switch {
+		case n <= MaxMessageSize:
+			line = append(line, frag...)
+		case len(line) < oversizedPrefixLength:
+			if rem := oversizedPrefixLength - len(line); len(frag) > rem {
+				frag = frag[:rem]
+			}
+			line = append(line, frag...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if n > MaxMessageSize && (err == nil || err == io.EOF) {
+			if len(line) > oversizedPrefixLength {
+				line = line[:oversizedPrefixLength]
+			}
+			return line, errMessageTooLarge
+		}
+		return line, err
+	}
+}
+
+// Decode decodes the next line into v. Empty lines are skipped.
+// If the line cannot be decoded or exceeds MaxMessageSize, a *DecodeError is returned and the line is skipped.
+func (d *lineDecoder) Decode(v interface{}) error {
+	for {
+		line, err := d.readLine()
+		if err == errMessageTooLarge {
+			return &DecodeError{Data: line, Err: err}
+		}
+		line = bytes.TrimSpace(line)
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return err
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return &DecodeError{Data: line, Err: err}
+		}
+		if dec.More() {
+			return &DecodeError{Data: line, Err: errors.New("trailing data after message")}
+		}
+		return nil
+	}
+}
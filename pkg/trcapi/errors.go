@@ -0,0 +1,118 @@
+package trcapi
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+)
+
+// Classes of errors, which occur on a Conn.
+// Errors sent by a Conn are of one of the types below, each of which matches exactly one
+// of the classes by its Is method, hence e.g. errors.Is(err, ErrDecode) can be used on Go 1.13 and newer.
+var (
+	// ErrTransport is the class of errors, which occur when the stream to TRC fails.
+	ErrTransport = errors.New("transport error")
+
+	// ErrDecode is the class of errors, which occur when a message received from TRC cannot be decoded.
+	ErrDecode = errors.New("decode error")
+
+	// ErrProtocol is the class of errors, which occur when TRC violates the protocol,
+	// e.g. by sending state messages out of order.
+	ErrProtocol = errors.New("protocol error")
+
+	// ErrUnexpectedType is the class of errors, which occur when a message of unexpected type is received from TRC.
+	ErrUnexpectedType = errors.New("unexpected message type")
+)
+
+// TransportError represents an error, which occurs when reading from or writing to the stream to TRC fails.
+// TransportError is fatal if it occurs when reading: the connection is closed.
+type TransportError struct {
+	// Err is the error, with which the stream failed.
+	Err error
+}
+
+// Error implements error.
+func (e *TransportError) Error() string {
+	return "communication with TRC failed: " + e.Err.Error()
+}
+
+// Is reports whether target is ErrTransport.
+func (e *TransportError) Is(target error) bool {
+	return target == ErrTransport
+}
+
+// Unwrap returns the error, with which the stream failed.
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the error, with which the stream failed.
+func (e *TransportError) Cause() error {
+	return e.Err
+}
+
+// DecodeError represents an error, which occurs when a message received from TRC cannot be decoded.
+// DecodeError is not fatal: the message is skipped, the state is resynchronized with TRC
+// and the connection stays usable.
+type DecodeError struct {
+	// Data is the skipped message.
+	// If the message exceeds MaxMessageSize, Data only holds its beginning.
+	Data []byte
+	// Err is the error, with which decoding failed.
+	Err error
+}
+
+// Error implements error.
+func (e *DecodeError) Error() string {
+	return "failed to decode incoming message: " + e.Err.Error()
+}
+
+// Is reports whether target is ErrDecode.
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrDecode
+}
+
+// Unwrap returns the error, with which decoding failed.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the error, with which decoding failed.
+func (e *DecodeError) Cause() error {
+	return e.Err
+}
+
+// ProtocolError represents an error, which occurs when TRC violates the protocol.
+// ProtocolError is not fatal: the offending message is skipped or the state is resynchronized with TRC.
+type ProtocolError struct {
+	// Reason describes the violation.
+	Reason string
+}
+
+// Error implements error.
+func (e *ProtocolError) Error() string {
+	return "TRC violated the protocol: " + e.Reason
+}
+
+// Is reports whether target is ErrProtocol.
+func (e *ProtocolError) Is(target error) bool {
+	return target == ErrProtocol
+}
+
+// UnexpectedTypeError represents an error, which occurs when a message of unexpected type is received from TRC.
+// UnexpectedTypeError is fatal: the connection is closed.
+type UnexpectedTypeError struct {
+	// Type is the type of the message received.
+	Type api.MessageType
+}
+
+// Error implements error.
+func (e *UnexpectedTypeError) Error() string {
+	return fmt.Sprintf("unmatched message type: %s", e.Type)
+}
+
+// Is reports whether target is ErrUnexpectedType.
+func (e *UnexpectedTypeError) Is(target error) bool {
+	return target == ErrUnexpectedType
+}
@@ -0,0 +1,55 @@
+package trcapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// nonceLength is the length of handshake challenge nonces in bytes.
+const nonceLength = 32
+
+// NewNonce returns a new random hex-encoded nonce to be used as a handshake challenge.
+func NewNonce() (string, error) {
+	b := make([]byte, nonceLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// mac returns the hex-encoded HMAC-SHA256 of label, trcNonce and srrsNonce keyed with secret.
+func mac(secret []byte, label, trcNonce, srrsNonce string) string {
+	h := hmac.New(sha256.New, secret)
+	for _, s := range []string{label, trcNonce, srrsNonce} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SRRSResponse returns the response of SRRS to the challenge trcNonce of TRC,
+// which proves possession of secret.
+func SRRSResponse(secret []byte, trcNonce, srrsNonce string) string {
+	return mac(secret, "srrs", trcNonce, srrsNonce)
+}
+
+// TRCResponse returns the response of TRC to the challenge srrsNonce of SRRS,
+// which proves possession of secret.
+func TRCResponse(secret []byte, trcNonce, srrsNonce string) string {
+	return mac(secret, "trc", trcNonce, srrsNonce)
+}
+
+// HandshakeToken returns the token derived from the handshake nonces,
+// which is used instead of the plaintext token, if the challenge-response handshake is performed.
+// The token is the complete hex-encoded HMAC, since it is accepted for authentication by the web API.
+func HandshakeToken(secret []byte, trcNonce, srrsNonce string) string {
+	return mac(secret, "token", trcNonce, srrsNonce)
+}
+
+// validResponse reports whether the hex-encoded responses got and expected are equal.
+// The comparison is performed in constant time.
+func validResponse(got, expected string) bool {
+	return hmac.Equal([]byte(got), []byte(expected))
+}
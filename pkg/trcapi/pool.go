@@ -1,8 +1,11 @@
 package trcapi
 
 import (
+	"context"
 	"sync"
 
+	"github.com/pkg/errors"
+
 	"go.uber.org/zap"
 )
 
@@ -14,10 +17,19 @@ type Pool struct {
 
 	connMu *sync.Mutex
 	conn   *Conn
+	// isShutdown is true if Shutdown was called.
+	isShutdown bool
+	// onConnect are the functions called with every new connection.
+	onConnect []func(*Conn)
 }
 
+// ErrNotConnected represents an error, which occurs when a connection is requested from a Pool
+// without a connect function, before one is set.
+var ErrNotConnected = errors.New("TRC is not connected")
+
 // NewPool returns a new Pool.
 // connectFunc must return a *Conn, function to close it(possibly nil) and error, if any.
+// If connectFunc is nil, connections are not established by the Pool and must be set using Set.
 func NewPool(connectFunc func() (*Conn, func(), error)) *Pool {
 	return &Pool{
 		connectFunc: connectFunc,
@@ -32,6 +44,10 @@ func (p *Pool) Conn() (*Conn, error) {
 	p.connMu.Lock()
 	defer p.connMu.Unlock()
 
+	if p.isShutdown {
+		return nil, ErrClosed
+	}
+
 	if p.conn != nil {
 		select {
 		case <-p.conn.Closed():
@@ -45,6 +61,12 @@ func (p *Pool) Conn() (*Conn, error) {
 		}
 	}
 
+	if p.connectFunc == nil {
+		p.conn = nil
+		p.closeFunc = nil
+		return nil, ErrNotConnected
+	}
+
 	logger.Debug("Establishing a new open connection...")
 	conn, closeFunc, err := p.connectFunc()
 	if err != nil {
@@ -52,9 +74,62 @@ func (p *Pool) Conn() (*Conn, error) {
 	}
 	p.conn = conn
 	p.closeFunc = closeFunc
+	p.connected(conn)
 	return conn, nil
 }
 
+// OnConnect registers f, which is called with every connection established by or set in the Pool.
+// If the Pool holds an open connection, f is called with it immediately.
+// f is called with the Pool locked, hence f must not block or call methods of the Pool.
+func (p *Pool) OnConnect(f func(*Conn)) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	p.onConnect = append(p.onConnect, f)
+	if p.conn == nil {
+		return
+	}
+	select {
+	case <-p.conn.Closed():
+	default:
+		f(p.conn)
+	}
+}
+
+// connected calls the functions registered by OnConnect with conn.
+// connected must be called with p.connMu held.
+func (p *Pool) connected(conn *Conn) {
+	for _, f := range p.onConnect {
+		f(conn)
+	}
+}
+
+// Set replaces the underlying connection by conn, which is closed by closeFunc(possibly nil).
+// The existing connection, if any, is closed.
+// If the Pool is shut down, conn is closed and ErrClosed is returned.
+func (p *Pool) Set(conn *Conn, closeFunc func()) error {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	if p.isShutdown {
+		if closeFunc != nil {
+			closeFunc()
+		}
+		return ErrClosed
+	}
+
+	if p.conn != nil && p.closeFunc != nil {
+		zap.L().Debug("Closing existing connection...")
+		p.closeFunc()
+	}
+	p.conn = conn
+	p.closeFunc = closeFunc
+	if conn != nil {
+		p.connected(conn)
+	}
+	return nil
+}
+
 // Close closes the underlying connection.
 func (p *Pool) Close() error {
 	p.connMu.Lock()
@@ -65,3 +140,23 @@ func (p *Pool) Close() error {
 	p.connMu.Unlock()
 	return nil
 }
+
+// Shutdown gracefully closes the underlying connection as Conn.Shutdown does.
+// No connections are established after Shutdown is called, Conn returns ErrClosed instead.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	p.isShutdown = true
+	if p.conn == nil {
+		return nil
+	}
+
+	err := p.conn.Shutdown(ctx)
+	if p.closeFunc != nil {
+		p.closeFunc()
+	}
+	p.conn = nil
+	p.closeFunc = nil
+	return err
+}
@@ -0,0 +1,49 @@
+package trcapi
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CertificateFingerprint returns the hex-encoded SHA-256 fingerprint of the DER-encoded certificate der.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// PinnedTLSConfig returns a client *tls.Config, which only accepts peer certificates
+// with one of the hex-encoded SHA-256 fingerprints.
+// The certificate chain is not verified otherwise, hence self-signed certificates can be used.
+func PinnedTLSConfig(fingerprints ...string) (*tls.Config, error) {
+	if len(fingerprints) == 0 {
+		return nil, errors.New("at least one fingerprint must be specified")
+	}
+
+	pins := make(map[string]struct{}, len(fingerprints))
+	for _, fp := range fingerprints {
+		fp = strings.ToLower(strings.Replace(fp, ":", "", -1))
+		if _, err := hex.DecodeString(fp); err != nil || len(fp) != 2*sha256.Size {
+			return nil, errors.Errorf("invalid SHA-256 fingerprint: %s", fp)
+		}
+		pins[fp] = struct{}{}
+	}
+
+	return &tls.Config{
+		// Verification is performed by VerifyPeerCertificate
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no peer certificate received")
+			}
+			if _, ok := pins[CertificateFingerprint(rawCerts[0])]; !ok {
+				return errors.New("peer certificate does not match any pinned fingerprint")
+			}
+			return nil
+		},
+	}, nil
+}
@@ -2,12 +2,15 @@
 package trctest
 
 import (
+	"crypto/hmac"
 	"encoding/json"
 	"io"
 	"sync"
 
+	"github.com/mohae/deepcopy"
 	"github.com/pkg/errors"
 	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/transport"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
 	"go.uber.org/zap"
 )
@@ -46,6 +49,75 @@ func DefaultHandshakeHandler(msg *api.Message) (*api.Message, error) {
 // Handler is a function, which handles a message.
 type Handler func(*api.Message) (*api.Message, error)
 
+// ChallengeHandshake performs the TRC side of the challenge-response handshake.
+// ChallengeHandshake is safe for concurrent use by multiple goroutines.
+type ChallengeHandshake struct {
+	secret []byte
+	nonce  string
+
+	mu    sync.RWMutex
+	token string
+}
+
+// NewChallengeHandshake returns a new *ChallengeHandshake using secret shared with SRRS.
+func NewChallengeHandshake(secret []byte) (*ChallengeHandshake, error) {
+	nonce, err := trcapi.NewNonce()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate challenge")
+	}
+	return &ChallengeHandshake{
+		secret: secret,
+		nonce:  nonce,
+	}, nil
+}
+
+// Request returns the handshake request, which should be sent using SendHandshake.
+func (h *ChallengeHandshake) Request() *api.Handshake {
+	return &api.Handshake{
+		Version:   trcapi.DefaultVersion,
+		Challenge: h.nonce,
+	}
+}
+
+// Handle is a handshake handler, which verifies the response of SRRS
+// and responds to the challenge of SRRS.
+func (h *ChallengeHandshake) Handle(msg *api.Message) (*api.Message, error) {
+	if _, err := DefaultHandshakeHandler(msg); err != nil {
+		return nil, err
+	}
+
+	var hs api.Handshake
+	if err := json.Unmarshal(msg.Payload, &hs); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode handshake payload")
+	}
+	if hs.Challenge == "" {
+		return nil, errors.New("SRRS did not send a challenge")
+	}
+	if !hmac.Equal([]byte(hs.Response), []byte(trcapi.SRRSResponse(h.secret, h.nonce, hs.Challenge))) {
+		return nil, errors.New("invalid challenge response")
+	}
+
+	b, err := json.Marshal(&api.Handshake{
+		Version:  hs.Version,
+		Response: trcapi.TRCResponse(h.secret, h.nonce, hs.Challenge),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	h.token = trcapi.HandshakeToken(h.secret, h.nonce, hs.Challenge)
+	h.mu.Unlock()
+	return api.NewMessage(api.MessageTypeHandshake, b, &msg.MessageID), nil
+}
+
+// Token returns the token derived from the handshake or empty string, if the handshake did not complete yet.
+func (h *ChallengeHandshake) Token() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.token
+}
+
 // Conn represents a connection to SRRS.
 type Conn struct {
 	decoder interface{ Decode(v interface{}) error }
@@ -54,8 +126,18 @@ type Conn struct {
 	errCh   chan error
 	closeCh chan struct{}
 
+	// closer closes the underlying transport on Close, if not nil.
+	closer io.Closer
+
 	handlers      *sync.Map
 	defaultHander Handler
+
+	// stateMu ensures the state messages are sent in the order, in which they are applied to state.
+	stateMu *sync.Mutex
+	// state is the state of TRC as sent to SRRS.
+	state *api.State
+	// seq is the sequence number of the last state message sent.
+	seq uint64
 }
 
 // Option represents a Conn option.
@@ -81,8 +163,17 @@ func WithDefaultHandler(h Handler) Option {
 	}
 }
 
+// WithState allows to specify the initial state of TRC, i.e. the state TRC had before connecting to SRRS.
+func WithState(st *api.State) Option {
+	return func(c *Conn) {
+		c.state = deepcopy.Copy(st).(*api.State)
+	}
+}
+
 // Connect establishes the TRC-side connection according to TRC API protocol
 // specification of version ver on w and r.
+// State requests are responded with the state of TRC, unless a handler for
+// api.MessageTypeGetState is specified.
 func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 	logger := zap.L()
 
@@ -94,6 +185,8 @@ func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 		closeCh:  make(chan struct{}),
 		errCh:    make(chan error),
 		handlers: &sync.Map{},
+		stateMu:  &sync.Mutex{},
+		state:    &api.State{},
 	}
 	for _, opt := range opts {
 		opt(conn)
@@ -130,10 +223,13 @@ func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 
 			var h Handler
 			v, ok := conn.handlers.Load(msg.Type)
-			if !ok {
-				h = conn.defaultHander
-			} else {
+			switch {
+			case ok:
 				h = v.(Handler)
+			case msg.Type == api.MessageTypeGetState:
+				h = conn.handleGetState
+			default:
+				h = conn.defaultHander
 			}
 
 			logger = logger.With(zap.Reflect("msg", msg))
@@ -152,7 +248,7 @@ func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 			logger.Debug("Sending response to SRRS...",
 				zap.Reflect("resp", resp),
 			)
-			if err := conn.encoder.Encode(resp); err != nil {
+			if err := conn.send(resp); err != nil {
 				conn.errCh <- err
 				return
 			}
@@ -161,6 +257,78 @@ func Connect(w io.Writer, r io.Reader, opts ...Option) *Conn {
 	return conn
 }
 
+// DialWebSocket dials SRRS accepting connections from TRC via WebSocket at url
+// and establishes the TRC-side connection on it.
+// The WebSocket is closed on Close.
+func DialWebSocket(url string, opts ...Option) (*Conn, error) {
+	ws, err := transport.DialWebSocket(nil, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn := Connect(ws, ws, opts...)
+	conn.closer = ws
+	return conn, nil
+}
+
+// send sends msg to SRRS. If msg is a state message, it is numbered and its payload is applied to the state.
+func (c *Conn) send(msg *api.Message) error {
+	if msg.Type != api.MessageTypeState {
+		return c.encoder.Encode(msg)
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	st := deepcopy.Copy(c.state).(*api.State)
+	if err := json.Unmarshal(msg.Payload, st); err != nil {
+		return errors.Wrap(err, "failed to decode state message payload")
+	}
+	msg.Seq = c.seq + 1
+	if err := c.encoder.Encode(msg); err != nil {
+		return err
+	}
+	c.state = st
+	c.seq = msg.Seq
+	return nil
+}
+
+// handleGetState is the handler of state requests, which is used unless a custom one is specified.
+// handleGetState responds with the state, which is sent directly, so that no state message
+// can be sent between the state being read and the response being sent.
+func (c *Conn) handleGetState(msg *api.Message) (*api.Message, error) {
+	if msg.ParentID != nil {
+		return nil, errors.New("TRC should not receive state responses")
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	b, err := json.Marshal(c.state)
+	if err != nil {
+		return nil, err
+	}
+	resp := api.NewMessage(api.MessageTypeGetState, b, &msg.MessageID)
+	resp.Seq = c.seq
+	if err := c.encoder.Encode(resp); err != nil {
+		return nil, errors.Wrap(err, "failed to send state response")
+	}
+	return nil, nil
+}
+
+// Seq returns the sequence number of the last state message sent.
+func (c *Conn) Seq() uint64 {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.seq
+}
+
+// State returns the state of TRC as sent to SRRS, i.e. the state, with which state requests are responded.
+func (c *Conn) State() *api.State {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return deepcopy.Copy(c.state).(*api.State)
+}
+
 // Ping sends ping to the TRC and waits for response.
 func (c *Conn) Ping() error {
 	return c.encoder.Encode(api.NewMessage(api.MessageTypePing, nil, nil))
@@ -172,7 +340,7 @@ func (c *Conn) SendState(st *api.State) error {
 	if err != nil {
 		return err
 	}
-	return c.encoder.Encode(api.NewMessage(api.MessageTypeState, b, nil))
+	return c.send(api.NewMessage(api.MessageTypeState, b, nil))
 }
 
 // SendHandshake sends handshake message.
@@ -187,6 +355,9 @@ func (c *Conn) SendHandshake(hs *api.Handshake) error {
 // Close closes the connection.
 func (c *Conn) Close() error {
 	close(c.closeCh)
+	if c.closer != nil {
+		return c.closer.Close()
+	}
 	return nil
 }
 
@@ -0,0 +1,133 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"go.uber.org/zap"
+)
+
+const (
+	// auditOperatorRefBox is the operator recorded for commands forwarded from the referee box.
+	auditOperatorRefBox = "refbox"
+
+	// auditOperatorSRRS is the operator recorded for actions performed by SRRS itself.
+	auditOperatorSRRS = "srrs"
+
+	// defaultAuditLimit is the maximum amount of entries returned on AuditEndpoint by default.
+	defaultAuditLimit = 100
+)
+
+// WithAuditLog allows to specify the audit log.
+// By default, the audit log is only stored in memory.
+func WithAuditLog(l *audit.Log) Option {
+	return func(srv *server) {
+		srv.audit = l
+	}
+}
+
+// clientIP returns the IP address of the client, which sent r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// record appends e to the audit log.
+// err is the error the action failed with, if any.
+func (srv *server) record(e *audit.Entry, err error) {
+	e.TRC = srv.name
+	e.Latency = int64(time.Since(e.Time) / time.Millisecond)
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	logger := zap.L().With(
+		zap.String("trc", e.TRC),
+		zap.String("operator", e.Operator),
+		zap.String("session", e.Session),
+		zap.String("action", e.Action),
+	)
+	logger.Debug("Recording audit log entry...")
+	if err := srv.audit.Append(e); err != nil {
+		logger.Error("Failed to record audit log entry", zap.Error(err))
+	}
+}
+
+// recordRefBoxCommand appends cmd forwarded from the referee box to the audit log.
+func (srv *server) recordRefBoxCommand(start time.Time, cmd api.Command, err error) {
+	req, _ := json.Marshal(cmd)
+	srv.record(&audit.Entry{
+		Time:     start,
+		Operator: auditOperatorRefBox,
+		ClientIP: srv.refbox.Addr(),
+		Action:   "command",
+		Request:  req,
+	}, err)
+}
+
+// handleAudit handles requests to AuditEndpoint.
+// The entries can be filtered by the `operator`, `since` and `until` query parameters.
+// `since` and `until` are in RFC3339 format.
+// At most `limit` latest entries are returned.
+func (srv *server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	logger := logcontext.Logger(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, errors.Errorf("expected a GET request, got %s", r.Method).Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	srv.sessionMu.RLock()
+	defer srv.sessionMu.RUnlock()
+
+	if srv.checkSession(w, r, credentials.RoleViewer) == nil {
+		return
+	}
+
+	q := r.URL.Query()
+	f := audit.Filter{
+		TRC:      srv.name,
+		Operator: q.Get("operator"),
+		Limit:    defaultAuditLimit,
+	}
+	for k, t := range map[string]*time.Time{
+		"since": &f.Since,
+		"until": &f.Until,
+	} {
+		s := q.Get(k)
+		if s == "" {
+			continue
+		}
+
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, errors.Wrapf(err, "invalid `%s` parameter", k).Error(), http.StatusBadRequest)
+			return
+		}
+		*t = v
+	}
+	if s := q.Get("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			http.Error(w, errors.Errorf("invalid `limit` parameter: %s", s).Error(), http.StatusBadRequest)
+			return
+		}
+		f.Limit = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(srv.audit.Query(f)); err != nil {
+		logger.Error("Failed to write audit log entries", zap.Error(err))
+	}
+}
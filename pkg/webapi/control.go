@@ -0,0 +1,101 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/control"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"go.uber.org/zap"
+)
+
+// WithControlLock allows to specify the lock, which grants control to a single session.
+func WithControlLock(l *control.Lock) Option {
+	return func(srv *server) {
+		srv.control = l
+	}
+}
+
+// client returns the control.Client identifying sess.
+func (sess *session) client() control.Client {
+	return control.Client{
+		Session:  sess.id,
+		Operator: sess.operator,
+	}
+}
+
+// checkControl checks whether sess holds control and acquires it, if no session does.
+// If sess cannot hold control, checkControl writes an error to w and returns false.
+func (srv *server) checkControl(w http.ResponseWriter, sess *session) bool {
+	if err := srv.control.Acquire(sess.client()); err != nil {
+		if h := srv.control.State().Holder; h != nil {
+			err = errors.Errorf("control is held by %s", h.Operator)
+		}
+		http.Error(w, err.Error(), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// handleControl handles requests to ControlEndpoint.
+func (srv *server) handleControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, errors.Errorf("expected a GET request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.sessionMu.RLock()
+	defer srv.sessionMu.RUnlock()
+
+	if srv.checkSession(w, r, credentials.RoleViewer) == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(srv.control.State()); err != nil {
+		logcontext.Logger(r.Context()).Error("Failed to write control state", zap.Error(err))
+	}
+}
+
+// makeControlHandler returns a handler, which calls f with the control.Client of the session.
+// Every request is recorded in the audit log as action.
+func (srv *server) makeControlHandler(action string, f func(c control.Client) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, errors.Errorf("expected a POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+			return
+		}
+
+		srv.sessionMu.RLock()
+		defer srv.sessionMu.RUnlock()
+
+		sess := srv.checkSession(w, r, credentials.RoleOperator)
+		if sess == nil {
+			return
+		}
+
+		logcontext.Logger(r.Context()).Info("Handling control action",
+			zap.String("action", action),
+			zap.String("session", sess.id),
+			zap.String("operator", sess.operator),
+		)
+
+		e := &audit.Entry{
+			Time:     time.Now(),
+			Operator: sess.operator,
+			Session:  sess.id,
+			ClientIP: clientIP(r),
+			Action:   action,
+		}
+		err := f(sess.client())
+		srv.record(e, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package webapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/control"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: handleControl(), makeControlHandler(), checkControl() in control.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestControl(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 16)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	operators, err := credentials.NewStore("")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(operators.Put("viewer", "secret", credentials.RoleViewer))
+	a.NoError(operators.Put("alice", "secret", credentials.RoleOperator))
+	a.NoError(operators.Put("bob", "secret", credentials.RoleOperator))
+
+	mux := http.NewServeMux()
+	RegisterHandlers(pool, mux,
+		WithOperators(operators),
+		WithDebounceInterval(0),
+	)
+
+	a.Equal(http.StatusMethodNotAllowed, do(mux, http.MethodGet, ControlEndpoint, "invalid", "").Code)
+
+	viewer := authenticate(t, mux, "viewer", "secret")
+	<-trcCh
+	a.Equal(http.StatusUnauthorized, do(mux, http.MethodGet, ControlEndpoint, "invalid", "").Code)
+	alice := authenticate(t, mux, "alice", "secret")
+	bob := authenticate(t, mux, "bob", "secret")
+
+	// state returns the control state as seen by viewer.
+	state := func() control.State {
+		var st control.State
+		rec := do(mux, http.MethodGet, ControlEndpoint, viewer, "")
+		if a.Equal(http.StatusOK, rec.Code) {
+			a.NoError(json.NewDecoder(rec.Body).Decode(&st))
+		}
+		return st
+	}
+	a.Nil(state().Holder)
+
+	a.Equal(http.StatusBadRequest, do(mux, http.MethodPost, ControlEndpoint, viewer, "").Code)
+	a.Equal(http.StatusBadRequest, do(mux, http.MethodGet, ControlRequestEndpoint, alice, "").Code)
+	a.Equal(http.StatusForbidden, do(mux, http.MethodPost, ControlRequestEndpoint, viewer, "").Code)
+	a.Equal(http.StatusForbidden, do(mux, http.MethodPost, CommandEndpoint, viewer, `"stop"`).Code)
+
+	// Control is acquired by the first command.
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, CommandEndpoint, alice, `"stop"`).Code)
+	<-msgCh
+	if st := state(); a.NotNil(st.Holder) {
+		a.Equal("alice", st.Holder.Operator)
+	}
+
+	a.Equal(http.StatusConflict, do(mux, http.MethodPost, CommandEndpoint, bob, `"start"`).Code)
+	a.Equal(http.StatusConflict, do(mux, http.MethodPost, ControlAcceptEndpoint, bob, "").Code)
+	a.Equal(http.StatusConflict, do(mux, http.MethodPost, ControlReleaseEndpoint, bob, "").Code)
+
+	// Denied requests leave control with the holder.
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, ControlRequestEndpoint, bob, "").Code)
+	if st := state(); a.NotNil(st.Request) {
+		a.Equal("bob", st.Request.Operator)
+	}
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, ControlDenyEndpoint, alice, "").Code)
+	st := state()
+	a.Nil(st.Request)
+	if a.NotNil(st.Holder) {
+		a.Equal("alice", st.Holder.Operator)
+	}
+
+	// Accepted requests transfer control.
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, ControlRequestEndpoint, bob, "").Code)
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, ControlAcceptEndpoint, alice, "").Code)
+	if st := state(); a.NotNil(st.Holder) {
+		a.Equal("bob", st.Holder.Operator)
+	}
+	a.Equal(http.StatusConflict, do(mux, http.MethodPost, CommandEndpoint, alice, `"start"`).Code)
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, CommandEndpoint, bob, `"start"`).Code)
+	<-msgCh
+
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, ControlReleaseEndpoint, bob, "").Code)
+	a.Nil(state().Holder)
+
+	// Control actions are recorded in the audit log.
+	rec := do(mux, http.MethodGet, AuditEndpoint, viewer, "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var entries []*audit.Entry
+		a.NoError(json.NewDecoder(rec.Body).Decode(&entries))
+
+		var actions []string
+		for _, e := range entries {
+			if e.Error == "" && e.Action != "command" {
+				actions = append(actions, e.Operator+" "+e.Action)
+			}
+		}
+		a.Equal([]string{
+			"bob control_request",
+			"alice control_deny",
+			"bob control_request",
+			"alice control_accept",
+			"bob control_release",
+		}, actions)
+	}
+}
+
+//Test_items: handleState() in webapi.go, checkControl() in control.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestControlReconnect(t *testing.T) {
+	a := assert.New(t)
+
+	ts := newTestServer(t, WithDebounceInterval(0))
+	defer ts.Close()
+
+	mux, key, msgCh := ts.mux, ts.key, ts.msgCh
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	wsConn := openState(t, srv, key)
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, CommandEndpoint, key, `"stop"`).Code)
+	<-msgCh
+	wsConn.Close()
+
+	// The session may only reconnect once the server noticed the closed WebSocket.
+	var err error
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		wsConn, _, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/"+StateEndpoint, nil)
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+		if err = wsConn.WriteJSON(key); err == nil {
+			_, err = readState(wsConn)
+		}
+		if err == nil {
+			break
+		}
+		wsConn.Close()
+	}
+	if !a.NoError(err, "Failed to reconnect") {
+		t.FailNow()
+	}
+	defer wsConn.Close()
+
+	// Control is kept across reconnects.
+	time.Sleep(100 * time.Millisecond)
+	rec := do(mux, http.MethodGet, ControlEndpoint, key, "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var st control.State
+		a.NoError(json.NewDecoder(rec.Body).Decode(&st))
+		a.NotNil(st.Holder)
+	}
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, CommandEndpoint, key, `"start"`).Code)
+	<-msgCh
+}
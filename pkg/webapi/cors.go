@@ -0,0 +1,99 @@
+package webapi
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"go.uber.org/zap"
+)
+
+const (
+	// corsAllowMethods are the methods allowed in cross-origin requests.
+	corsAllowMethods = "GET, POST, DELETE, OPTIONS"
+
+	// corsAllowHeaders are the headers allowed in cross-origin requests.
+	corsAllowHeaders = "Authorization, Content-Type"
+
+	// corsMaxAge is the time in seconds for which the result of a preflight request may be cached.
+	corsMaxAge = 600
+)
+
+var errOriginNotAllowed = errors.New("origin not allowed")
+
+// WithAllowedOrigins allows to specify the origins, from which cross-origin requests
+// and WebSocket connections are accepted, in addition to the origin of SRRS itself.
+// An origin is of the form `scheme://host[:port]`. `*` allows all origins.
+// By default, only same-origin requests are accepted.
+// WithAllowedOrigins can be applied at runtime using Server.Apply.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(srv *server) {
+		m := make(map[string]struct{}, len(origins))
+		for _, o := range origins {
+			m[strings.ToLower(strings.TrimSuffix(strings.TrimSpace(o), "/"))] = struct{}{}
+		}
+
+		srv.settingsMu.Lock()
+		srv.allowedOrigins = m
+		srv.settingsMu.Unlock()
+	}
+}
+
+// checkOrigin reports whether r is allowed given its Origin header.
+// Requests without the Origin header and same-origin requests are always allowed.
+func (srv *server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	srv.settingsMu.RLock()
+	defer srv.settingsMu.RUnlock()
+
+	if _, ok := srv.allowedOrigins["*"]; ok {
+		return true
+	}
+	_, ok := srv.allowedOrigins[strings.ToLower(origin)]
+	return ok
+}
+
+// handleCORS applies the origin policy to r.
+// handleCORS writes the response and returns false, if r is rejected or is a preflight request.
+func (srv *server) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if !srv.checkOrigin(r) {
+		logcontext.Logger(r.Context()).Warn("Rejecting request from disallowed origin", zap.String("origin", origin))
+		http.Error(w, errOriginNotAllowed.Error(), http.StatusForbidden)
+		return false
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Credentials", "true")
+
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return true
+	}
+
+	h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+	h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+	h.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+	w.WriteHeader(http.StatusNoContent)
+	return false
+}
@@ -0,0 +1,67 @@
+package webapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: WithAllowedOrigins(), handleCORS() in cors.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestAllowedOrigins(t *testing.T) {
+	a := assert.New(t)
+
+	const allowed = "http://ui.example.com"
+
+	ts := newTestServer(t, WithAllowedOrigins(allowed))
+	defer ts.Close()
+
+	mux := ts.mux
+
+	// doOrigin serves a request with method to endpoint ep of mux sent from origin.
+	doOrigin := func(method, ep, origin string, f func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/"+ep, nil)
+		req.Header.Set("Origin", origin)
+		if f != nil {
+			f(req)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := doOrigin(http.MethodOptions, CommandEndpoint, allowed, func(req *http.Request) {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	})
+	a.Equal(http.StatusNoContent, rec.Code)
+	a.Equal(allowed, rec.Header().Get("Access-Control-Allow-Origin"))
+	a.Contains(rec.Header().Get("Access-Control-Allow-Methods"), http.MethodPost)
+	a.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+
+	rec = doOrigin(http.MethodGet, AuthEndpoint, "http://evil.example.com", nil)
+	a.Equal(http.StatusForbidden, rec.Code)
+	a.Empty(rec.Header().Get("Access-Control-Allow-Origin"))
+
+	rec = doOrigin(http.MethodGet, AuthEndpoint, allowed, func(req *http.Request) {
+		req.SetBasicAuth("", testToken)
+	})
+	a.Equal(http.StatusOK, rec.Code)
+	a.Equal(allowed, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/" + StateEndpoint
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"http://evil.example.com"}})
+	a.Error(err)
+	if a.NotNil(resp) {
+		a.Equal(http.StatusForbidden, resp.StatusCode)
+	}
+}
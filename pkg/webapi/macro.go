@@ -0,0 +1,212 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/control"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"github.com/rvolosatovs/turtlitto/pkg/macro"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"go.uber.org/zap"
+)
+
+// handleMacros handles requests to MacrosEndpoint.
+// GET lists the stored macros, POST stores the macro in the request body
+// and DELETE removes the macro named by the `name` query parameter.
+func (srv *server) handleMacros(w http.ResponseWriter, r *http.Request) {
+	logger := logcontext.Logger(r.Context())
+
+	srv.sessionMu.RLock()
+	defer srv.sessionMu.RUnlock()
+
+	role := credentials.RoleOperator
+	if r.Method == http.MethodGet {
+		role = credentials.RoleViewer
+	}
+	if srv.checkSession(w, r, role) == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(srv.macros.List()); err != nil {
+			logger.Error("Failed to write macros", zap.Error(err))
+		}
+
+	case http.MethodPost:
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+
+		var m macro.Macro
+		if err := dec.Decode(&m); err != nil {
+			http.Error(w, errors.Wrap(err, "failed to decode macro").Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("Storing macro", zap.String("macro", m.Name))
+		if err := srv.macros.Put(&m); err != nil {
+			http.Error(w, errors.Wrap(err, "failed to store macro").Error(), http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+
+		logger.Info("Deleting macro", zap.String("macro", name))
+		switch err := srv.macros.Delete(name); err {
+		case nil:
+		case macro.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, errors.Wrap(err, "failed to delete macro").Error(), http.StatusInternalServerError)
+		}
+
+	default:
+		http.Error(w, errors.Errorf("expected a GET, POST or DELETE request, got %s", r.Method).Error(), http.StatusMethodNotAllowed)
+	}
+}
+
+// macroStep is the request recorded in the audit log for a command or turtle state sent by a macro.
+type macroStep struct {
+	Macro   string                      `json:"macro"`
+	Step    int                         `json:"step"`
+	Command api.Command                 `json:"command,omitempty"`
+	Turtles map[string]*api.TurtleState `json:"turtles,omitempty"`
+}
+
+// macroExecutor is the macro.Executor, which executes the macro started by sess on TRC.
+// Every command and turtle state sent is recorded in the audit log.
+type macroExecutor struct {
+	*trcapi.Conn
+
+	srv   *server
+	sess  *session
+	macro string
+}
+
+// record appends step sent at start as action to the audit log.
+func (ex *macroExecutor) record(action string, start time.Time, step macroStep, err error) {
+	step.Macro = ex.macro
+	if p := ex.srv.runner.Progress(); p != nil {
+		step.Step = p.Step
+	}
+	req, _ := json.Marshal(step)
+	ex.srv.record(&audit.Entry{
+		Time:     start,
+		Operator: ex.sess.operator,
+		Session:  ex.sess.id,
+		Action:   action,
+		Request:  req,
+	}, err)
+}
+
+// SetCommand sends cmd to TRC and records it in the audit log.
+func (ex *macroExecutor) SetCommand(ctx context.Context, cmd api.Command) error {
+	start := time.Now()
+	err := ex.Conn.SetCommand(ctx, cmd)
+	ex.record("macro_command", start, macroStep{Command: cmd}, err)
+	return err
+}
+
+// SetTurtleState sends st to TRC and records it in the audit log.
+func (ex *macroExecutor) SetTurtleState(ctx context.Context, st map[string]*api.TurtleState) error {
+	start := time.Now()
+	err := ex.Conn.SetTurtleState(ctx, st)
+	ex.record("macro_turtles", start, macroStep{Turtles: st}, err)
+	return err
+}
+
+// runMacro starts the macro named in the request body on trcConn on behalf of sess.
+// The macro keeps running after the request is handled, until it finishes or sess loses control.
+func (srv *server) runMacro(ctx context.Context, sess *session, trcConn *trcapi.Conn, dec *json.Decoder) error {
+	var name string
+	if err := dec.Decode(&name); err != nil {
+		return errors.Wrap(err, "failed to decode request body")
+	}
+	if srv.getMode() == ModeRefBox {
+		return errRefBoxMode
+	}
+
+	m, err := srv.macros.Get(name)
+	if err != nil {
+		return err
+	}
+
+	logger := logcontext.Logger(ctx).With(zap.String("macro", name))
+
+	// Subscribe before starting, so that a handover in between is not missed.
+	controlCh, closeControlFn := srv.control.Subscribe()
+	if !srv.control.Holds(sess.id) {
+		closeControlFn()
+		return control.ErrNotHolder
+	}
+
+	logger.Info("Starting macro")
+
+	srv.acquire()
+	macroCtx, cancel := context.WithCancel(logcontext.WithLogger(context.Background(), logger))
+	done, err := srv.runner.Start(macroCtx, &macroExecutor{
+		Conn:  trcConn,
+		srv:   srv,
+		sess:  sess,
+		macro: m.Name,
+	}, m)
+	if err != nil {
+		cancel()
+		closeControlFn()
+		srv.release()
+		return err
+	}
+
+	go func() {
+		defer srv.release()
+		defer closeControlFn()
+		defer cancel()
+
+		for {
+			select {
+			case <-done:
+				return
+
+			case <-controlCh:
+				if srv.control.Holds(sess.id) {
+					continue
+				}
+				// The macro must not keep driving the robots on behalf of the previous controller.
+				logger.Info("Control lost by the session, which started the macro, cancelling macro...")
+				cancel()
+			}
+		}
+	}()
+	return nil
+}
+
+// handleMacroCancel handles requests to MacroCancelEndpoint.
+func (srv *server) handleMacroCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, errors.Errorf("expected a POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.sessionMu.RLock()
+	defer srv.sessionMu.RUnlock()
+
+	sess := srv.checkSession(w, r, credentials.RoleOperator)
+	if sess == nil || !srv.checkControl(w, sess) {
+		return
+	}
+
+	logcontext.Logger(r.Context()).Info("Cancelling macro")
+	if err := srv.runner.Cancel(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+}
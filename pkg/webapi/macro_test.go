@@ -0,0 +1,155 @@
+package webapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/macro"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitMacro reads States from wsConn until one reports the macro progress with status.
+func waitMacro(t *testing.T, wsConn *websocket.Conn, status macro.Status) *macro.Progress {
+	for {
+		st, err := readState(wsConn)
+		if err != nil {
+			t.Fatalf("Failed to read state while waiting for macro status `%s`: %s", status, err)
+		}
+		if st.Macro != nil && st.Macro.Status == status {
+			return st.Macro
+		}
+	}
+}
+
+//Test_items: handleMacros(), runMacro(), handleMacroCancel() in macro.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMacros(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 16)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	operators, err := credentials.NewStore("")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(operators.Put("viewer", "secret", credentials.RoleViewer))
+	a.NoError(operators.Put("operator", "secret", credentials.RoleOperator))
+
+	mux := http.NewServeMux()
+	RegisterHandlers(pool, mux,
+		WithOperators(operators),
+		WithDebounceInterval(0),
+	)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	a.Equal(http.StatusMethodNotAllowed, do(mux, http.MethodPost, MacroRunEndpoint, "", `"kick_off"`).Code)
+
+	key := authenticate(t, mux, "", testToken)
+	<-trcCh
+
+	viewer := authenticate(t, mux, "viewer", "secret")
+	other := authenticate(t, mux, "operator", "secret")
+
+	const m = `{"name":"kick_off","steps":[{"command":"stop"},{"wait_for":{"command":"penalty_cyan"}}]}`
+
+	a.Equal(http.StatusUnauthorized, do(mux, http.MethodPost, MacrosEndpoint, "invalid", m).Code)
+	a.Equal(http.StatusForbidden, do(mux, http.MethodPost, MacrosEndpoint, viewer, m).Code)
+	a.Equal(http.StatusBadRequest, do(mux, http.MethodPost, MacrosEndpoint, key, `{"name":"kick_off","unknown":42}`).Code)
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, MacrosEndpoint, key, m).Code)
+
+	rec := do(mux, http.MethodGet, MacrosEndpoint, viewer, "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var listed []*macro.Macro
+		a.NoError(json.NewDecoder(rec.Body).Decode(&listed))
+		if a.Len(listed, 1) {
+			a.Equal("kick_off", listed[0].Name)
+			a.Len(listed[0].Steps, 2)
+		}
+	}
+
+	wsConn := openState(t, srv, viewer)
+	defer wsConn.Close()
+
+	a.Equal(http.StatusForbidden, do(mux, http.MethodPost, MacroRunEndpoint, viewer, `"kick_off"`).Code)
+	a.Equal(http.StatusBadRequest, do(mux, http.MethodPost, MacroRunEndpoint, key, `"unknown"`).Code)
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, MacroRunEndpoint, key, `"kick_off"`).Code)
+
+	p := waitMacro(t, wsConn, macro.StatusRunning)
+	a.Equal("kick_off", p.Macro)
+	a.Equal(2, p.Steps)
+
+	var st api.State
+	a.NoError(json.Unmarshal((<-msgCh).Payload, &st))
+	a.Equal(api.CommandStop, st.Command)
+
+	// The runner waits for penalty_cyan once the command is sent.
+	for p.Step != 1 {
+		p = waitMacro(t, wsConn, macro.StatusRunning)
+	}
+
+	a.Equal(http.StatusBadRequest, do(mux, http.MethodPost, MacroRunEndpoint, key, `"kick_off"`).Code)
+
+	// Only the controller can run and cancel macros.
+	a.Equal(http.StatusConflict, do(mux, http.MethodPost, MacroRunEndpoint, other, `"kick_off"`).Code)
+	a.Equal(http.StatusConflict, do(mux, http.MethodPost, MacroCancelEndpoint, other, "").Code)
+	a.Equal(http.StatusForbidden, do(mux, http.MethodPost, MacroCancelEndpoint, viewer, "").Code)
+	a.Equal(http.StatusBadRequest, do(mux, http.MethodGet, MacroCancelEndpoint, key, "").Code)
+
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, MacroCancelEndpoint, key, "").Code)
+	p = waitMacro(t, wsConn, macro.StatusCancelled)
+	a.Equal("kick_off", p.Macro)
+	a.Equal(1, p.Step)
+
+	a.Equal(http.StatusConflict, do(mux, http.MethodPost, MacroCancelEndpoint, key, "").Code)
+
+	// Handing control over cancels the macro started by the previous controller.
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, MacroRunEndpoint, key, `"kick_off"`).Code)
+	waitMacro(t, wsConn, macro.StatusRunning)
+	<-msgCh
+
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, ControlRequestEndpoint, other, "").Code)
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, ControlAcceptEndpoint, key, "").Code)
+	p = waitMacro(t, wsConn, macro.StatusCancelled)
+	a.Equal("kick_off", p.Macro)
+	a.Equal(http.StatusConflict, do(mux, http.MethodPost, MacroCancelEndpoint, other, "").Code)
+
+	a.Equal(http.StatusOK, do(mux, http.MethodDelete, MacrosEndpoint+"?name=kick_off", key, "").Code)
+	a.Equal(http.StatusNotFound, do(mux, http.MethodDelete, MacrosEndpoint+"?name=kick_off", key, "").Code)
+
+	// Commands sent by macros are recorded in the audit log on behalf of the session, which started them.
+	rec = do(mux, http.MethodGet, AuditEndpoint+"?operator=trc", viewer, "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var entries []*audit.Entry
+		a.NoError(json.NewDecoder(rec.Body).Decode(&entries))
+
+		var steps []*audit.Entry
+		for _, e := range entries {
+			if e.Action == "macro_command" {
+				steps = append(steps, e)
+			}
+		}
+		if a.Len(steps, 2) {
+			for _, e := range steps {
+				a.NotEmpty(e.Session)
+				a.Empty(e.Error)
+				a.JSONEq(`{"macro":"kick_off","step":0,"command":"stop"}`, string(e.Request))
+			}
+		}
+	}
+}
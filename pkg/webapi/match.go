@@ -0,0 +1,82 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"github.com/rvolosatovs/turtlitto/pkg/match"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"go.uber.org/zap"
+)
+
+// WithMatchTracker allows to specify the tracker of the match state.
+// By default, a tracker with match.DefaultHalfDuration is used.
+func WithMatchTracker(t *match.Tracker) Option {
+	return func(srv *server) {
+		srv.match = t
+	}
+}
+
+// watchCommands feeds the commands sent to and received from TRC on trcConn to the match tracker
+// until trcConn is closed. watchCommands is called by the pool with every new connection.
+func (srv *server) watchCommands(trcConn *trcapi.Conn) {
+	logger := zap.L()
+
+	ch, closeFn, err := trcConn.SubscribeCommands(context.Background())
+	if err != nil {
+		logger.Warn("Failed to subscribe to commands", zap.Error(err))
+		return
+	}
+
+	go func() {
+		defer closeFn()
+
+		for cmd := range ch {
+			logger.Debug("Observed command", zap.String("command", string(cmd)))
+			srv.match.Observe(cmd)
+		}
+	}()
+}
+
+// handleMatch handles requests to MatchEndpoint.
+func (srv *server) handleMatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, errors.Errorf("expected a GET request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.sessionMu.RLock()
+	defer srv.sessionMu.RUnlock()
+
+	if srv.checkSession(w, r, credentials.RoleViewer) == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(srv.match.State()); err != nil {
+		logcontext.Logger(r.Context()).Error("Failed to write match state", zap.Error(err))
+	}
+}
+
+// handleMatchReset handles requests to MatchResetEndpoint.
+func (srv *server) handleMatchReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, errors.Errorf("expected a POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.sessionMu.RLock()
+	defer srv.sessionMu.RUnlock()
+
+	sess := srv.checkSession(w, r, credentials.RoleOperator)
+	if sess == nil || !srv.checkControl(w, sess) {
+		return
+	}
+
+	logcontext.Logger(r.Context()).Info("Resetting match")
+	srv.match.Reset()
+}
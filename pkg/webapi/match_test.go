@@ -0,0 +1,84 @@
+package webapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/match"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: watchCommands(), handleMatch(), handleMatchReset() in match.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMatch(t *testing.T) {
+	a := assert.New(t)
+
+	pool := trcapi.NewPool(nil)
+	defer pool.Close()
+
+	mux := http.NewServeMux()
+	RegisterHandlers(pool, mux)
+
+	srrsIn, trcOut := io.Pipe()
+	trcIn, srrsOut := io.Pipe()
+	defer srrsIn.Close()
+	defer trcIn.Close()
+
+	trc := trctest.Connect(trcOut, trcIn,
+		trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+		trctest.WithHandler(api.MessageTypePing, trctest.DefaultPingHandler),
+	)
+	defer trc.Close()
+
+	go trc.SendHandshake(&api.Handshake{
+		Version: trcapi.DefaultVersion,
+		Token:   testToken,
+	})
+
+	trcConn, err := trcapi.Connect(trcapi.DefaultVersion, srrsOut, srrsIn)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(pool.Set(trcConn, func() { trcConn.Close() }))
+
+	// Commands sent by TRC are observed before any client connects.
+	a.NoError(trc.SendState(&api.State{
+		Command: api.CommandKickOffCyan,
+	}))
+
+	key := authenticate(t, mux, "", testToken)
+
+	// waitMatch polls MatchEndpoint until the match is in period and phase.
+	waitMatch := func(period match.Period, phase match.Phase) {
+		var st match.State
+		for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			rec := do(mux, http.MethodGet, MatchEndpoint, key, "")
+			if !a.Equal(http.StatusOK, rec.Code) || !a.NoError(json.NewDecoder(rec.Body).Decode(&st)) {
+				t.FailNow()
+			}
+			if st.Period == period && st.Phase == phase {
+				return
+			}
+		}
+		t.Fatalf("Timed out waiting for match to be in period %s and phase %s, got %s and %s", period, phase, st.Period, st.Phase)
+	}
+	waitMatch(match.PeriodFirstHalf, match.PhaseKickOff)
+
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, MatchResetEndpoint, key, "").Code)
+	waitMatch(match.PeriodPreGame, match.PhaseStopped)
+
+	// A repeated identical command is observed, although the state of TRC does not change.
+	a.NoError(trc.SendState(&api.State{
+		Command: api.CommandKickOffCyan,
+	}))
+	waitMatch(match.PeriodFirstHalf, match.PhaseKickOff)
+}
@@ -0,0 +1,43 @@
+package webapi
+
+import "sync"
+
+// notifier notifies subscribers about changes.
+// The zero value of notifier is ready to use.
+type notifier struct {
+	mu   sync.RWMutex
+	subs map[chan struct{}]struct{}
+}
+
+// subscribe opens a subscription to changes.
+// subscribe returns a read-only channel, on which a value is sent every time
+// there is a change and a function, which must be used to close the subscription.
+func (n *notifier) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	if n.subs == nil {
+		n.subs = make(map[chan struct{}]struct{})
+	}
+	n.subs[ch] = struct{}{}
+	n.mu.Unlock()
+
+	return ch, func() {
+		n.mu.Lock()
+		delete(n.subs, ch)
+		n.mu.Unlock()
+		close(ch)
+	}
+}
+
+// notify notifies the subscribers about a change.
+func (n *notifier) notify() {
+	n.mu.RLock()
+	for ch := range n.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	n.mu.RUnlock()
+}
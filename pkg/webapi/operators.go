@@ -0,0 +1,183 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"go.uber.org/zap"
+)
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// trcOperator is the operator of sessions authenticated using the TRC token.
+// Operators of this name cannot be added on OperatorsEndpoint.
+const trcOperator = "trc"
+
+// WithOperators allows to specify the store of operator credentials.
+// Operators authenticate using their name and password as basic auth credentials.
+func WithOperators(st *credentials.Store) Option {
+	return func(srv *server) {
+		srv.operators = st
+	}
+}
+
+// WithTRCTokenAuth allows to specify whether the token received from TRC is accepted for authentication.
+// Sessions authenticated using the TRC token have credentials.RoleAdmin and are recorded as operator "trc".
+// TRC token authentication is enabled by default.
+func WithTRCTokenAuth(enabled bool) Option {
+	return func(srv *server) {
+		srv.trcTokenAuth = enabled
+	}
+}
+
+// clientCertName returns the common name of the verified client certificate of r, if any.
+// Client certificates are only verified if the TLS server is configured with client CAs.
+func clientCertName(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	return name, name != ""
+}
+
+// authenticate authenticates the client, which sent r, and returns the name and role of the operator.
+// Clients presenting a verified certificate, which names a stored operator, are authenticated by it.
+// Otherwise, the basic auth credentials in r are checked against the operator store first
+// and against the TRC token of trcConn, if the operator is unknown. The operator is then trcOperator,
+// regardless of the name in the credentials.
+// If authentication fails, authenticate returns the HTTP status code, which should be returned to the client.
+func (srv *server) authenticate(r *http.Request, trcConn *trcapi.Conn) (string, credentials.Role, int, error) {
+	if name, ok := clientCertName(r); ok && srv.operators != nil {
+		if role, err := srv.operators.Role(name); err == nil {
+			return name, role, http.StatusOK, nil
+		}
+		logcontext.Logger(r.Context()).Debug("Client certificate does not name an operator", zap.String("name", name))
+	}
+
+	name, password, ok := r.BasicAuth()
+	if ok && srv.operators != nil {
+		switch role, err := srv.operators.Authenticate(name, password); err {
+		case nil:
+			return name, role, http.StatusOK, nil
+		case credentials.ErrNotFound:
+		default:
+			return "", "", http.StatusUnauthorized, errInvalidCredentials
+		}
+	}
+
+	if !srv.trcTokenAuth {
+		if !ok {
+			return "", "", http.StatusBadRequest, errAuthorizationHeader
+		}
+		return "", "", http.StatusUnauthorized, errInvalidCredentials
+	}
+
+	trcTok, err := trcConn.Token()
+	if err != nil {
+		return "", "", http.StatusInternalServerError, errors.Wrap(err, errFailedToGetToken.Error())
+	}
+
+	if !ok && trcTok != "" {
+		return "", "", http.StatusBadRequest, errAuthorizationHeader
+	}
+
+	if trcTok != "" && password != trcTok {
+		return "", "", http.StatusUnauthorized, errInvalidToken
+	}
+	return trcOperator, credentials.RoleAdmin, http.StatusOK, nil
+}
+
+// removeOperatorSessions removes the sessions of the operator called name.
+func (srv *server) removeOperatorSessions(logger *zap.Logger, name string) {
+	srv.sessionMu.Lock()
+	defer srv.sessionMu.Unlock()
+
+	for _, sess := range srv.sessions {
+		if sess.operator == name {
+			logger.Info("Removing session of revoked operator", zap.String("session", sess.id))
+			srv.removeSession(sess)
+		}
+	}
+}
+
+// operatorRequest is the request body of a POST request to OperatorsEndpoint.
+type operatorRequest struct {
+	Name     string           `json:"name"`
+	Password string           `json:"password,omitempty"`
+	Role     credentials.Role `json:"role"`
+}
+
+// handleOperators handles requests to OperatorsEndpoint.
+// GET lists the operators, POST adds or replaces the operator in the request body
+// and DELETE revokes the operator named by the `name` query parameter.
+// The sessions of the revoked operator are removed.
+func (srv *server) handleOperators(w http.ResponseWriter, r *http.Request) {
+	logger := logcontext.Logger(r.Context())
+
+	// Hashing passwords is slow, so the sessions are not locked while the store is updated.
+	srv.sessionMu.RLock()
+	sess := srv.checkSession(w, r, credentials.RoleAdmin)
+	srv.sessionMu.RUnlock()
+	if sess == nil {
+		return
+	}
+
+	if srv.operators == nil {
+		http.Error(w, "no operator store configured", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(srv.operators.List()); err != nil {
+			logger.Error("Failed to write operators", zap.Error(err))
+		}
+
+	case http.MethodPost:
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+
+		var req operatorRequest
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, errors.Wrap(err, "failed to decode operator").Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name == trcOperator {
+			http.Error(w, errors.Errorf("operator name `%s` is reserved", trcOperator).Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("Storing operator",
+			zap.String("name", req.Name),
+			zap.String("role", string(req.Role)),
+		)
+		if err := srv.operators.Put(req.Name, req.Password, req.Role); err != nil {
+			http.Error(w, errors.Wrap(err, "failed to store operator").Error(), http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+
+		logger.Info("Revoking operator", zap.String("name", name))
+		switch err := srv.operators.Delete(name); err {
+		case nil:
+		case credentials.ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		default:
+			http.Error(w, errors.Wrap(err, "failed to revoke operator").Error(), http.StatusInternalServerError)
+			return
+		}
+
+		srv.removeOperatorSessions(logger, name)
+
+	default:
+		http.Error(w, errors.Errorf("expected a GET, POST or DELETE request, got %s", r.Method).Error(), http.StatusMethodNotAllowed)
+	}
+}
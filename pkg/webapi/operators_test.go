@@ -0,0 +1,113 @@
+package webapi_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: clientCertName(), authenticate() in operators.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestClientCertificate(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 1)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	operators, err := credentials.NewStore("")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(operators.Put("laptop", "", credentials.RoleOperator))
+
+	mux := http.NewServeMux()
+	RegisterHandlers(pool, mux, WithOperators(operators))
+
+	// authenticateCert authenticates at mux using a verified client certificate with common name cn.
+	authenticateCert := func(cn string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+AuthEndpoint, nil)
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{
+				{Subject: pkix.Name{CommonName: cn}},
+			}},
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	a.Equal(http.StatusBadRequest, authenticateCert("stranger").Code)
+
+	rec := authenticateCert("laptop")
+	if !a.Equal(http.StatusOK, rec.Code) {
+		t.FailNow()
+	}
+	<-trcCh
+
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, CommandEndpoint, rec.Body.String(), `"stop"`).Code)
+	<-msgCh
+}
+
+//Test_items: handleOperators(), authenticate() in operators.go, removeSession() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRevokeOperator(t *testing.T) {
+	a := assert.New(t)
+
+	operators, err := credentials.NewStore("")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	ts := newTestServer(t, WithOperators(operators))
+	defer ts.Close()
+
+	mux := ts.mux
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// The name sent along with the TRC token does not name the operator of the session.
+	admin := authenticate(t, mux, "alice", testToken)
+
+	a.Equal(http.StatusBadRequest, do(mux, http.MethodPost, OperatorsEndpoint, admin, `{"name":"trc","password":"secret","role":"admin"}`).Code)
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, OperatorsEndpoint, admin, `{"name":"alice","password":"secret","role":"operator"}`).Code)
+	alice := authenticate(t, mux, "alice", "secret")
+
+	wsConn := openState(t, srv, alice)
+	defer wsConn.Close()
+
+	a.Equal(http.StatusOK, do(mux, http.MethodDelete, OperatorsEndpoint+"?name=alice", admin, "").Code)
+	a.Equal(http.StatusUnauthorized, do(mux, http.MethodGet, ControlEndpoint, alice, "").Code)
+	a.Equal(http.StatusOK, do(mux, http.MethodGet, ControlEndpoint, admin, "").Code)
+
+	// The WebSocket of the revoked operator is closed.
+	a.NoError(wsConn.SetReadDeadline(time.Now().Add(timeout)))
+	for {
+		_, _, err := wsConn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if a.IsType(&websocket.CloseError{}, err) {
+			a.Equal(websocket.ClosePolicyViolation, err.(*websocket.CloseError).Code)
+		}
+		break
+	}
+}
@@ -0,0 +1,63 @@
+package webapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRequestRate is the default amount of requests per second a session may send to TRC.
+	DefaultRequestRate = 5
+
+	// DefaultRequestBurst is the default amount of requests a session may send to TRC at once.
+	DefaultRequestBurst = 10
+
+	// DefaultDebounceInterval is the default interval, within which identical consecutive requests
+	// of a session are ignored.
+	DefaultDebounceInterval = 250 * time.Millisecond
+
+	// DefaultAuthAttempts is the default amount of consecutive failed authentication attempts,
+	// after which a client IP is locked out.
+	DefaultAuthAttempts = 5
+
+	// DefaultAuthLockout is the default duration of an authentication lockout.
+	DefaultAuthLockout = time.Minute
+)
+
+// WithRequestRateLimit allows to specify the amount of requests per second each session
+// may send to TRC and the maximum burst size.
+// If rate is not positive, requests are not limited.
+// WithRequestRateLimit can be applied at runtime using Server.Apply.
+func WithRequestRateLimit(rate float64, burst int) Option {
+	return func(srv *server) {
+		srv.limiter.SetLimit(rate, burst)
+	}
+}
+
+// WithDebounceInterval allows to specify the interval, within which identical consecutive requests
+// of a session are ignored. Ignored requests are answered with 409 Conflict and recorded in the audit log.
+// If d is not positive, requests are not debounced.
+// WithDebounceInterval can be applied at runtime using Server.Apply.
+func WithDebounceInterval(d time.Duration) Option {
+	return func(srv *server) {
+		srv.debouncer.SetInterval(d)
+	}
+}
+
+// WithAuthLockout allows to specify the amount of consecutive failed authentication attempts,
+// after which a client IP is locked out for d.
+// If attempts is not positive, clients are never locked out.
+// WithAuthLockout can be applied at runtime using Server.Apply.
+func WithAuthLockout(attempts int, d time.Duration) Option {
+	return func(srv *server) {
+		srv.lockout.SetPolicy(attempts, d)
+	}
+}
+
+// tooManyRequests writes a 429 response with err to w, which asks the client to retry after d.
+func tooManyRequests(w http.ResponseWriter, err error, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
@@ -0,0 +1,68 @@
+package webapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: WithRequestRateLimit(), WithDebounceInterval(), WithAuthLockout() in ratelimit.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestRateLimit(t *testing.T) {
+	a := assert.New(t)
+
+	ts := newTestServer(t,
+		WithRequestRateLimit(0.01, 2),
+		WithDebounceInterval(time.Minute),
+		WithAuthLockout(2, time.Minute),
+	)
+	defer ts.Close()
+
+	mux, key, msgCh := ts.mux, ts.key, ts.msgCh
+
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, CommandEndpoint, key, `"stop"`).Code)
+	select {
+	case <-msgCh:
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for command to arrive at TRC")
+	}
+
+	// Identical consecutive command is ignored, but recorded.
+	a.Equal(http.StatusConflict, do(mux, http.MethodPost, CommandEndpoint, key, `"stop"`).Code)
+	select {
+	case <-msgCh:
+		t.Error("Debounced command arrived at TRC")
+	default:
+	}
+
+	rec := do(mux, http.MethodGet, AuditEndpoint, key, "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var entries []*audit.Entry
+		a.NoError(json.NewDecoder(rec.Body).Decode(&entries))
+
+		var cmds []*audit.Entry
+		for _, e := range entries {
+			if e.Action == "command" {
+				cmds = append(cmds, e)
+			}
+		}
+		if a.Len(cmds, 2) {
+			a.Empty(cmds[0].Error)
+			a.NotEmpty(cmds[1].Error)
+			a.Equal(`"stop"`, string(cmds[1].Request))
+		}
+	}
+
+	a.Equal(http.StatusTooManyRequests, do(mux, http.MethodPost, CommandEndpoint, key, `"start"`).Code)
+
+	a.Equal(http.StatusUnauthorized, do(mux, http.MethodGet, AuthEndpoint, "invalid", "").Code)
+	a.Equal(http.StatusUnauthorized, do(mux, http.MethodGet, AuthEndpoint, "invalid", "").Code)
+	a.Equal(http.StatusTooManyRequests, do(mux, http.MethodGet, AuthEndpoint, testToken, "").Code)
+}
@@ -0,0 +1,170 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"github.com/rvolosatovs/turtlitto/pkg/refbox"
+	"go.uber.org/zap"
+)
+
+// Mode is the control mode, which determines who issues commands to TRC.
+type Mode string
+
+const (
+	// ModeManual means that commands are issued by the operators.
+	ModeManual Mode = "manual"
+
+	// ModeRefBox means that commands are issued by the referee box.
+	ModeRefBox Mode = "refbox"
+)
+
+// Validate implements api.Validator.
+func (m Mode) Validate() error {
+	switch m {
+	case ModeManual, ModeRefBox:
+	default:
+		return errors.Errorf("invalid Mode: %s", m)
+	}
+	return nil
+}
+
+var (
+	errRefBoxMode       = errors.New("commands are issued by the referee box")
+	errRefBoxNotEnabled = errors.New("no referee box configured")
+)
+
+// WithRefBox allows to specify the client of the referee box.
+// Commands received from the referee box are forwarded to TRC in ModeRefBox.
+func WithRefBox(c *refbox.Client) Option {
+	return func(srv *server) {
+		srv.refbox = c
+	}
+}
+
+// getMode returns the current control mode.
+func (srv *server) getMode() Mode {
+	srv.modeMu.RLock()
+	defer srv.modeMu.RUnlock()
+	return srv.mode
+}
+
+// setMode sets the control mode to m.
+// TRC is kept running as long as the mode is ModeRefBox.
+// A running macro is cancelled when switching to ModeRefBox.
+func (srv *server) setMode(m Mode) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	if m == ModeRefBox && srv.refbox == nil {
+		return errRefBoxNotEnabled
+	}
+
+	srv.modeMu.Lock()
+	old := srv.mode
+	srv.mode = m
+	srv.modeMu.Unlock()
+
+	if old == m {
+		return nil
+	}
+	if m == ModeRefBox {
+		// Running macros would interfere with the referee box
+		if err := srv.runner.Cancel(); err == nil {
+			zap.L().Info("Cancelled running macro")
+		}
+		srv.acquire()
+	} else {
+		srv.release()
+	}
+	srv.changes.notify()
+	return nil
+}
+
+// handleRefBoxCommand forwards cmd received from the referee box to TRC, if the mode is ModeRefBox.
+func (srv *server) handleRefBoxCommand(ctx context.Context, cmd api.Command) error {
+	if srv.getMode() != ModeRefBox {
+		logcontext.Logger(ctx).Debug("Manual mode, ignoring referee box command", zap.String("command", string(cmd)))
+		return nil
+	}
+
+	start := time.Now()
+
+	trcConn, err := srv.pool.Conn()
+	if err != nil {
+		err = errors.Wrap(err, "failed to establish connection to TRC")
+		srv.recordRefBoxCommand(start, cmd, err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, srv.getTimeouts().Write)
+	defer cancel()
+
+	err = trcConn.SetCommand(ctx, cmd)
+	srv.recordRefBoxCommand(start, cmd, err)
+	return err
+}
+
+// handleMode handles requests to ModeEndpoint.
+// GET returns the current mode and POST sets the mode in the request body.
+func (srv *server) handleMode(w http.ResponseWriter, r *http.Request) {
+	logger := logcontext.Logger(r.Context())
+
+	srv.sessionMu.RLock()
+	defer srv.sessionMu.RUnlock()
+
+	role := credentials.RoleOperator
+	if r.Method == http.MethodGet {
+		role = credentials.RoleViewer
+	}
+	sess := srv.checkSession(w, r, role)
+	if sess == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(srv.getMode()); err != nil {
+			logger.Error("Failed to write mode", zap.Error(err))
+		}
+
+	case http.MethodPost:
+		if !srv.checkControl(w, sess) {
+			return
+		}
+
+		var m Mode
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			http.Error(w, errors.Wrap(err, "failed to decode request body").Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("Setting mode", zap.String("mode", string(m)))
+		req, _ := json.Marshal(m)
+		e := &audit.Entry{
+			Time:     time.Now(),
+			Operator: sess.operator,
+			Session:  sess.id,
+			ClientIP: clientIP(r),
+			Action:   "mode",
+			Request:  req,
+		}
+		err := srv.setMode(m)
+		srv.record(e, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+	default:
+		http.Error(w, errors.Errorf("expected a GET or POST request, got %s", r.Method).Error(), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,56 @@
+package webapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: handleMode() in refbox.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMode(t *testing.T) {
+	a := assert.New(t)
+
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	mux, key := ts.mux, ts.key
+
+	// The referee box is not configured.
+	a.Equal(http.StatusBadRequest, do(mux, http.MethodPost, ModeEndpoint, key, `"refbox"`).Code)
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, ModeEndpoint, key, `"manual"`).Code)
+
+	rec := do(mux, http.MethodGet, ModeEndpoint, key, "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var m Mode
+		a.NoError(json.NewDecoder(rec.Body).Decode(&m))
+		a.Equal(ModeManual, m)
+	}
+
+	// Mode switches are recorded in the audit log.
+	rec = do(mux, http.MethodGet, AuditEndpoint, key, "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var entries []*audit.Entry
+		a.NoError(json.NewDecoder(rec.Body).Decode(&entries))
+
+		var modes []*audit.Entry
+		for _, e := range entries {
+			if e.Action == "mode" {
+				modes = append(modes, e)
+			}
+		}
+		if a.Len(modes, 2) {
+			a.Equal("trc", modes[0].Operator)
+			a.JSONEq(`"refbox"`, string(modes[0].Request))
+			a.NotEmpty(modes[0].Error)
+			a.JSONEq(`"manual"`, string(modes[1].Request))
+			a.Empty(modes[1].Error)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package webapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+)
+
+var errReloadUnsupported = errors.New("reloading is not supported")
+
+// WithReloadFunc allows to specify the function, which reloads the configuration of SRRS,
+// when requested on ReloadEndpoint.
+func WithReloadFunc(f func() error) Option {
+	return func(srv *server) {
+		srv.reload = f
+	}
+}
+
+// handleReload handles requests to ReloadEndpoint.
+func (srv *server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, errors.Errorf("expected a POST request, got %s", r.Method).Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.sessionMu.RLock()
+	sess := srv.checkSession(w, r, credentials.RoleAdmin)
+	srv.sessionMu.RUnlock()
+	if sess == nil {
+		return
+	}
+
+	if srv.reload == nil {
+		http.Error(w, errReloadUnsupported.Error(), http.StatusNotFound)
+		return
+	}
+
+	logcontext.Logger(r.Context()).Info("Reloading configuration...")
+
+	e := &audit.Entry{
+		Time:     time.Now(),
+		Operator: sess.operator,
+		Session:  sess.id,
+		ClientIP: clientIP(r),
+		Action:   "reload",
+	}
+	err := srv.reload()
+	srv.record(e, err)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "failed to reload configuration").Error(), http.StatusInternalServerError)
+		return
+	}
+}
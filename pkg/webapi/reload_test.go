@@ -0,0 +1,77 @@
+package webapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: handleReload() in reload.go, Server.Apply(), Server.RevokeOperator() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestReload(t *testing.T) {
+	a := assert.New(t)
+
+	operators, err := credentials.NewStore("")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(operators.Put("operator", "secret", credentials.RoleOperator))
+
+	const origin = "http://ui.example.com"
+
+	var ts *testServer
+	reloaded := 0
+	reloadErr := errors.New("invalid configuration")
+
+	ts = newTestServer(t,
+		WithOperators(operators),
+		WithReloadFunc(func() error {
+			if reloaded++; reloaded > 1 {
+				return reloadErr
+			}
+			ts.web.Apply(WithAllowedOrigins(origin))
+			if err := operators.Delete("operator"); err != nil {
+				return err
+			}
+			ts.web.RevokeOperator("operator")
+			return nil
+		}),
+	)
+	defer ts.Close()
+
+	mux, key := ts.mux, ts.key
+
+	originAllowed := func() bool {
+		req := httptest.NewRequest(http.MethodOptions, "/"+CommandEndpoint, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code == http.StatusNoContent
+	}
+	a.False(originAllowed())
+
+	op := authenticate(t, mux, "operator", "secret")
+	a.Equal(http.StatusForbidden, do(mux, http.MethodPost, ReloadEndpoint, op, "").Code)
+	a.Equal(0, reloaded)
+
+	a.Equal(http.StatusBadRequest, do(mux, http.MethodGet, ReloadEndpoint, key, "").Code)
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, ReloadEndpoint, key, "").Code)
+	a.Equal(1, reloaded)
+	a.True(originAllowed())
+
+	// Sessions of operators removed on reload are removed.
+	a.Equal(http.StatusUnauthorized, do(mux, http.MethodPost, ReloadEndpoint, op, "").Code)
+
+	rec := do(mux, http.MethodPost, ReloadEndpoint, key, "")
+	a.Equal(http.StatusInternalServerError, rec.Code)
+	a.Contains(rec.Body.String(), reloadErr.Error())
+	a.True(originAllowed())
+}
@@ -0,0 +1,154 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"go.uber.org/zap"
+)
+
+var errShuttingDown = errors.New("server is shutting down")
+
+// WithShutdownCommand allows to specify the command sent to TRC on shutdown, e.g. api.CommandStop.
+// By default, no command is sent.
+func WithShutdownCommand(cmd api.Command) Option {
+	return func(srv *server) {
+		srv.shutdownCommand = cmd
+	}
+}
+
+// begin registers an in-flight request.
+// begin reports false, if the web API is shut down and the request must be rejected.
+func (srv *server) begin() bool {
+	srv.shutdownMu.RLock()
+	defer srv.shutdownMu.RUnlock()
+
+	select {
+	case <-srv.shutdownCh:
+		return false
+	default:
+	}
+	srv.requests.Add(1)
+	return true
+}
+
+// sendShutdownCommand sends the shutdown command to TRC and records it in the audit log.
+// If TRC connects to SRRS and is not connected, there is nothing to shut down and no command is sent.
+func (srv *server) sendShutdownCommand(ctx context.Context) error {
+	start := time.Now()
+	req, _ := json.Marshal(srv.shutdownCommand)
+	e := &audit.Entry{
+		Time:     start,
+		Operator: auditOperatorSRRS,
+		Action:   "shutdown",
+		Request:  req,
+	}
+
+	trcConn, err := srv.pool.Conn()
+	if err == trcapi.ErrNotConnected {
+		return nil
+	}
+	if err != nil {
+		err = errors.Wrap(err, "failed to establish connection to TRC")
+		srv.record(e, err)
+		return err
+	}
+
+	err = trcConn.SetCommand(ctx, srv.shutdownCommand)
+	srv.record(e, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to send shutdown command to TRC")
+	}
+	return nil
+}
+
+// Shutdown gracefully shuts down the web API.
+// New requests are rejected, WebSocket connections are closed with a close reason
+// and the in-flight requests are waited for until ctx is done.
+// Afterwards, the shutdown command is sent to TRC, if configured.
+// The command is sent after the in-flight requests to ensure that it is the last one TRC receives.
+// If ctx is done before the in-flight requests complete, the command is still sent within the write timeout.
+// If several TRCs are managed, they are shut down concurrently and the first error is returned.
+// Shutdown does not close the pools.
+func (s *Server) Shutdown(ctx context.Context) error {
+	errCh := make(chan error, len(s.servers))
+	for _, srv := range s.servers {
+		go func(srv *server) {
+			errCh <- srv.shutdown(ctx)
+		}(srv)
+	}
+
+	var err error
+	for range s.servers {
+		if srvErr := <-errCh; srvErr != nil && err == nil {
+			err = srvErr
+		}
+	}
+	return err
+}
+
+// shutdown gracefully shuts down srv as described in Server.Shutdown.
+func (srv *server) shutdown(ctx context.Context) error {
+	logger := zap.L()
+	if srv.name != "" {
+		logger = logger.With(zap.String("trc", srv.name))
+	}
+
+	srv.shutdownMu.Lock()
+	select {
+	case <-srv.shutdownCh:
+		srv.shutdownMu.Unlock()
+		return errShuttingDown
+	default:
+		close(srv.shutdownCh)
+	}
+	srv.shutdownMu.Unlock()
+
+	srv.cancel()
+	if err := srv.runner.Cancel(); err == nil {
+		logger.Info("Cancelled running macro")
+	}
+
+	logger.Debug("Waiting for in-flight requests to complete...")
+	drainedCh := make(chan struct{})
+	go func() {
+		srv.requests.Wait()
+		close(drainedCh)
+	}()
+
+	var err error
+	select {
+	case <-drainedCh:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "failed to wait for in-flight requests")
+	}
+
+	srv.stopTimerMu.Lock()
+	srv.stopTimer.Stop()
+	srv.stopTimerMu.Unlock()
+
+	if srv.shutdownCommand == "" {
+		return err
+	}
+
+	cmdCtx := ctx
+	if err != nil {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(context.Background(), srv.getTimeouts().Write)
+		defer cancel()
+	}
+
+	logger.Info("Sending shutdown command to TRC...", zap.String("command", string(srv.shutdownCommand)))
+	if cmdErr := srv.sendShutdownCommand(cmdCtx); cmdErr != nil {
+		if err != nil {
+			logger.Warn("In-flight requests did not complete", zap.Error(err))
+		}
+		return cmdErr
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package webapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: Server.Shutdown() in shutdown.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestShutdown(t *testing.T) {
+	a := assert.New(t)
+
+	ts := newTestServer(t, WithShutdownCommand(api.CommandStop))
+	defer ts.Close()
+
+	mux, web, key, msgCh := ts.mux, ts.web, ts.key, ts.msgCh
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	wsConn := openState(t, srv, key)
+	defer wsConn.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		errCh <- web.Shutdown(ctx)
+	}()
+
+	a.NoError(wsConn.SetReadDeadline(time.Now().Add(timeout)))
+	_, _, err := wsConn.ReadMessage()
+	if a.IsType(&websocket.CloseError{}, err) {
+		a.Equal(websocket.CloseGoingAway, err.(*websocket.CloseError).Code)
+		a.Contains(err.(*websocket.CloseError).Text, "shutting down")
+	}
+
+	select {
+	case msg := <-msgCh:
+		var st api.State
+		a.NoError(json.Unmarshal(msg.Payload, &st))
+		a.Equal(api.CommandStop, st.Command)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for shutdown command")
+	}
+
+	select {
+	case err := <-errCh:
+		a.NoError(err)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for Shutdown to return")
+	}
+
+	a.Equal(http.StatusServiceUnavailable, do(mux, http.MethodGet, AuthEndpoint, testToken, "").Code)
+	a.Equal(http.StatusServiceUnavailable, do(mux, http.MethodPost, CommandEndpoint, key, `"start"`).Code)
+}
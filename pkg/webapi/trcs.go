@@ -0,0 +1,88 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+)
+
+var (
+	// TRCsEndpoint is the endpoint listing the names of the TRCs registered by RegisterTRCHandlers.
+	TRCsEndpoint = path.Join("api", "v1", "trcs")
+
+	// apiPrefix is the common prefix of the endpoints.
+	apiPrefix = path.Join("api", "v1") + "/"
+)
+
+// TRCEndpoint returns the endpoint ep of the TRC named name registered by RegisterTRCHandlers,
+// e.g. TRCEndpoint("field1", CommandEndpoint) returns `api/v1/trcs/field1/command`.
+func TRCEndpoint(name, ep string) string {
+	return path.Join(TRCsEndpoint, name, strings.TrimPrefix(ep, apiPrefix))
+}
+
+// TRC is a named TRC managed by the web API.
+type TRC struct {
+	// Name identifies the TRC in the endpoints. Name must be a valid path segment.
+	Name string
+
+	// Pool is the pool of connections to the TRC.
+	Pool *trcapi.Pool
+
+	// Options are applied to the web API of the TRC after the options common to all TRCs.
+	// Options, which must not be shared between TRCs, such as WithMatchTracker or WithRefBox, must be specified here.
+	Options []Option
+}
+
+// RegisterTRCHandlers registers the webapi endpoints of each of trcs on handler.
+// The endpoints of a TRC are registered under TRCEndpoint, e.g. the commands for the TRC named `field1`
+// are sent to `api/v1/trcs/field1/command`. Each TRC has its own sessions, control lock, match and state stream.
+// The names of the TRCs are listed on TRCsEndpoint.
+// opts are applied to the web API of each TRC.
+// The names of trcs must be unique and not empty.
+func RegisterTRCHandlers(trcs []TRC, handler HandleFuncer, opts ...Option) *Server {
+	if len(trcs) == 0 {
+		panic(errors.New("no TRCs specified"))
+	}
+
+	s := &Server{}
+	names := make([]string, 0, len(trcs))
+	for _, t := range trcs {
+		if t.Name == "" {
+			panic(errors.New("TRC name must not be empty"))
+		}
+
+		trcOpts := make([]Option, 0, len(opts)+len(t.Options))
+		trcOpts = append(trcOpts, opts...)
+		trcOpts = append(trcOpts, t.Options...)
+
+		srv := newServer(t.Name, t.Pool, trcOpts...)
+		name := t.Name
+		srv.register(handler, func(ep string) string {
+			return TRCEndpoint(name, ep)
+		})
+		s.servers = append(s.servers, srv)
+		names = append(names, t.Name)
+	}
+
+	// CORS settings are common to all TRCs.
+	cors := s.servers[0]
+	handler.HandleFunc("/"+TRCsEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		if !cors.handleCORS(w, r) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, errors.Errorf("expected a GET request, got %s", r.Method).Error(), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(names); err != nil {
+			http.Error(w, errors.Wrap(err, "failed to encode TRC names").Error(), http.StatusInternalServerError)
+		}
+	})
+	return s
+}
@@ -0,0 +1,87 @@
+package webapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+//Test_items: RegisterTRCHandlers(), TRCEndpoint() in trcs.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMultiTRC(t *testing.T) {
+	a := assert.New(t)
+
+	names := []string{"field1", "field2"}
+
+	testTRCs := make(map[string]*testTRC, len(names))
+	trcs := make([]TRC, 0, len(names))
+	for _, name := range names {
+		trc := newTestTRC()
+		defer trc.Close()
+
+		testTRCs[name] = trc
+		trcs = append(trcs, TRC{
+			Name: name,
+			Pool: trc.Pool,
+		})
+	}
+
+	mux := http.NewServeMux()
+	RegisterTRCHandlers(trcs, mux)
+
+	rec := do(mux, http.MethodGet, TRCsEndpoint, "", "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var listed []string
+		a.NoError(json.NewDecoder(rec.Body).Decode(&listed))
+		a.Equal(names, listed)
+	}
+	a.Equal(http.StatusMethodNotAllowed, do(mux, http.MethodPost, TRCsEndpoint, "", "").Code)
+	a.Equal(http.StatusNotFound, do(mux, http.MethodGet, AuthEndpoint, testToken, "").Code)
+
+	keys := make(map[string]string, len(names))
+	for _, name := range names {
+		keys[name] = testTRCs[name].authenticate(t, mux, TRCEndpoint(name, AuthEndpoint))
+	}
+
+	for i, name := range names {
+		cmd := api.CommandStart
+		if i > 0 {
+			cmd = api.CommandGoIn
+		}
+		b, err := json.Marshal(cmd)
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+		a.Equal(http.StatusOK, do(mux, http.MethodPost, TRCEndpoint(name, CommandEndpoint), keys[name], string(b)).Code)
+
+		select {
+		case msg := <-testTRCs[name].msgCh:
+			var st api.State
+			a.NoError(json.Unmarshal(msg.Payload, &st))
+			a.Equal(cmd, st.Command)
+		case <-time.After(timeout):
+			t.Fatalf("Timed out waiting for %s to receive the command", name)
+		}
+
+		for _, other := range names {
+			if other == name {
+				continue
+			}
+			select {
+			case msg := <-testTRCs[other].msgCh:
+				t.Errorf("%s received a message sent to %s: %s", other, name, msg.Payload)
+			default:
+			}
+		}
+	}
+
+	// Sessions are per TRC.
+	a.Equal(http.StatusUnauthorized, do(mux, http.MethodPost, TRCEndpoint(names[1], CommandEndpoint), keys[names[0]], `"stop"`).Code)
+}
@@ -2,37 +2,63 @@
 package webapi
 
 import (
+	"bytes"
 	"compress/flate"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/control"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
 	"github.com/rvolosatovs/turtlitto/pkg/logcontext"
+	"github.com/rvolosatovs/turtlitto/pkg/macro"
+	"github.com/rvolosatovs/turtlitto/pkg/match"
+	"github.com/rvolosatovs/turtlitto/pkg/ratelimit"
+	"github.com/rvolosatovs/turtlitto/pkg/refbox"
 	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
 	"go.uber.org/zap"
 )
 
-const (
-	// pingInterval is pingInterval.
-	pingInterval = 5 * time.Second
+// Timeouts are the timeouts and intervals used by the web API.
+type Timeouts struct {
+	// Ping is the interval, at which pings are sent on WebSockets.
+	Ping time.Duration
 
-	// writeTimeout is writeTimeout.
-	writeTimeout = 3 * time.Second
+	// Write is the timeout of WebSocket writes.
+	Write time.Duration
 
-	// readTimeout is readTimeout.
-	readTimeout = 3 * time.Second
+	// Read is the timeout of WebSocket reads.
+	Read time.Duration
 
-	// inactivityTimeout is inactivityTimeout.
-	inactivityTimeout = 5 * time.Second
-)
+	// Inactivity is the time after which TRC is stopped, if no client is active.
+	Inactivity time.Duration
+
+	// Session is the time after which a session without an open WebSocket expires, unless it is used by a request.
+	Session time.Duration
+}
+
+// sessionCheckInterval is the interval, at which expired sessions are removed.
+const sessionCheckInterval = time.Second
+
+// DefaultTimeouts are the default Timeouts.
+var DefaultTimeouts = Timeouts{
+	Ping:       5 * time.Second,
+	Write:      3 * time.Second,
+	Read:       3 * time.Second,
+	Inactivity: 5 * time.Second,
+	Session:    5 * time.Minute,
+}
 
 var (
 	// StateEndpoint is the state endpoint.
@@ -47,12 +73,64 @@ var (
 	// CommandEndpoint is the command endpoint.
 	CommandEndpoint = path.Join("api", "v1", "command")
 
+	// MacrosEndpoint is the macro management endpoint.
+	MacrosEndpoint = path.Join("api", "v1", "macros")
+
+	// MacroRunEndpoint is the macro execution endpoint.
+	MacroRunEndpoint = path.Join("api", "v1", "macros", "run")
+
+	// MacroCancelEndpoint is the macro cancellation endpoint.
+	MacroCancelEndpoint = path.Join("api", "v1", "macros", "cancel")
+
+	// MatchEndpoint is the match state endpoint.
+	MatchEndpoint = path.Join("api", "v1", "match")
+
+	// MatchResetEndpoint is the match reset endpoint.
+	MatchResetEndpoint = path.Join("api", "v1", "match", "reset")
+
+	// ModeEndpoint is the control mode endpoint.
+	ModeEndpoint = path.Join("api", "v1", "mode")
+
+	// AuditEndpoint is the audit log endpoint.
+	AuditEndpoint = path.Join("api", "v1", "audit")
+
+	// OperatorsEndpoint is the operator management endpoint.
+	OperatorsEndpoint = path.Join("api", "v1", "operators")
+
+	// ControlEndpoint is the control lock state endpoint.
+	ControlEndpoint = path.Join("api", "v1", "control")
+
+	// ControlRequestEndpoint is the endpoint used to request control.
+	ControlRequestEndpoint = path.Join("api", "v1", "control", "request")
+
+	// ControlAcceptEndpoint is the endpoint used by the controller to accept a pending request.
+	ControlAcceptEndpoint = path.Join("api", "v1", "control", "accept")
+
+	// ControlDenyEndpoint is the endpoint used by the controller to deny a pending request.
+	ControlDenyEndpoint = path.Join("api", "v1", "control", "deny")
+
+	// ControlReleaseEndpoint is the endpoint used by the controller to release control.
+	ControlReleaseEndpoint = path.Join("api", "v1", "control", "release")
+
+	// ReloadEndpoint is the endpoint used to reload the configuration of SRRS.
+	ReloadEndpoint = path.Join("api", "v1", "reload")
+
+	// ErrAuthenticateFirst represents an error, which occurs when a request is made before any session exists.
+	// WebSockets on StateEndpoint are closed with the text of ErrAuthenticateFirst in this case.
+	ErrAuthenticateFirst = errors.New("authenticate first")
+
+	// ErrInvalidSessionKey represents an error, which occurs when a request carries an unknown session key.
+	// WebSockets on StateEndpoint are closed with the text of ErrInvalidSessionKey in this case.
+	ErrInvalidSessionKey = errors.New("invalid session key")
+
 	errActiveWebSocket     = errors.New("an active WebSocket connection already exists")
-	errAuthenticateFirst   = errors.New("authenticate first")
+	errSessionRemoved      = errors.New("session removed")
 	errAuthorizationHeader = errors.New("`Authorization` header not found or invalid")
-	errInvalidSessionKey   = errors.New("invalid session key")
 	errInvalidToken        = errors.New("invalid token")
 	errFailedToGetToken    = errors.New("TRC connection established, but failed to get token")
+	errTooManyRequests     = errors.New("too many requests")
+	errDuplicateRequest    = errors.New("identical consecutive request ignored")
+	errTooManyAttempts     = errors.New("too many failed authentication attempts")
 )
 
 // controlWriter can write Control messages to itself.
@@ -62,32 +140,271 @@ type controlWriter interface {
 
 // wsError closes websocket represented by w with and error message err and code code.
 // wsError logs to logger.
-func wsError(w controlWriter, logger *zap.Logger, err error, code int) {
+func (srv *server) wsError(w controlWriter, logger *zap.Logger, err error, code int) {
 	logger = logger.With(
 		zap.Error(err),
 		zap.Int("code", code),
 	)
 
 	logger.Error("Closing WebSocket...")
-	if err := w.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, err.Error()), time.Now().Add(writeTimeout)); err != nil {
+	if err := w.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, err.Error()), time.Now().Add(srv.getTimeouts().Write)); err != nil {
 		logger.Warn("Failed to gracefully close WebSocket")
 	}
 }
 
 type session struct {
+	// lastSeen is the time in Unix nanoseconds the session was last used, i.e. the time it was created,
+	// it was used to authenticate a request or its WebSocket was closed.
+	// lastSeen is accessed atomically, since requests only read-lock the sessions.
+	lastSeen int64
+
 	isActive bool
 	key      string
+
+	// id identifies the session in the audit log.
+	id string
+
+	// operator is the name of the operator, who created the session.
+	operator string
+
+	// role is the role of the operator, who created the session.
+	role credentials.Role
+
+	// done is closed when the session is removed.
+	done chan struct{}
 }
 
-// server manages the web API.
+// State is the state sent on StateEndpoint.
+type State struct {
+	*api.State
+
+	// Seq is the sequence number of the state of TRC. Web clients should ignore States
+	// with sequence numbers less than the one of the last State received.
+	Seq uint64 `json:"seq,omitempty"`
+
+	// Macro is the progress of the last macro execution, if any.
+	Macro *macro.Progress `json:"macro,omitempty"`
+
+	// Match is the state of the match.
+	Match *match.State `json:"match,omitempty"`
+
+	// Mode is the control mode.
+	Mode Mode `json:"mode,omitempty"`
+
+	// Control is the state of the control lock.
+	Control *control.State `json:"control,omitempty"`
+
+	// Session is the ID of the session, to which the State is sent.
+	Session string `json:"session,omitempty"`
+}
+
+// server manages the web API of a single TRC.
 type server struct {
+	// name is the name of the TRC, if several TRCs are managed.
+	name string
+
 	pool *trcapi.Pool
 
+	macros *macro.Store
+	runner *macro.Runner
+
+	match *match.Tracker
+
+	control *control.Lock
+
+	refbox *refbox.Client
+
+	operators    *credentials.Store
+	trcTokenAuth bool
+
+	audit *audit.Log
+
+	// limiter limits the rate of requests sent to TRC by session ID.
+	limiter *ratelimit.Limiter
+	// debouncer suppresses identical consecutive requests by session ID.
+	debouncer *ratelimit.Debouncer
+	// lockout locks client IPs out after failed authentication attempts.
+	lockout *ratelimit.Lockout
+
+	settingsMu sync.RWMutex
+	// allowedOrigins are the origins, from which cross-origin requests are accepted.
+	allowedOrigins map[string]struct{}
+	timeouts       Timeouts
+
+	// reload reloads the configuration of SRRS.
+	reload func() error
+
+	// shutdownCommand is the command sent to TRC on shutdown, if any.
+	shutdownCommand api.Command
+
+	shutdownMu sync.RWMutex
+	// shutdownCh is closed when the web API is shut down.
+	shutdownCh chan struct{}
+	// requests are the in-flight requests.
+	requests sync.WaitGroup
+
+	// cancel stops the background activities of the web API.
+	cancel context.CancelFunc
+
+	modeMu sync.RWMutex
+	mode   Mode
+
+	// changes notifies about changes of the state of the server sent on StateEndpoint.
+	changes notifier
+
 	sessionMu sync.RWMutex
-	session   *session
+	// sessions are the sessions by key.
+	sessions map[string]*session
 
 	stopTimerMu sync.Mutex
 	stopTimer   *time.Timer
+	activeConns int
+}
+
+// Option represents a web API option.
+type Option func(*server)
+
+// WithTimeouts allows to specify the timeouts and intervals used by the web API.
+// By default, DefaultTimeouts are used.
+// WithTimeouts can be applied at runtime using Server.Apply.
+func WithTimeouts(t Timeouts) Option {
+	return func(srv *server) {
+		srv.settingsMu.Lock()
+		srv.timeouts = t
+		srv.settingsMu.Unlock()
+	}
+}
+
+// getTimeouts returns the current timeouts.
+func (srv *server) getTimeouts() Timeouts {
+	srv.settingsMu.RLock()
+	defer srv.settingsMu.RUnlock()
+	return srv.timeouts
+}
+
+// WithMacroStore allows to specify the store of macros.
+// By default, macros are only stored in memory.
+func WithMacroStore(st *macro.Store) Option {
+	return func(srv *server) {
+		srv.macros = st
+	}
+}
+
+// state returns the State sent on StateEndpoint of sess given st.
+func (srv *server) state(st *api.State, seq uint64, sess *session) *State {
+	return &State{
+		State:   st,
+		Seq:     seq,
+		Macro:   srv.runner.Progress(),
+		Match:   srv.match.State(),
+		Mode:    srv.getMode(),
+		Control: srv.control.State(),
+		Session: sess.id,
+	}
+}
+
+// writeState writes the State of sess given the state of trcConn on wsConn.
+func (srv *server) writeState(ctx context.Context, wsConn *websocket.Conn, trcConn *trcapi.Conn, sess *session) error {
+	st, seq := trcConn.SequencedState(ctx)
+	if err := wsConn.SetWriteDeadline(time.Now().Add(srv.getTimeouts().Write)); err != nil {
+		return errors.Wrap(err, "failed to set write deadline")
+	}
+	if err := wsConn.WriteJSON(srv.state(st, seq, sess)); err != nil {
+		return errors.Wrap(err, "failed to write state")
+	}
+	return nil
+}
+
+// acquire marks the beginning of an activity, which keeps TRC running.
+func (srv *server) acquire() {
+	srv.stopTimerMu.Lock()
+	srv.activeConns++
+	srv.stopTimer.Stop()
+	srv.stopTimerMu.Unlock()
+}
+
+// release marks the end of an activity started by acquire.
+// If there are no more activities, TRC is stopped after the inactivity timeout.
+func (srv *server) release() {
+	srv.stopTimerMu.Lock()
+	srv.activeConns--
+	if srv.activeConns == 0 {
+		srv.stopTimer.Reset(srv.getTimeouts().Inactivity)
+	}
+	srv.stopTimerMu.Unlock()
+}
+
+// stopInactive stops TRC, once there are no more activities, and records the command in the audit log.
+func (srv *server) stopInactive() {
+	req, _ := json.Marshal(api.CommandStop)
+	e := &audit.Entry{
+		Time:     time.Now(),
+		Operator: auditOperatorSRRS,
+		Action:   "inactivity_stop",
+		Request:  req,
+	}
+
+	trcConn, err := srv.pool.Conn()
+	if err != nil {
+		zap.L().Error("Failed to establish connection to TRC", zap.Error(err))
+		srv.record(e, errors.Wrap(err, "failed to establish connection to TRC"))
+		return
+	}
+	defer trcConn.Close()
+
+	err = trcConn.SetCommand(context.Background(), api.CommandStop)
+	srv.record(e, err)
+	if err != nil {
+		zap.L().Error("Failed to stop TRC", zap.Error(err))
+	}
+}
+
+// touch marks sess as used at t.
+func (sess *session) touch(t time.Time) {
+	atomic.StoreInt64(&sess.lastSeen, t.UnixNano())
+}
+
+// idle returns the time elapsed since sess was last used.
+func (sess *session) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&sess.lastSeen)))
+}
+
+// checkSession returns the session, which key r carries, if the role of the session
+// allows actions, which require role. The session is marked as used.
+// Otherwise, checkSession writes an error to w and returns nil.
+// checkSession must be called with srv.sessionMu held.
+func (srv *server) checkSession(w http.ResponseWriter, r *http.Request, role credentials.Role) *session {
+	_, key, ok := r.BasicAuth()
+	sess := srv.sessions[key]
+	switch {
+	case len(srv.sessions) == 0:
+		http.Error(w, ErrAuthenticateFirst.Error(), http.StatusMethodNotAllowed)
+		return nil
+
+	case !ok:
+		http.Error(w, errAuthorizationHeader.Error(), http.StatusBadRequest)
+		return nil
+
+	case sess == nil:
+		http.Error(w, ErrInvalidSessionKey.Error(), http.StatusUnauthorized)
+		return nil
+
+	case !sess.role.Allows(role):
+		http.Error(w, errors.Errorf("role %s is required", role).Error(), http.StatusForbidden)
+		return nil
+	}
+	sess.touch(time.Now())
+	return sess
+}
+
+// removeSession removes sess, which closes its WebSocket, and releases the control held by it, if any.
+// removeSession must be called with srv.sessionMu held.
+func (srv *server) removeSession(sess *session) {
+	delete(srv.sessions, sess.key)
+	close(sess.done)
+	srv.control.Remove(sess.id)
+	srv.limiter.Remove(sess.id)
+	srv.debouncer.Remove(sess.id)
 }
 
 // handleState handles requests to StateEndpoint.
@@ -96,11 +413,10 @@ func (srv *server) handleState(w http.ResponseWriter, r *http.Request) {
 	logger := logcontext.Logger(ctx)
 
 	wsConn, err := (&websocket.Upgrader{
-		HandshakeTimeout:  readTimeout,
+		HandshakeTimeout:  srv.getTimeouts().Read,
 		EnableCompression: true,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		}}).Upgrade(w, r, nil)
+		CheckOrigin:       srv.checkOrigin,
+	}).Upgrade(w, r, nil)
 	if err != nil {
 		logger.Error("Failed to open WebSocket")
 		return
@@ -109,83 +425,105 @@ func (srv *server) handleState(w http.ResponseWriter, r *http.Request) {
 
 	wsConn.EnableWriteCompression(true)
 	if err := wsConn.SetCompressionLevel(flate.BestCompression); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to enable compression"), websocket.CloseProtocolError)
+		srv.wsError(wsConn, logger, errors.Wrap(err, "failed to enable compression"), websocket.CloseProtocolError)
 		return
 	}
 
 	var key string
 	logger.Debug("Reading key...")
 
-	if err := wsConn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to set read deadline"), websocket.CloseInternalServerErr)
+	if err := wsConn.SetReadDeadline(time.Now().Add(srv.getTimeouts().Read)); err != nil {
+		srv.wsError(wsConn, logger, errors.Wrap(err, "failed to set read deadline"), websocket.CloseInternalServerErr)
 		return
 	}
 
 	if err := wsConn.ReadJSON(&key); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to read session key"), websocket.CloseInvalidFramePayloadData)
+		srv.wsError(wsConn, logger, errors.Wrap(err, "failed to read session key"), websocket.CloseInvalidFramePayloadData)
 		return
 	}
 
 	srv.sessionMu.Lock()
+	sess := srv.sessions[key]
 	switch {
-	case srv.session == nil:
+	case len(srv.sessions) == 0:
 		srv.sessionMu.Unlock()
-		wsError(wsConn, logger, errAuthenticateFirst, websocket.ClosePolicyViolation)
+		srv.wsError(wsConn, logger, ErrAuthenticateFirst, websocket.ClosePolicyViolation)
 		return
 
-	case key != srv.session.key:
+	case sess == nil:
 		srv.sessionMu.Unlock()
-		wsError(wsConn, logger, errInvalidSessionKey, websocket.CloseInvalidFramePayloadData)
+		srv.wsError(wsConn, logger, ErrInvalidSessionKey, websocket.CloseInvalidFramePayloadData)
 		return
 
-	case srv.session.isActive:
+	case sess.isActive:
 		srv.sessionMu.Unlock()
-		wsError(wsConn, logger, errActiveWebSocket, websocket.ClosePolicyViolation)
+		srv.wsError(wsConn, logger, errActiveWebSocket, websocket.ClosePolicyViolation)
 		return
 	}
 
-	srv.session.isActive = true
+	sess.isActive = true
 	srv.sessionMu.Unlock()
 
 	defer func() {
 		srv.sessionMu.Lock()
-		srv.session.isActive = false
+		sess.isActive = false
+		sess.touch(time.Now())
 		srv.sessionMu.Unlock()
 	}()
 
 	logger.Debug("Retrieving a connection from pool...")
 	trcConn, err := srv.pool.Conn()
 	if err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to establish connection to TRC"), websocket.CloseInternalServerErr)
+		srv.wsError(wsConn, logger, errors.Wrap(err, "failed to establish connection to TRC"), websocket.CloseInternalServerErr)
 		return
 	}
 
 	logger.Debug("Subscribing to state changes...")
 	changeCh, closeFn, err := trcConn.SubscribeStateChanges(ctx)
 	if err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to subscribe to state changes"), websocket.CloseInternalServerErr)
+		srv.wsError(wsConn, logger, errors.Wrap(err, "failed to subscribe to state changes"), websocket.CloseInternalServerErr)
 		return
 	}
 	defer closeFn()
 
-	oldState := trcConn.State(ctx)
+	logger.Debug("Subscribing to TRC errors...")
+	trcErrCh, closeTRCErrFn, err := trcConn.SubscribeErrors(ctx)
+	if err != nil {
+		srv.wsError(wsConn, logger, errors.Wrap(err, "failed to subscribe to TRC errors"), websocket.CloseInternalServerErr)
+		return
+	}
+	defer closeTRCErrFn()
+
+	progressCh, closeProgressFn := srv.runner.SubscribeProgress()
+	defer closeProgressFn()
+
+	matchCh, closeMatchFn := srv.match.Subscribe()
+	defer closeMatchFn()
+
+	srvCh, closeSrvFn := srv.changes.subscribe()
+	defer closeSrvFn()
 
-	if err := wsConn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to set write deadline"), websocket.CloseInternalServerErr)
+	controlCh, closeControlFn := srv.control.Subscribe()
+	defer closeControlFn()
+
+	oldState, seq := trcConn.SequencedState(ctx)
+
+	if err := wsConn.SetWriteDeadline(time.Now().Add(srv.getTimeouts().Write)); err != nil {
+		srv.wsError(wsConn, logger, errors.Wrap(err, "failed to set write deadline"), websocket.CloseInternalServerErr)
 		return
 	}
 
 	logger.Debug("Sending current state on the WebSocket...", zap.Reflect("state", oldState))
-	if err := wsConn.WriteJSON(oldState); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to write state"), websocket.CloseInternalServerErr)
+	if err := wsConn.WriteJSON(srv.state(oldState, seq, sess)); err != nil {
+		srv.wsError(wsConn, logger, errors.Wrap(err, "failed to write state"), websocket.CloseInternalServerErr)
 		return
 	}
 
-	if err := wsConn.SetReadDeadline(time.Now().Add(pingInterval + writeTimeout + readTimeout)); err != nil {
-		wsError(wsConn, logger, errors.Wrap(err, "failed to set read deadline"), websocket.CloseInternalServerErr)
+	if err := wsConn.SetReadDeadline(time.Now().Add(srv.getTimeouts().Ping + srv.getTimeouts().Write + srv.getTimeouts().Read)); err != nil {
+		srv.wsError(wsConn, logger, errors.Wrap(err, "failed to set read deadline"), websocket.CloseInternalServerErr)
 	}
 	wsConn.SetPongHandler(func(string) error {
-		return wsConn.SetReadDeadline(time.Now().Add(pingInterval + writeTimeout + readTimeout))
+		return wsConn.SetReadDeadline(time.Now().Add(srv.getTimeouts().Ping + srv.getTimeouts().Write + srv.getTimeouts().Read))
 	})
 
 	errCh := make(chan error, 1)
@@ -201,26 +539,43 @@ func (srv *server) handleState(w http.ResponseWriter, r *http.Request) {
 	for {
 		select {
 		case <-ctx.Done():
-			wsError(wsConn, logger, errors.New("context done"), websocket.CloseInvalidFramePayloadData)
+			srv.wsError(wsConn, logger, errors.New("context done"), websocket.CloseInvalidFramePayloadData)
 			return
 
 		case <-trcConn.Closed():
-			wsError(wsConn, logger, errors.New("TRC connection is closed"), websocket.CloseInternalServerErr)
+			srv.wsError(wsConn, logger, errors.New("TRC connection is closed"), websocket.CloseInternalServerErr)
+			return
+
+		case <-srv.shutdownCh:
+			srv.wsError(wsConn, logger, errShuttingDown, websocket.CloseGoingAway)
+			return
+
+		case <-sess.done:
+			srv.wsError(wsConn, logger, errSessionRemoved, websocket.ClosePolicyViolation)
 			return
 
-		case <-trcConn.Errors():
-			defer trcConn.Close()
-			wsError(wsConn, logger, errors.New("communication with TRC failed"), websocket.CloseInternalServerErr)
+		case err, ok := <-trcErrCh:
+			if !ok {
+				srv.wsError(wsConn, logger, errors.New("TRC connection is closed"), websocket.CloseInternalServerErr)
+				return
+			}
+			switch err.(type) {
+			case *trcapi.DecodeError, *trcapi.ProtocolError:
+				// The state is resynchronized by trcConn, which is shared with other sessions.
+				logger.Debug("Non-fatal TRC error occurred", zap.Error(err))
+				continue
+			}
+			srv.wsError(wsConn, logger, errors.Wrap(err, "communication with TRC failed"), websocket.CloseInternalServerErr)
 			return
 
 		case err := <-errCh:
-			wsError(wsConn, logger, errors.Wrap(err, "communication via WebSocket failed"), websocket.CloseAbnormalClosure)
+			srv.wsError(wsConn, logger, errors.Wrap(err, "communication via WebSocket failed"), websocket.CloseAbnormalClosure)
 			return
 
 		case <-changeCh:
 			logger.Debug("State change acknowledged")
 
-			st := trcConn.State(ctx)
+			st, seq := trcConn.SequencedState(ctx)
 			// TODO: Compute diff of st and oldState
 			_ = oldState
 
@@ -230,25 +585,53 @@ func (srv *server) handleState(w http.ResponseWriter, r *http.Request) {
 			}
 			oldState = st
 
-			if err := wsConn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
-				wsError(wsConn, logger, errors.Wrap(err, "failed to set write deadline"), websocket.CloseInternalServerErr)
+			if err := wsConn.SetWriteDeadline(time.Now().Add(srv.getTimeouts().Write)); err != nil {
+				srv.wsError(wsConn, logger, errors.Wrap(err, "failed to set write deadline"), websocket.CloseInternalServerErr)
 				return
 			}
 
 			logger.Debug("Sending state diff on the WebSocket...", zap.Reflect("state", diff))
-			if err := wsConn.WriteJSON(diff); err != nil {
-				wsError(wsConn, logger, errors.Wrap(err, "failed to write state"), websocket.CloseInternalServerErr)
+			if err := wsConn.WriteJSON(srv.state(diff, seq, sess)); err != nil {
+				srv.wsError(wsConn, logger, errors.Wrap(err, "failed to write state"), websocket.CloseInternalServerErr)
+				return
+			}
+
+		case <-progressCh:
+			logger.Debug("Macro progress change acknowledged")
+			if err := srv.writeState(ctx, wsConn, trcConn, sess); err != nil {
+				srv.wsError(wsConn, logger, err, websocket.CloseInternalServerErr)
 				return
 			}
 
-		case <-time.After(pingInterval):
-			if err := wsConn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
-				wsError(wsConn, logger, errors.Wrap(err, "failed to set write deadline"), websocket.CloseInternalServerErr)
+		case <-matchCh:
+			logger.Debug("Match state change acknowledged")
+			if err := srv.writeState(ctx, wsConn, trcConn, sess); err != nil {
+				srv.wsError(wsConn, logger, err, websocket.CloseInternalServerErr)
+				return
+			}
+
+		case <-srvCh:
+			logger.Debug("Server state change acknowledged")
+			if err := srv.writeState(ctx, wsConn, trcConn, sess); err != nil {
+				srv.wsError(wsConn, logger, err, websocket.CloseInternalServerErr)
+				return
+			}
+
+		case <-controlCh:
+			logger.Debug("Control state change acknowledged")
+			if err := srv.writeState(ctx, wsConn, trcConn, sess); err != nil {
+				srv.wsError(wsConn, logger, err, websocket.CloseInternalServerErr)
+				return
+			}
+
+		case <-time.After(srv.getTimeouts().Ping):
+			if err := wsConn.SetWriteDeadline(time.Now().Add(srv.getTimeouts().Write)); err != nil {
+				srv.wsError(wsConn, logger, errors.Wrap(err, "failed to set write deadline"), websocket.CloseInternalServerErr)
 				return
 			}
 
 			if err := wsConn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				wsError(wsConn, logger, errors.Wrap(err, "failed to write ping"), websocket.CloseInternalServerErr)
+				srv.wsError(wsConn, logger, errors.Wrap(err, "failed to write ping"), websocket.CloseInternalServerErr)
 				return
 			}
 		}
@@ -267,8 +650,10 @@ func (srv *server) handleAuth(w http.ResponseWriter, r *http.Request) {
 	srv.sessionMu.Lock()
 	defer srv.sessionMu.Unlock()
 
-	if srv.session != nil && srv.session.isActive {
-		http.Error(w, errActiveWebSocket.Error(), http.StatusTeapot)
+	ip := clientIP(r)
+	if locked, d := srv.lockout.Locked(ip); locked {
+		logger.Warn("Rejecting authentication attempt of locked out client", zap.String("ip", ip))
+		tooManyRequests(w, errTooManyAttempts, d)
 		return
 	}
 
@@ -279,23 +664,16 @@ func (srv *server) handleAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.Debug("Retrieving token...")
-	trcTok, err := trcConn.Token()
+	logger.Debug("Authenticating...")
+	operator, role, code, err := srv.authenticate(r, trcConn)
 	if err != nil {
-		http.Error(w, errors.Wrap(err, errFailedToGetToken.Error()).Error(), http.StatusInternalServerError)
-		return
-	}
-
-	_, authTok, ok := r.BasicAuth()
-	if !ok && trcTok != "" {
-		http.Error(w, errAuthorizationHeader.Error(), http.StatusBadRequest)
-		return
-	}
-
-	if trcTok != "" && authTok != trcTok {
-		http.Error(w, errInvalidToken.Error(), http.StatusUnauthorized)
+		if code == http.StatusUnauthorized && srv.lockout.Fail(ip) {
+			logger.Warn("Locking out client after failed authentication attempts", zap.String("ip", ip))
+		}
+		http.Error(w, err.Error(), code)
 		return
 	}
+	srv.lockout.Reset(ip)
 
 	logger.Debug("Generating new session key...")
 	b := make([]byte, 64)
@@ -306,19 +684,66 @@ func (srv *server) handleAuth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	key := hex.EncodeToString(b)
+
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "failed to generate session ID").Error(), http.StatusInternalServerError)
+		return
+	}
+
 	_, err = w.Write([]byte(key))
 	if err != nil {
 		http.Error(w, errors.Wrap(err, "failed to write session key").Error(), http.StatusInternalServerError)
 		return
 	}
 
-	logger.Debug("Creating new session")
-	srv.session = &session{
-		key: key,
+	logger.Debug("Creating new session",
+		zap.String("operator", operator),
+		zap.String("role", string(role)),
+	)
+	srv.sessions[key] = &session{
+		lastSeen: time.Now().UnixNano(),
+		key:      key,
+		id:       hex.EncodeToString(id),
+		operator: operator,
+		role:     role,
+		done:     make(chan struct{}),
+	}
+}
+
+// expireSessions removes the sessions without an open WebSocket, which were not used within the session timeout.
+// The control held by expired sessions is released.
+func (srv *server) expireSessions() {
+	srv.sessionMu.Lock()
+	defer srv.sessionMu.Unlock()
+
+	for _, sess := range srv.sessions {
+		if !sess.isActive && sess.idle() > srv.getTimeouts().Session {
+			zap.L().Debug("Removing expired session", zap.String("session", sess.id))
+			srv.removeSession(sess)
+		}
 	}
 }
 
-func (srv *server) makeTRCSendHandler(f func(context.Context, *trcapi.Conn, *json.Decoder) error) http.HandlerFunc {
+// watchSessions calls expireSessions every sessionCheckInterval until ctx is done.
+func (srv *server) watchSessions(ctx context.Context) {
+	ticker := time.NewTicker(sessionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			srv.expireSessions()
+		}
+	}
+}
+
+// makeTRCSendHandler returns a handler, which calls f with the session, which sent the request, and the request body.
+// Every request is recorded in the audit log as action.
+func (srv *server) makeTRCSendHandler(action string, f func(context.Context, *session, *trcapi.Conn, *json.Decoder) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := logcontext.Logger(ctx)
@@ -329,22 +754,19 @@ func (srv *server) makeTRCSendHandler(f func(context.Context, *trcapi.Conn, *jso
 			return
 		}
 
+		// The sessions are not locked during the round trip to TRC. sess stays usable after it is removed,
+		// since its ID, operator and role never change.
 		srv.sessionMu.RLock()
-		defer srv.sessionMu.RUnlock()
-
-		_, key, ok := r.BasicAuth()
-		if !ok && srv.session.key != "" {
-			http.Error(w, errAuthorizationHeader.Error(), http.StatusBadRequest)
+		sess := srv.checkSession(w, r, credentials.RoleOperator)
+		ok := sess != nil && srv.checkControl(w, sess)
+		srv.sessionMu.RUnlock()
+		if !ok {
 			return
 		}
 
-		switch {
-		case srv.session == nil:
-			http.Error(w, errAuthenticateFirst.Error(), http.StatusMethodNotAllowed)
-			return
-
-		case key != srv.session.key:
-			http.Error(w, errInvalidSessionKey.Error(), http.StatusUnauthorized)
+		if ok, d := srv.limiter.Allow(sess.id); !ok {
+			logger.Warn("Rate limit exceeded", zap.String("session", sess.id))
+			tooManyRequests(w, errTooManyRequests, d)
 			return
 		}
 
@@ -355,10 +777,38 @@ func (srv *server) makeTRCSendHandler(f func(context.Context, *trcapi.Conn, *jso
 			return
 		}
 
-		dec := json.NewDecoder(r.Body)
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, errors.Wrap(err, "failed to read request body").Error(), http.StatusBadRequest)
+			return
+		}
+
+		e := &audit.Entry{
+			Time:     time.Now(),
+			Operator: sess.operator,
+			Session:  sess.id,
+			ClientIP: clientIP(r),
+			Action:   action,
+		}
+		if json.Valid(b) {
+			e.Request = b
+		}
+
+		if !srv.debouncer.Allow(sess.id, action+" "+string(b)) {
+			logger.Debug("Ignoring identical consecutive request", zap.String("session", sess.id))
+			srv.record(e, errDuplicateRequest)
+			http.Error(w, errDuplicateRequest.Error(), http.StatusConflict)
+			return
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(b))
 		dec.DisallowUnknownFields()
 
-		if err := f(ctx, trcConn, dec); err != nil {
+		err = f(ctx, sess, trcConn, dec)
+		srv.record(e, err)
+		if err != nil {
+			// Allow the client to retry immediately.
+			srv.debouncer.Remove(sess.id)
 			http.Error(w, errors.Wrap(err, "failed to process request").Error(), http.StatusBadRequest)
 			return
 		}
@@ -371,34 +821,95 @@ type HandleFuncer interface {
 	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
 }
 
-// Register endpoints registers webapi endpoints on handler.
-func RegisterHandlers(pool *trcapi.Pool, handler HandleFuncer) {
-	var stopTimerMu sync.Mutex
-	stopTimer := time.AfterFunc(420 /* blaze it */, func() {
-		trcConn, err := pool.Conn()
-		if err != nil {
-			zap.L().Error("Failed to establish connection to TRC", zap.Error(err))
-			return
-		}
-		defer trcConn.Close()
+// Server is the web API registered by RegisterHandlers or RegisterTRCHandlers.
+type Server struct {
+	servers []*server
+}
 
-		if err := trcConn.SetCommand(context.Background(), api.CommandStop); err != nil {
-			zap.L().Error("Failed to stop TRC", zap.Error(err))
+// Apply applies opts to the running web API.
+// Only options documented as applicable at runtime may be specified.
+// Sessions and WebSocket connections are preserved.
+func (s *Server) Apply(opts ...Option) {
+	for _, srv := range s.servers {
+		for _, opt := range opts {
+			opt(srv)
 		}
-	})
-	stopTimer.Stop()
+	}
+}
 
-	activeConns := 0
+// RevokeOperator removes the sessions of the operator called name on all TRCs.
+// Their WebSockets are closed and the control held by them is released.
+// Operators revoked on OperatorsEndpoint are handled by the web API itself,
+// RevokeOperator is meant for operators removed from the operator store otherwise.
+func (s *Server) RevokeOperator(name string) {
+	for _, srv := range s.servers {
+		srv.removeOperatorSessions(zap.L(), name)
+	}
+}
+
+// RegisterHandlers registers webapi endpoints on handler.
+// The returned *Server can be used to change the settings of the web API at runtime.
+func RegisterHandlers(pool *trcapi.Pool, handler HandleFuncer, opts ...Option) *Server {
+	s := newServer("", pool, opts...)
+	s.register(handler, func(ep string) string {
+		return ep
+	})
+	return &Server{servers: []*server{s}}
+}
 
+// newServer returns a new *server managing the TRC named name, which is connected to via pool.
+func newServer(name string, pool *trcapi.Pool, opts ...Option) *server {
 	s := &server{
-		pool: pool,
+		name:         name,
+		pool:         pool,
+		runner:       macro.NewRunner(),
+		mode:         ModeManual,
+		trcTokenAuth: true,
+		sessions:     make(map[string]*session),
+		timeouts:     DefaultTimeouts,
+		limiter:      ratelimit.NewLimiter(DefaultRequestRate, DefaultRequestBurst),
+		debouncer:    ratelimit.NewDebouncer(DefaultDebounceInterval),
+		lockout:      ratelimit.NewLockout(DefaultAuthAttempts, DefaultAuthLockout),
+		shutdownCh:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.macros == nil {
+		s.macros, _ = macro.NewStore("")
+	}
+	if s.match == nil {
+		s.match = match.NewTracker()
 	}
+	if s.control == nil {
+		s.control = control.New()
+	}
+	if s.audit == nil {
+		s.audit, _ = audit.Open("")
+	}
+	pool.OnConnect(s.watchCommands)
+
+	s.stopTimer = time.AfterFunc(420 /* blaze it */, s.stopInactive)
+	s.stopTimer.Stop()
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	go s.watchSessions(ctx)
+	if s.refbox != nil {
+		go s.refbox.Run(ctx, s.handleRefBoxCommand)
+	}
+	return s
+}
+
+// register registers the endpoints of s on handler.
+// endpoint returns the path, on which an endpoint is registered.
+func (s *server) register(handler HandleFuncer, endpoint func(ep string) string) {
 	for ep, f := range map[string]http.HandlerFunc{
-		"/" + AuthEndpoint: s.handleAuth,
+		AuthEndpoint: s.handleAuth,
 
-		"/" + StateEndpoint: s.handleState,
+		StateEndpoint: s.handleState,
 
-		"/" + CommandEndpoint: s.makeTRCSendHandler(func(ctx context.Context, trcConn *trcapi.Conn, dec *json.Decoder) error {
+		CommandEndpoint: s.makeTRCSendHandler("command", func(ctx context.Context, _ *session, trcConn *trcapi.Conn, dec *json.Decoder) error {
 			var cmd api.Command
 			if err := dec.Decode(&cmd); err != nil {
 				return errors.Wrap(err, "failed to decode request body")
@@ -406,15 +917,34 @@ func RegisterHandlers(pool *trcapi.Pool, handler HandleFuncer) {
 			if cmd == "" {
 				return nil
 			}
+			if s.getMode() == ModeRefBox {
+				return errRefBoxMode
+			}
 
 			zap.L().Info("Received command", zap.String("command", string(cmd)))
+			if cmd.IsRelative() {
+				tc, err := trcConn.State(ctx).TeamColor()
+				if err != nil {
+					return errors.Wrap(err, "failed to determine team color")
+				}
+
+				rel := cmd
+				cmd, err = cmd.Resolve(tc)
+				if err != nil {
+					return err
+				}
+				zap.L().Info("Resolved command",
+					zap.String("relative_command", string(rel)),
+					zap.String("command", string(cmd)),
+				)
+			}
 			if err := trcConn.SetCommand(ctx, cmd); err != nil {
 				return errors.Wrap(err, "failed to send command to TRC")
 			}
 			return nil
 		}),
 
-		"/" + TurtleEndpoint: s.makeTRCSendHandler(func(ctx context.Context, trcConn *trcapi.Conn, dec *json.Decoder) error {
+		TurtleEndpoint: s.makeTRCSendHandler("turtles", func(ctx context.Context, _ *session, trcConn *trcapi.Conn, dec *json.Decoder) error {
 
 			var st map[string]*api.TurtleState
 			if err := dec.Decode(&st); err != nil {
@@ -430,22 +960,55 @@ func RegisterHandlers(pool *trcapi.Pool, handler HandleFuncer) {
 			}
 			return nil
 		}),
+
+		MacrosEndpoint: s.handleMacros,
+
+		MacroRunEndpoint: s.makeTRCSendHandler("macro_run", s.runMacro),
+
+		MacroCancelEndpoint: s.handleMacroCancel,
+
+		MatchEndpoint: s.handleMatch,
+
+		MatchResetEndpoint: s.handleMatchReset,
+
+		ModeEndpoint: s.handleMode,
+
+		AuditEndpoint: s.handleAudit,
+
+		OperatorsEndpoint: s.handleOperators,
+
+		ReloadEndpoint: s.handleReload,
+
+		ControlEndpoint: s.handleControl,
+
+		ControlRequestEndpoint: s.makeControlHandler("control_request", s.control.Request),
+
+		ControlAcceptEndpoint: s.makeControlHandler("control_accept", func(c control.Client) error {
+			return s.control.Accept(c.Session)
+		}),
+
+		ControlDenyEndpoint: s.makeControlHandler("control_deny", func(c control.Client) error {
+			return s.control.Deny(c.Session)
+		}),
+
+		ControlReleaseEndpoint: s.makeControlHandler("control_release", func(c control.Client) error {
+			return s.control.Release(c.Session)
+		}),
 	} {
 		hdl := f
-		handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
-			stopTimerMu.Lock()
-			activeConns++
-			stopTimer.Stop()
-			stopTimerMu.Unlock()
+		handler.HandleFunc("/"+endpoint(ep), func(w http.ResponseWriter, r *http.Request) {
+			if !s.handleCORS(w, r) {
+				return
+			}
+			if !s.begin() {
+				http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			defer s.requests.Done()
 
+			s.acquire()
 			hdl(w, r)
-
-			stopTimerMu.Lock()
-			activeConns--
-			if activeConns == 0 {
-				stopTimer.Reset(inactivityTimeout)
-			}
-			stopTimerMu.Unlock()
+			s.release()
 		})
 	}
 }
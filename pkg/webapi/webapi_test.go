@@ -0,0 +1,263 @@
+package webapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/audit"
+	"github.com/rvolosatovs/turtlitto/pkg/control"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	. "github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testToken = "test"
+	timeout   = time.Second
+)
+
+// newTestPool returns a *trcapi.Pool of connections to test TRCs.
+// The test TRCs are sent on trcCh and the state messages received by them on msgCh.
+func newTestPool(trcCh chan<- *trctest.Conn, msgCh chan<- *api.Message) *trcapi.Pool {
+	return trcapi.NewPool(func() (*trcapi.Conn, func(), error) {
+		srrsIn, trcOut := io.Pipe()
+		trcIn, srrsOut := io.Pipe()
+
+		trc := trctest.Connect(trcOut, trcIn,
+			trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+			trctest.WithHandler(api.MessageTypePing, trctest.DefaultPingHandler),
+			trctest.WithHandler(api.MessageTypeState, func(msg *api.Message) (*api.Message, error) {
+				msgCh <- msg
+				return trctest.DefaultStateHandler(msg)
+			}),
+		)
+		go trc.SendHandshake(&api.Handshake{
+			Version: trcapi.DefaultVersion,
+			Token:   testToken,
+		})
+
+		conn, err := trcapi.Connect(trcapi.DefaultVersion, srrsOut, srrsIn)
+		if err != nil {
+			return nil, nil, err
+		}
+		trcCh <- trc
+		return conn, func() {
+			conn.Close()
+			trc.Close()
+			srrsIn.Close()
+			trcIn.Close()
+		}, nil
+	})
+}
+
+// testTRC is a pool of connections to test TRCs.
+type testTRC struct {
+	*trcapi.Pool
+
+	// trcCh receives the test TRCs once they connect.
+	trcCh chan *trctest.Conn
+
+	// msgCh receives the state messages received by the test TRCs.
+	msgCh chan *api.Message
+}
+
+// newTestTRC returns a new *testTRC.
+func newTestTRC() *testTRC {
+	trc := &testTRC{
+		trcCh: make(chan *trctest.Conn, 1),
+		msgCh: make(chan *api.Message, 16),
+	}
+	trc.Pool = newTestPool(trc.trcCh, trc.msgCh)
+	return trc
+}
+
+// authenticate authenticates at endpoint ep of h using the TRC token, waits for the test TRC to connect
+// and returns the session key.
+func (trc *testTRC) authenticate(t *testing.T, h http.Handler, ep string) string {
+	key := authenticateAt(t, h, ep, "", testToken)
+	select {
+	case <-trc.trcCh:
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for TRC to connect")
+	}
+	return key
+}
+
+// testServer is the web API of a test TRC.
+type testServer struct {
+	*testTRC
+
+	mux *http.ServeMux
+	web *Server
+
+	// key is the key of a session authenticated using the TRC token.
+	key string
+}
+
+// newTestServer registers the web API configured by opts on a new *http.ServeMux
+// and authenticates a session using the TRC token.
+// The returned *testServer must be closed once the test is done.
+func newTestServer(t *testing.T, opts ...Option) *testServer {
+	ts := &testServer{
+		testTRC: newTestTRC(),
+		mux:     http.NewServeMux(),
+	}
+	ts.web = RegisterHandlers(ts.Pool, ts.mux, opts...)
+	ts.key = ts.authenticate(t, ts.mux, AuthEndpoint)
+	return ts
+}
+
+// do serves a request with method and body to endpoint ep of h and returns the recorded response.
+// The request carries the session key, if it is not empty.
+func do(h http.Handler, method, ep, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/"+ep, strings.NewReader(body))
+	if key != "" {
+		req.SetBasicAuth("", key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// authenticate authenticates at h as operator using password and returns the session key.
+func authenticate(t *testing.T, h http.Handler, operator, password string) string {
+	return authenticateAt(t, h, AuthEndpoint, operator, password)
+}
+
+// authenticateAt authenticates at endpoint ep of h as operator using password and returns the session key.
+func authenticateAt(t *testing.T, h http.Handler, ep, operator, password string) string {
+	req := httptest.NewRequest(http.MethodGet, "/"+ep, nil)
+	req.SetBasicAuth(operator, password)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Failed to authenticate as `%s`: %d %s", operator, rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+// openState opens a WebSocket on StateEndpoint of srv and sends key on it.
+// The initial state is read before openState returns.
+func openState(t *testing.T, srv *httptest.Server, key string) *websocket.Conn {
+	wsConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/"+StateEndpoint, nil)
+	if err != nil {
+		t.Fatalf("Failed to open WebSocket: %s", err)
+	}
+	if err := wsConn.WriteJSON(key); err != nil {
+		t.Fatalf("Failed to send session key: %s", err)
+	}
+	if _, err := readState(wsConn); err != nil {
+		t.Fatalf("Failed to read initial state: %s", err)
+	}
+	return wsConn
+}
+
+// readState reads the next State from wsConn.
+func readState(wsConn *websocket.Conn) (*State, error) {
+	if err := wsConn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	st := &State{}
+	if err := wsConn.ReadJSON(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+//Test_items: checkSession(), expireSessions() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestSessionExpiry(t *testing.T) {
+	a := assert.New(t)
+
+	const sessionTimeout = time.Second
+
+	timeouts := DefaultTimeouts
+	timeouts.Session = sessionTimeout
+
+	ts := newTestServer(t, WithTimeouts(timeouts))
+	defer ts.Close()
+
+	mux, key, msgCh := ts.mux, ts.key, ts.msgCh
+	idle := authenticate(t, mux, "", testToken)
+
+	a.Equal(http.StatusOK, do(mux, http.MethodPost, CommandEndpoint, key, `"stop"`).Code)
+	<-msgCh
+
+	// Sessions used by requests do not expire.
+	for deadline := time.Now().Add(2 * sessionTimeout); time.Now().Before(deadline); time.Sleep(sessionTimeout / 5) {
+		if !a.Equal(http.StatusOK, do(mux, http.MethodGet, ControlEndpoint, key, "").Code) {
+			t.FailNow()
+		}
+	}
+	a.Equal(http.StatusUnauthorized, do(mux, http.MethodGet, ControlEndpoint, idle, "").Code)
+
+	rec := do(mux, http.MethodGet, ControlEndpoint, key, "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var st control.State
+		a.NoError(json.NewDecoder(rec.Body).Decode(&st))
+		a.NotNil(st.Holder)
+	}
+
+	// Expired sessions release control without any other request being made.
+	time.Sleep(2*sessionTimeout + time.Second)
+
+	viewer := authenticate(t, mux, "", testToken)
+	a.Equal(http.StatusUnauthorized, do(mux, http.MethodGet, ControlEndpoint, key, "").Code)
+
+	rec = do(mux, http.MethodGet, ControlEndpoint, viewer, "")
+	if a.Equal(http.StatusOK, rec.Code) {
+		var st control.State
+		a.NoError(json.NewDecoder(rec.Body).Decode(&st))
+		a.Nil(st.Holder)
+	}
+}
+
+//Test_items: stopInactive(), release() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestInactivityStop(t *testing.T) {
+	a := assert.New(t)
+
+	timeouts := DefaultTimeouts
+	timeouts.Inactivity = 100 * time.Millisecond
+
+	ts := newTestServer(t, WithTimeouts(timeouts))
+	defer ts.Close()
+
+	mux, key, msgCh := ts.mux, ts.key, ts.msgCh
+
+	select {
+	case msg := <-msgCh:
+		var st api.State
+		a.NoError(json.Unmarshal(msg.Payload, &st))
+		a.Equal(api.CommandStop, st.Command)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for TRC to be stopped")
+	}
+
+	// The stop command is recorded in the audit log on behalf of SRRS, once TRC acknowledges it.
+	var entries []*audit.Entry
+	for deadline := time.Now().Add(timeout); len(entries) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		rec := do(mux, http.MethodGet, AuditEndpoint+"?operator=srrs", key, "")
+		if !a.Equal(http.StatusOK, rec.Code) {
+			t.FailNow()
+		}
+		a.NoError(json.NewDecoder(rec.Body).Decode(&entries))
+	}
+	if a.NotEmpty(entries) {
+		a.Equal("inactivity_stop", entries[0].Action)
+		a.JSONEq(`"stop"`, string(entries[0].Request))
+		a.Empty(entries[0].Error)
+	}
+}
@@ -0,0 +1,428 @@
+// Package webclient implements a client of the web API.
+package webclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/deepcopy"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/webapi"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultTimeout is the default timeout of requests to SRRS.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultReconnectInterval is the default interval between reconnection attempts.
+	DefaultReconnectInterval = time.Second
+)
+
+// ErrNotAuthenticated represents an error, which occurs when a request requiring
+// a session is made before Authenticate succeeded.
+var ErrNotAuthenticated = errors.New("not authenticated")
+
+// Client is a client of SRRS web API.
+// Client is safe for concurrent use by multiple goroutines.
+type Client struct {
+	httpClient        *http.Client
+	dialer            *websocket.Dialer
+	reconnectInterval time.Duration
+	operator          string
+	trc               string
+
+	httpURL *url.URL
+	wsURL   *url.URL
+
+	mu    sync.RWMutex
+	token string
+	key   string
+}
+
+// Option represents a Client option.
+type Option func(*Client)
+
+// WithHTTPClient allows to specify the *http.Client used for requests.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithDialer allows to specify the *websocket.Dialer used to open WebSockets.
+func WithDialer(d *websocket.Dialer) Option {
+	return func(c *Client) {
+		c.dialer = d
+	}
+}
+
+// WithReconnectInterval allows to specify the interval between reconnection attempts.
+func WithReconnectInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.reconnectInterval = d
+	}
+}
+
+// WithOperator allows to specify the name of the operator recorded in the audit log of SRRS.
+func WithOperator(name string) Option {
+	return func(c *Client) {
+		c.operator = name
+	}
+}
+
+// WithTRC allows to specify the name of the TRC to control, if SRRS manages several TRCs.
+// By default, the only TRC managed by SRRS is controlled.
+func WithTRC(name string) Option {
+	return func(c *Client) {
+		c.trc = name
+	}
+}
+
+// New returns a new *Client of SRRS at addr.
+// addr is a URL with scheme http or https, e.g. http://localhost:4242.
+func New(addr string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse address")
+	}
+
+	wsURL := *u
+	switch u.Scheme {
+	case "http":
+		wsURL.Scheme = "ws"
+	case "https":
+		wsURL.Scheme = "wss"
+	default:
+		return nil, errors.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	c := &Client{
+		httpClient: &http.Client{
+			Timeout: DefaultTimeout,
+		},
+		dialer: &websocket.Dialer{
+			HandshakeTimeout: DefaultTimeout,
+		},
+		reconnectInterval: DefaultReconnectInterval,
+		httpURL:           u,
+		wsURL:             &wsURL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// endpoint returns the URL of endpoint ep relative to base.
+func endpoint(base *url.URL, ep string) string {
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + ep
+	return u.String()
+}
+
+// endpoint returns the URL of endpoint ep of the TRC controlled by c relative to base.
+func (c *Client) endpoint(base *url.URL, ep string) string {
+	if c.trc != "" {
+		ep = webapi.TRCEndpoint(c.trc, ep)
+	}
+	return endpoint(base, ep)
+}
+
+// responseError returns an error describing a non-OK resp.
+func responseError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(resp.Body)
+	return errors.Errorf("SRRS returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
+
+// Authenticate retrieves a session key from SRRS using TRC token or operator password tok.
+// tok is remembered and used to re-authenticate if the session is lost.
+func (c *Client) Authenticate(tok string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authenticate(tok)
+}
+
+// authenticate implements Authenticate.
+// authenticate must be called with c.mu held.
+func (c *Client) authenticate(tok string) error {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint(c.httpURL, webapi.AuthEndpoint), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.operator, tok)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send authentication request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read session key")
+	}
+	c.token = tok
+	c.key = string(b)
+	return nil
+}
+
+// reauthenticate authenticates using the last token, if the session key is still oldKey.
+func (c *Client) reauthenticate(oldKey string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.key != oldKey {
+		// Another goroutine already re-authenticated.
+		return nil
+	}
+	return c.authenticate(c.token)
+}
+
+// sessionKey returns the current session key.
+func (c *Client) sessionKey() (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.key == "" {
+		return "", ErrNotAuthenticated
+	}
+	return c.key, nil
+}
+
+// send POSTs v encoded as JSON to endpoint ep.
+// send re-authenticates and retries once if the session is rejected by SRRS.
+func (c *Client) send(ep string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	for retry := true; ; retry = false {
+		key, err := c.sessionKey()
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, c.endpoint(c.httpURL, ep), bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth("", key)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "failed to send request")
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			resp.Body.Close()
+			return nil
+
+		case retry && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusMethodNotAllowed):
+			resp.Body.Close()
+			zap.L().Debug("Session rejected, re-authenticating...", zap.Int("status", resp.StatusCode))
+			if err := c.reauthenticate(key); err != nil {
+				return errors.Wrap(err, "failed to re-authenticate")
+			}
+			continue
+		}
+
+		err = responseError(resp)
+		resp.Body.Close()
+		return err
+	}
+}
+
+// SendCommand sends cmd to SRRS.
+func (c *Client) SendCommand(cmd api.Command) error {
+	return c.send(webapi.CommandEndpoint, cmd)
+}
+
+// SetTurtles sends the turtle states st to SRRS.
+func (c *Client) SetTurtles(st map[string]*api.TurtleState) error {
+	return c.send(webapi.TurtleEndpoint, st)
+}
+
+// RequestControl requests control from the current controller.
+// Control is granted immediately, if no session holds it.
+func (c *Client) RequestControl() error {
+	return c.send(webapi.ControlRequestEndpoint, nil)
+}
+
+// AcceptControl hands control over to the session, which requested it.
+func (c *Client) AcceptControl() error {
+	return c.send(webapi.ControlAcceptEndpoint, nil)
+}
+
+// DenyControl denies the pending control request.
+func (c *Client) DenyControl() error {
+	return c.send(webapi.ControlDenyEndpoint, nil)
+}
+
+// ReleaseControl releases control.
+func (c *Client) ReleaseControl() error {
+	return c.send(webapi.ControlReleaseEndpoint, nil)
+}
+
+// Reload requests SRRS to reload its configuration.
+// Reload requires the admin role.
+func (c *Client) Reload() error {
+	return c.send(webapi.ReloadEndpoint, nil)
+}
+
+// openState opens a WebSocket on StateEndpoint and sends the session key on it.
+func (c *Client) openState() (*websocket.Conn, error) {
+	key, err := c.sessionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wsConn, _, err := c.dialer.Dial(c.endpoint(c.wsURL, webapi.StateEndpoint), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open WebSocket")
+	}
+
+	if err := wsConn.WriteJSON(key); err != nil {
+		wsConn.Close()
+		return nil, errors.Wrap(err, "failed to send session key")
+	}
+	return wsConn, nil
+}
+
+// sessionRejected reports whether err is the error, with which SRRS closes the WebSocket
+// on StateEndpoint if the session key is not valid.
+func sessionRejected(err error) bool {
+	ce, ok := err.(*websocket.CloseError)
+	return ok && (ce.Text == webapi.ErrInvalidSessionKey.Error() || ce.Text == webapi.ErrAuthenticateFirst.Error())
+}
+
+// WatchState opens a subscription to the state of SRRS.
+// WatchState returns a read-only channel, on which the complete state is sent
+// every time SRRS reports a change. States older than the last one received are dropped.
+// If the WebSocket is closed, WatchState reconnects automatically using the current session
+// and re-authenticates only if SRRS rejects it. The channel is closed once ctx is done.
+func (c *Client) WatchState(ctx context.Context) (<-chan *api.State, error) {
+	logger := zap.L()
+
+	wsConn, err := c.openState()
+	if err != nil {
+		return nil, err
+	}
+
+	var connMu sync.Mutex
+	go func() {
+		<-ctx.Done()
+		connMu.Lock()
+		wsConn.Close()
+		connMu.Unlock()
+	}()
+
+	ch := make(chan *api.State)
+	go func() {
+		defer close(ch)
+
+		st := &api.State{}
+		for {
+			var rejected bool
+
+			// The sequence numbers start over on every connection.
+			var lastSeq *uint64
+			for {
+				_, b, err := wsConn.ReadMessage()
+				if err != nil {
+					logger.Debug("Failed to read state", zap.Error(err))
+					rejected = sessionRejected(err)
+					break
+				}
+
+				var frame struct {
+					Seq *uint64 `json:"seq"`
+				}
+				if err := json.Unmarshal(b, &frame); err != nil {
+					logger.Warn("Failed to decode state", zap.Error(err))
+					break
+				}
+				if frame.Seq != nil {
+					if lastSeq != nil && *frame.Seq < *lastSeq {
+						logger.Debug("Ignoring stale state", zap.Uint64("seq", *frame.Seq), zap.Uint64("last_seq", *lastSeq))
+						continue
+					}
+					lastSeq = frame.Seq
+				}
+
+				next := deepcopy.Copy(st).(*api.State)
+				if err := json.Unmarshal(b, next); err != nil {
+					logger.Warn("Failed to decode state", zap.Error(err))
+					break
+				}
+				st = next
+
+				select {
+				case ch <- deepcopy.Copy(st).(*api.State):
+				case <-ctx.Done():
+					return
+				}
+			}
+			wsConn.Close()
+
+		reconnect:
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(c.reconnectInterval):
+				}
+
+				if rejected {
+					key, err := c.sessionKey()
+					if err != nil {
+						return
+					}
+
+					logger.Debug("Session rejected, re-authenticating...")
+					if err := c.reauthenticate(key); err != nil {
+						logger.Warn("Failed to re-authenticate", zap.Error(err))
+						continue
+					}
+					rejected = false
+				}
+
+				logger.Debug("Reconnecting...")
+				conn, err := c.openState()
+				if err != nil {
+					logger.Warn("Failed to reconnect", zap.Error(err))
+					continue
+				}
+
+				connMu.Lock()
+				wsConn = conn
+				connMu.Unlock()
+
+				select {
+				case <-ctx.Done():
+					// ctx may have been done before wsConn was replaced.
+					conn.Close()
+					return
+				default:
+					break reconnect
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,894 @@
+package webclient_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/turtlitto/pkg/api"
+	"github.com/rvolosatovs/turtlitto/pkg/api/apitest"
+	"github.com/rvolosatovs/turtlitto/pkg/credentials"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi"
+	"github.com/rvolosatovs/turtlitto/pkg/trcapi/trctest"
+	"github.com/rvolosatovs/turtlitto/pkg/webapi"
+	. "github.com/rvolosatovs/turtlitto/pkg/webclient"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testToken = "test"
+	timeout   = time.Second
+)
+
+// newTestPool returns a *trcapi.Pool of connections to test TRCs.
+// The test TRCs are sent on trcCh and the state messages received by them on msgCh.
+func newTestPool(trcCh chan<- *trctest.Conn, msgCh chan<- *api.Message) *trcapi.Pool {
+	return trcapi.NewPool(func() (*trcapi.Conn, func(), error) {
+		srrsIn, trcOut := io.Pipe()
+		trcIn, srrsOut := io.Pipe()
+
+		trc := trctest.Connect(trcOut, trcIn,
+			trctest.WithHandler(api.MessageTypeHandshake, trctest.DefaultHandshakeHandler),
+			trctest.WithHandler(api.MessageTypePing, trctest.DefaultPingHandler),
+			trctest.WithHandler(api.MessageTypeState, func(msg *api.Message) (*api.Message, error) {
+				msgCh <- msg
+				return trctest.DefaultStateHandler(msg)
+			}),
+		)
+		go trc.SendHandshake(&api.Handshake{
+			Version: trcapi.DefaultVersion,
+			Token:   testToken,
+		})
+
+		conn, err := trcapi.Connect(trcapi.DefaultVersion, srrsOut, srrsIn)
+		if err != nil {
+			return nil, nil, err
+		}
+		trcCh <- trc
+		return conn, func() {
+			conn.Close()
+			trc.Close()
+			srrsIn.Close()
+			trcIn.Close()
+		}, nil
+	})
+}
+
+//Test_items: Authenticate(), SendCommand(), SetTurtles(), WatchState() in webclient.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestClient(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 1)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	operators, err := credentials.NewStore("")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(operators.Put("viewer", "secret", credentials.RoleViewer))
+	a.NoError(operators.Put("operator", "secret", credentials.RoleOperator))
+
+	mux := http.NewServeMux()
+	webapi.RegisterHandlers(pool, mux, webapi.WithOperators(operators))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cl, err := New(srv.URL)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	err = cl.SendCommand(api.CommandStart)
+	a.Equal(ErrNotAuthenticated, err)
+
+	err = cl.Authenticate("invalid")
+	a.Error(err)
+
+	viewer, err := New(srv.URL, WithOperator("viewer"))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Error(viewer.Authenticate("invalid"))
+	a.NoError(viewer.Authenticate("secret"))
+	a.Error(viewer.SendCommand(api.CommandStart))
+
+	err = cl.Authenticate(testToken)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	trc := <-trcCh
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stCh, err := cl.WatchState(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	select {
+	case st := <-stCh:
+		a.Len(st.Turtles, apitest.MaxTurtles)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for initial state")
+	}
+
+	err = trc.SendState(&api.State{
+		Command: api.CommandGoIn,
+	})
+	a.NoError(err)
+
+	select {
+	case st := <-stCh:
+		a.Equal(api.CommandGoIn, st.Command)
+		a.Len(st.Turtles, apitest.MaxTurtles)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for state update")
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cl.SendCommand(api.CommandStop)
+	}()
+
+	select {
+	case msg := <-msgCh:
+		var st api.State
+		a.NoError(json.Unmarshal(msg.Payload, &st))
+		a.Equal(api.CommandStop, st.Command)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for command to arrive at TRC")
+	}
+	a.NoError(<-errCh)
+
+	other, err := New(srv.URL, WithOperator("operator"))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(other.Authenticate("secret"))
+	a.Error(other.SendCommand(api.CommandStart))
+	a.Error(other.AcceptControl())
+
+	a.NoError(other.RequestControl())
+	a.NoError(cl.AcceptControl())
+	a.Error(cl.SendCommand(api.CommandStart))
+
+	a.NoError(cl.RequestControl())
+	a.NoError(other.ReleaseControl())
+
+	go func() {
+		errCh <- cl.SetTurtles(map[string]*api.TurtleState{
+			"3": {Role: api.RoleGoalkeeper},
+		})
+	}()
+
+	select {
+	case msg := <-msgCh:
+		var st api.State
+		a.NoError(json.Unmarshal(msg.Payload, &st))
+		if a.Contains(st.Turtles, "3") {
+			a.Equal(api.RoleGoalkeeper, st.Turtles["3"].Role)
+		}
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for turtle state to arrive at TRC")
+	}
+	a.NoError(<-errCh)
+
+	cancel()
+	for range stCh {
+	}
+}
+
+//Test_items: Authenticate(), SendCommand() in webclient.go, rate limiting in webapi
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestClientRateLimit(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 1)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	mux := http.NewServeMux()
+	webapi.RegisterHandlers(pool, mux,
+		webapi.WithRequestRateLimit(0.01, 2),
+		webapi.WithDebounceInterval(time.Minute),
+		webapi.WithAuthLockout(2, time.Minute),
+	)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cl, err := New(srv.URL)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	if !a.NoError(cl.Authenticate(testToken)) {
+		t.FailNow()
+	}
+	<-trcCh
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cl.SendCommand(api.CommandStop)
+	}()
+
+	select {
+	case <-msgCh:
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for command to arrive at TRC")
+	}
+	a.NoError(<-errCh)
+
+	// Identical consecutive command is ignored.
+	err = cl.SendCommand(api.CommandStop)
+	if a.Error(err) {
+		a.Contains(err.Error(), "409")
+	}
+	select {
+	case <-msgCh:
+		t.Error("Debounced command arrived at TRC")
+	default:
+	}
+
+	err = cl.SendCommand(api.CommandStart)
+	if a.Error(err) {
+		a.Contains(err.Error(), "429")
+	}
+
+	a.Error(cl.Authenticate("invalid"))
+	a.Error(cl.Authenticate("invalid"))
+	err = cl.Authenticate(testToken)
+	if a.Error(err) {
+		a.Contains(err.Error(), "429")
+	}
+}
+
+//Test_items: origin policy in webapi
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestAllowedOrigins(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 1)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	const allowed = "http://ui.example.com"
+
+	mux := http.NewServeMux()
+	webapi.RegisterHandlers(pool, mux, webapi.WithAllowedOrigins(allowed))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	newRequest := func(method, ep, origin string) *http.Request {
+		req, err := http.NewRequest(method, srv.URL+"/"+ep, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %s", err)
+		}
+		req.Header.Set("Origin", origin)
+		return req
+	}
+
+	req := newRequest(http.MethodOptions, webapi.CommandEndpoint, allowed)
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp, err := http.DefaultClient.Do(req)
+	if a.NoError(err) {
+		resp.Body.Close()
+		a.Equal(http.StatusNoContent, resp.StatusCode)
+		a.Equal(allowed, resp.Header.Get("Access-Control-Allow-Origin"))
+		a.Contains(resp.Header.Get("Access-Control-Allow-Methods"), http.MethodPost)
+		a.Contains(resp.Header.Get("Access-Control-Allow-Headers"), "Authorization")
+	}
+
+	resp, err = http.DefaultClient.Do(newRequest(http.MethodGet, webapi.AuthEndpoint, "http://evil.example.com"))
+	if a.NoError(err) {
+		resp.Body.Close()
+		a.Equal(http.StatusForbidden, resp.StatusCode)
+		a.Empty(resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+
+	req = newRequest(http.MethodGet, webapi.AuthEndpoint, allowed)
+	req.SetBasicAuth("", testToken)
+	resp, err = http.DefaultClient.Do(req)
+	if a.NoError(err) {
+		resp.Body.Close()
+		a.Equal(http.StatusOK, resp.StatusCode)
+		a.Equal(allowed, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+	<-trcCh
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/" + webapi.StateEndpoint
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"http://evil.example.com"}})
+	a.Error(err)
+	if a.NotNil(resp) {
+		a.Equal(http.StatusForbidden, resp.StatusCode)
+	}
+}
+
+//Test_items: Reload() in webclient.go, Server.Apply() in webapi.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestReload(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 1)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	operators, err := credentials.NewStore("")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(operators.Put("operator", "secret", credentials.RoleOperator))
+
+	const origin = "http://ui.example.com"
+
+	var web *webapi.Server
+	reloaded := 0
+	reloadErr := errors.New("invalid configuration")
+
+	mux := http.NewServeMux()
+	web = webapi.RegisterHandlers(pool, mux,
+		webapi.WithOperators(operators),
+		webapi.WithReloadFunc(func() error {
+			if reloaded++; reloaded > 1 {
+				return reloadErr
+			}
+			web.Apply(webapi.WithAllowedOrigins(origin))
+			return nil
+		}),
+	)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	originAllowed := func() bool {
+		req, err := http.NewRequest(http.MethodOptions, srv.URL+"/"+webapi.CommandEndpoint, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %s", err)
+		}
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		resp, err := http.DefaultClient.Do(req)
+		if !a.NoError(err) {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusNoContent
+	}
+	a.False(originAllowed())
+
+	op, err := New(srv.URL, WithOperator("operator"))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(op.Authenticate("secret"))
+	a.Error(op.Reload())
+	a.Equal(0, reloaded)
+
+	cl, err := New(srv.URL)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	if !a.NoError(cl.Authenticate(testToken)) {
+		t.FailNow()
+	}
+	<-trcCh
+
+	a.NoError(cl.Reload())
+	a.Equal(1, reloaded)
+	a.True(originAllowed())
+
+	err = cl.Reload()
+	if a.Error(err) {
+		a.Contains(err.Error(), reloadErr.Error())
+	}
+	a.True(originAllowed())
+}
+
+//Test_items: Server.Shutdown() in shutdown.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestShutdown(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 1)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	mux := http.NewServeMux()
+	web := webapi.RegisterHandlers(pool, mux, webapi.WithShutdownCommand(api.CommandStop))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/"+webapi.AuthEndpoint, nil)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	req.SetBasicAuth("", testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	key, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !a.NoError(err) || !a.Equal(http.StatusOK, resp.StatusCode) {
+		t.FailNow()
+	}
+	<-trcCh
+
+	wsConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/"+webapi.StateEndpoint, nil)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer wsConn.Close()
+
+	a.NoError(wsConn.WriteJSON(string(key)))
+	_, _, err = wsConn.ReadMessage()
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		errCh <- web.Shutdown(ctx)
+	}()
+
+	a.NoError(wsConn.SetReadDeadline(time.Now().Add(timeout)))
+	_, _, err = wsConn.ReadMessage()
+	if a.IsType(&websocket.CloseError{}, err) {
+		a.Equal(websocket.CloseGoingAway, err.(*websocket.CloseError).Code)
+		a.Contains(err.(*websocket.CloseError).Text, "shutting down")
+	}
+
+	select {
+	case msg := <-msgCh:
+		var st api.State
+		a.NoError(json.Unmarshal(msg.Payload, &st))
+		a.Equal(api.CommandStop, st.Command)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for shutdown command")
+	}
+
+	select {
+	case err := <-errCh:
+		a.NoError(err)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for Shutdown to return")
+	}
+
+	cl, err := New(srv.URL)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	err = cl.Authenticate(testToken)
+	if a.Error(err) {
+		a.Contains(err.Error(), "503")
+	}
+}
+
+//Test_items: WithTRC() in webclient.go, RegisterTRCHandlers() in trcs.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestMultiTRC(t *testing.T) {
+	a := assert.New(t)
+
+	names := []string{"field1", "field2"}
+
+	trcChs := make(map[string]chan *trctest.Conn, len(names))
+	msgChs := make(map[string]chan *api.Message, len(names))
+	trcs := make([]webapi.TRC, 0, len(names))
+	for _, name := range names {
+		trcChs[name] = make(chan *trctest.Conn, 1)
+		msgChs[name] = make(chan *api.Message, 1)
+
+		pool := newTestPool(trcChs[name], msgChs[name])
+		defer pool.Close()
+
+		trcs = append(trcs, webapi.TRC{
+			Name: name,
+			Pool: pool,
+		})
+	}
+
+	mux := http.NewServeMux()
+	webapi.RegisterTRCHandlers(trcs, mux)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/" + webapi.TRCsEndpoint)
+	if a.NoError(err) {
+		var listed []string
+		a.NoError(json.NewDecoder(resp.Body).Decode(&listed))
+		resp.Body.Close()
+		a.Equal(names, listed)
+	}
+
+	resp, err = http.Get(srv.URL + "/" + webapi.AuthEndpoint)
+	if a.NoError(err) {
+		resp.Body.Close()
+		a.Equal(http.StatusNotFound, resp.StatusCode)
+	}
+
+	clients := make(map[string]*Client, len(names))
+	for _, name := range names {
+		cl, err := New(srv.URL, WithTRC(name))
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+		if !a.NoError(cl.Authenticate(testToken)) {
+			t.FailNow()
+		}
+		clients[name] = cl
+
+		select {
+		case <-trcChs[name]:
+		case <-time.After(timeout):
+			t.Fatalf("Timed out waiting for %s to connect", name)
+		}
+	}
+
+	for i, name := range names {
+		cmd := api.CommandStart
+		if i > 0 {
+			cmd = api.CommandGoIn
+		}
+		a.NoError(clients[name].SendCommand(cmd))
+
+		select {
+		case msg := <-msgChs[name]:
+			var st api.State
+			a.NoError(json.Unmarshal(msg.Payload, &st))
+			a.Equal(cmd, st.Command)
+		case <-time.After(timeout):
+			t.Fatalf("Timed out waiting for %s to receive the command", name)
+		}
+
+		for _, other := range names {
+			if other == name {
+				continue
+			}
+			select {
+			case msg := <-msgChs[other]:
+				t.Errorf("%s received a message sent to %s: %s", other, name, msg.Payload)
+			default:
+			}
+		}
+	}
+
+	// Sessions are per TRC.
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/"+webapi.TRCEndpoint(names[0], webapi.AuthEndpoint), nil)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	req.SetBasicAuth("", testToken)
+	resp, err = http.DefaultClient.Do(req)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	key, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.NoError(err)
+
+	req, err = http.NewRequest(http.MethodPost, srv.URL+"/"+webapi.TRCEndpoint(names[1], webapi.CommandEndpoint), strings.NewReader(`"stop"`))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	req.SetBasicAuth("", string(key))
+	resp, err = http.DefaultClient.Do(req)
+	if a.NoError(err) {
+		resp.Body.Close()
+		a.Equal(http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+//Test_items: WithTRC(), Reload(), SendCommand() in webclient.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestClientTRC(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 1)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	reloaded := 0
+
+	mux := http.NewServeMux()
+	webapi.RegisterTRCHandlers([]webapi.TRC{{
+		Name: "field1",
+		Pool: pool,
+	}}, mux,
+		webapi.WithDebounceInterval(time.Minute),
+		webapi.WithReloadFunc(func() error {
+			reloaded++
+			return nil
+		}),
+	)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cl, err := New(srv.URL, WithTRC("field1"))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	if !a.NoError(cl.Authenticate(testToken)) {
+		t.FailNow()
+	}
+	<-trcCh
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cl.SendCommand(api.CommandStop)
+	}()
+
+	select {
+	case <-msgCh:
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for command to arrive at TRC")
+	}
+	a.NoError(<-errCh)
+
+	err = cl.SendCommand(api.CommandStop)
+	if a.Error(err) {
+		a.Contains(err.Error(), "409")
+	}
+
+	a.NoError(cl.Reload())
+	a.Equal(1, reloaded)
+
+	other, err := New(srv.URL, WithTRC("field2"))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Error(other.Authenticate(testToken))
+}
+
+//Test_items: WatchState() in webclient.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestWatchStateSeq(t *testing.T) {
+	a := assert.New(t)
+
+	const key = "key"
+
+	// The states are sent out of order, the one with seq 1 is stale.
+	frames := []string{
+		`{"command":"start","seq":2}`,
+		`{"command":"stop","seq":1}`,
+		`{"command":"go_in","seq":3}`,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+webapi.AuthEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(key))
+	})
+	mux.HandleFunc("/"+webapi.StateEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		wsConn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to open WebSocket: %s", err)
+			return
+		}
+		defer wsConn.Close()
+
+		var k string
+		if err := wsConn.ReadJSON(&k); err != nil || k != key {
+			t.Errorf("Expected session key %s, got %s (%v)", key, k, err)
+			return
+		}
+		for _, f := range frames {
+			if err := wsConn.WriteMessage(websocket.TextMessage, []byte(f)); err != nil {
+				t.Errorf("Failed to write state: %s", err)
+				return
+			}
+		}
+		// Keep the WebSocket open until the client closes it.
+		wsConn.ReadMessage()
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cl, err := New(srv.URL)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	if !a.NoError(cl.Authenticate(testToken)) {
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stCh, err := cl.WatchState(ctx)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	for _, expected := range []api.Command{api.CommandStart, api.CommandGoIn} {
+		select {
+		case st := <-stCh:
+			a.Equal(expected, st.Command)
+		case <-time.After(timeout):
+			t.Fatalf("Timed out waiting for state with command %s", expected)
+		}
+	}
+
+	select {
+	case st := <-stCh:
+		t.Errorf("Unexpected state received: %v", st)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// newTestCertificate returns a certificate with common name cn signed by parent using parentKey.
+// If parent is nil, the certificate is a self-signed CA certificate.
+func newTestCertificate(cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+//Test_items: WithHTTPClient(), WithDialer(), Authenticate() in webclient.go
+//Input_spec: -
+//Output_spec: Pass or fail
+//Envir_needs: -
+func TestClientCertificate(t *testing.T) {
+	a := assert.New(t)
+
+	trcCh := make(chan *trctest.Conn, 1)
+	msgCh := make(chan *api.Message, 1)
+
+	pool := newTestPool(trcCh, msgCh)
+	defer pool.Close()
+
+	ca, caKey, err := newTestCertificate("test CA", nil, nil)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	operators, err := credentials.NewStore("")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.NoError(operators.Put("laptop", "", credentials.RoleOperator))
+
+	mux := http.NewServeMux()
+	webapi.RegisterHandlers(pool, mux, webapi.WithOperators(operators))
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.TLS = &tls.Config{
+		ClientCAs:  caPool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	newClient := func(cn string) *Client {
+		cert, key, err := newTestCertificate(cn, ca, caKey)
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+
+		conf := srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		conf.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  key,
+		}}
+
+		cl, err := New(srv.URL,
+			WithHTTPClient(&http.Client{
+				Timeout: timeout,
+				Transport: &http.Transport{
+					TLSClientConfig: conf,
+				},
+			}),
+			WithDialer(&websocket.Dialer{
+				HandshakeTimeout: timeout,
+				TLSClientConfig:  conf,
+			}),
+		)
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+		return cl
+	}
+
+	a.Error(newClient("stranger").Authenticate(""))
+
+	cl := newClient("laptop")
+	if !a.NoError(cl.Authenticate("")) {
+		t.FailNow()
+	}
+	<-trcCh
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cl.SendCommand(api.CommandStop)
+	}()
+
+	select {
+	case msg := <-msgCh:
+		var st api.State
+		a.NoError(json.Unmarshal(msg.Payload, &st))
+		a.Equal(api.CommandStop, st.Command)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for command to arrive at TRC")
+	}
+	a.NoError(<-errCh)
+}